@@ -0,0 +1,73 @@
+// Package geom is a small example of a Go package made usable from
+// zygo by zygo-bind. See zygo_bind.go for what it generates.
+package geom
+
+//go:generate zygo-bind -types Point,Rect -funcs Dist,Centroid,ParsePoint,Walk
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+type Point struct {
+	X float64 `json:"x" msg:"x"`
+	Y float64 `json:"y" msg:"y"`
+}
+
+type Rect struct {
+	Min Point `json:"min" msg:"min"`
+	Max Point `json:"max" msg:"max"`
+}
+
+func (p Point) String() string {
+	return fmt.Sprintf("(%g,%g)", p.X, p.Y)
+}
+
+// Scale multiplies p by k, and returns it.
+func (p *Point) Scale(k float64) *Point {
+	p.X *= k
+	p.Y *= k
+	return p
+}
+
+func (r Rect) Area() float64 {
+	return (r.Max.X - r.Min.X) * (r.Max.Y - r.Min.Y)
+}
+
+func (r Rect) Contains(p Point) bool {
+	return r.Min.X <= p.X && p.X <= r.Max.X && r.Min.Y <= p.Y && p.Y <= r.Max.Y
+}
+
+func Dist(a, b Point) float64 {
+	return math.Hypot(b.X-a.X, b.Y-a.Y)
+}
+
+// Centroid is the mean of ps, of which there must be at least one.
+func Centroid(ps ...Point) (Point, error) {
+	if len(ps) == 0 {
+		return Point{}, fmt.Errorf("centroid of no points")
+	}
+	var c Point
+	for _, p := range ps {
+		c.X += p.X
+		c.Y += p.Y
+	}
+	n := float64(len(ps))
+	return Point{X: c.X / n, Y: c.Y / n}, nil
+}
+
+// ParsePoint reads a point written as x,y.
+func ParsePoint(s string) (Point, error) {
+	var p Point
+	_, err := fmt.Sscanf(s, "%g,%g", &p.X, &p.Y)
+	return p, err
+}
+
+// Walk takes n steps from p, stopping early if ctx is done.
+func Walk(ctx context.Context, p Point, n int, step func(Point) Point) Point {
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		p = step(p)
+	}
+	return p
+}
@@ -0,0 +1,182 @@
+// Code generated by zygo-bind; DO NOT EDIT.
+
+package geom
+
+import (
+	"github.com/glycerine/zygomys/repl"
+)
+
+// ZygoBindings is to be passed to zygo.RegisterPackage.
+var ZygoBindings = &zygo.Bindings{
+	Package: "github.com/glycerine/zygomys/cmd/zygo-bind/example/geom",
+	Types: []zygo.TypeBinding{
+		{
+			Name: "point",
+			Factory: func(env *zygo.Glisp) (interface{}, error) {
+				return &Point{}, nil
+			},
+			Methods: map[string]zygo.GlispUserFunction{
+				"Scale":  zygoBindPointScale,
+				"String": zygoBindPointString,
+			},
+		},
+		{
+			Name: "rect",
+			Factory: func(env *zygo.Glisp) (interface{}, error) {
+				return &Rect{}, nil
+			},
+			Methods: map[string]zygo.GlispUserFunction{
+				"Area":     zygoBindRectArea,
+				"Contains": zygoBindRectContains,
+			},
+		},
+	},
+	Funcs: []zygo.FuncBinding{
+		{Name: "geom-dist", Fn: zygoBindDist},
+		{Name: "geom-centroid", Fn: zygoBindCentroid},
+		{Name: "geom-parse-point", Fn: zygoBindParsePoint},
+		{Name: "geom-walk", Fn: zygoBindWalk},
+	},
+}
+
+// zygoBindPointScale calls (*Point).Scale.
+func zygoBindPointScale(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+	if len(args) != 2 {
+		return zygo.SexpNull, zygo.WrongNargs
+	}
+	var recv *Point
+	if err := zygo.BindReceiver(env, name, args[0], &recv); err != nil {
+		return zygo.SexpNull, err
+	}
+	var a0 float64
+	if err := zygo.BindArg(env, name, 1, args[1], &a0); err != nil {
+		return zygo.SexpNull, err
+	}
+	var r0 *Point
+	env.CallGo(func() { r0 = recv.Scale(a0) })
+	return zygo.BindResults(env, r0)
+}
+
+// zygoBindPointString calls (*Point).String.
+func zygoBindPointString(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+	if len(args) != 1 {
+		return zygo.SexpNull, zygo.WrongNargs
+	}
+	var recv *Point
+	if err := zygo.BindReceiver(env, name, args[0], &recv); err != nil {
+		return zygo.SexpNull, err
+	}
+	var r0 string
+	env.CallGo(func() { r0 = recv.String() })
+	return zygo.BindResults(env, r0)
+}
+
+// zygoBindRectArea calls (*Rect).Area.
+func zygoBindRectArea(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+	if len(args) != 1 {
+		return zygo.SexpNull, zygo.WrongNargs
+	}
+	var recv *Rect
+	if err := zygo.BindReceiver(env, name, args[0], &recv); err != nil {
+		return zygo.SexpNull, err
+	}
+	var r0 float64
+	env.CallGo(func() { r0 = recv.Area() })
+	return zygo.BindResults(env, r0)
+}
+
+// zygoBindRectContains calls (*Rect).Contains.
+func zygoBindRectContains(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+	if len(args) != 2 {
+		return zygo.SexpNull, zygo.WrongNargs
+	}
+	var recv *Rect
+	if err := zygo.BindReceiver(env, name, args[0], &recv); err != nil {
+		return zygo.SexpNull, err
+	}
+	var a0 Point
+	if err := zygo.BindArg(env, name, 1, args[1], &a0); err != nil {
+		return zygo.SexpNull, err
+	}
+	var r0 bool
+	env.CallGo(func() { r0 = recv.Contains(a0) })
+	return zygo.BindResults(env, r0)
+}
+
+// zygoBindDist calls Dist.
+func zygoBindDist(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+	if len(args) != 2 {
+		return zygo.SexpNull, zygo.WrongNargs
+	}
+	var a0 Point
+	if err := zygo.BindArg(env, name, 0, args[0], &a0); err != nil {
+		return zygo.SexpNull, err
+	}
+	var a1 Point
+	if err := zygo.BindArg(env, name, 1, args[1], &a1); err != nil {
+		return zygo.SexpNull, err
+	}
+	var r0 float64
+	env.CallGo(func() { r0 = Dist(a0, a1) })
+	return zygo.BindResult(env, r0)
+}
+
+// zygoBindCentroid calls Centroid.
+func zygoBindCentroid(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+	var a0 []Point
+	for i := 0; i < len(args); i++ {
+		var v Point
+		if err := zygo.BindArg(env, name, i, args[i], &v); err != nil {
+			return zygo.SexpNull, err
+		}
+		a0 = append(a0, v)
+	}
+	var r0 Point
+	var err error
+	env.CallGo(func() { r0, err = Centroid(a0...) })
+	if err != nil {
+		return zygo.SexpNull, err
+	}
+	return zygo.BindResult(env, r0)
+}
+
+// zygoBindParsePoint calls ParsePoint.
+func zygoBindParsePoint(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+	if len(args) != 1 {
+		return zygo.SexpNull, zygo.WrongNargs
+	}
+	var a0 string
+	if err := zygo.BindArg(env, name, 0, args[0], &a0); err != nil {
+		return zygo.SexpNull, err
+	}
+	var r0 Point
+	var err error
+	env.CallGo(func() { r0, err = ParsePoint(a0) })
+	if err != nil {
+		return zygo.SexpNull, err
+	}
+	return zygo.BindResult(env, r0)
+}
+
+// zygoBindWalk calls Walk.
+func zygoBindWalk(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+	if len(args) != 3 {
+		return zygo.SexpNull, zygo.WrongNargs
+	}
+	a0 := env.Context()
+	var a1 Point
+	if err := zygo.BindArg(env, name, 0, args[0], &a1); err != nil {
+		return zygo.SexpNull, err
+	}
+	var a2 int
+	if err := zygo.BindArg(env, name, 1, args[1], &a2); err != nil {
+		return zygo.SexpNull, err
+	}
+	var a3 func(Point) Point
+	if err := zygo.BindArg(env, name, 2, args[2], &a3); err != nil {
+		return zygo.SexpNull, err
+	}
+	var r0 Point
+	env.CallGo(func() { r0 = Walk(a0, a1, a2, a3) })
+	return zygo.BindResult(env, r0)
+}
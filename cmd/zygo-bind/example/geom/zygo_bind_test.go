@@ -0,0 +1,56 @@
+package geom
+
+import (
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+	"github.com/glycerine/zygomys/repl"
+)
+
+func TestGeneratedBindings(t *testing.T) {
+
+	cv.Convey(`Given the bindings zygo-bind generated for geom,`+
+		` scripts should make its records, call its funcs,`+
+		` and call methods through _method.`, t, func() {
+
+		cv.So(zygo.RegisterPackage(ZygoBindings), cv.ShouldBeNil)
+		cv.So(zygo.RegisterPackage(ZygoBindings), cv.ShouldNotBeNil)
+
+		env := zygo.NewGlisp()
+		env.StandardSetup()
+
+		x, err := env.EvalString(`(geom-dist (point x:0.0 y:0.0) (point x:3.0 y:4.0))`)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(x.(zygo.SexpFloat).Val, cv.ShouldEqual, 5.0)
+
+		x, err = env.EvalString(`(:y (geom-centroid (point x:1.0 y:2.0) (point x:3.0 y:6.0)))`)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(x.(zygo.SexpFloat).Val, cv.ShouldEqual, 4.0)
+
+		_, err = env.EvalString(`(geom-centroid)`)
+		env.Clear()
+		cv.So(err.Error(), cv.ShouldEqual, "Error calling 'geom-centroid': centroid of no points")
+
+		x, err = env.EvalString(`(:x (geom-parse-point "1.5,2"))`)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(x.(zygo.SexpFloat).Val, cv.ShouldEqual, 1.5)
+
+		x, err = env.EvalString(`(:x (geom-walk (point x:0.0 y:0.0) 3 (fn [p] (point x:(+ (:x p) 1.0) y:(:y p)))))`)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(x.(zygo.SexpFloat).Val, cv.ShouldEqual, 3.0)
+
+		x, err = env.EvalString(`(def r (rect min:(point x:0.0 y:0.0) max:(point x:2.0 y:3.0)))
+            [(_method r Area:) (_method r Contains: (point x:1.0 y:1.0))]`)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(x.SexpString(), cv.ShouldEqual, "[[6] [true]]")
+
+		x, err = env.EvalString(`(_method (point x:1.0 y:2.0) String:)`)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(x.SexpString(), cv.ShouldEqual, `["(1,2)"]`)
+
+		_, err = env.EvalString(`(geom-dist (point) 3)`)
+		env.Clear()
+		cv.So(err.Error(), cv.ShouldEqual, "Error calling 'geom-dist': geom-dist: argument 2: "+
+			"cannot use *zygo.SexpInt / val = '3' as Go geom.Point")
+	})
+}
@@ -0,0 +1,458 @@
+/*
+zygo-bind generates the zygo bindings for a Go package, so that its
+types and functions can be used from zygo without writing glue by
+hand. Put a line like
+
+	//go:generate zygo-bind -types Point,Rect -funcs Dist,ParsePoint
+
+in the package, run go generate, and then, before making an env,
+
+	zygo.RegisterPackage(mypkg.ZygoBindings)
+
+Each listed struct type T becomes a record named after it, t, with
+every exported method of *T callable through _method. Each listed
+function F becomes a zygo function prefix-f, where prefix defaults
+to the package name. Go names are turned into zygo ones by
+lowercasing them and putting a dash between words, so ParsePoint
+becomes parse-point.
+*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Config holds what zygo-bind was asked to bind.
+type Config struct {
+	Dir    string
+	Out    string
+	Pkg    string
+	Prefix string
+	Types  []string
+	Funcs  []string
+
+	// prefixSet says -prefix was given, even as "".
+	prefixSet bool
+}
+
+func usage(myflags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "zygo-bind: generate zygo bindings for a Go package\n")
+	myflags.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	cfg := &Config{}
+	myflags := flag.NewFlagSet("zygo-bind", flag.ExitOnError)
+	typeList := myflags.String("types", "", "comma separated struct types to bind")
+	funcList := myflags.String("funcs", "", "comma separated functions to bind")
+	myflags.StringVar(&cfg.Dir, "dir", ".", "directory of the package to bind")
+	myflags.StringVar(&cfg.Out, "o", "zygo_bind.go", "output file, written in -dir")
+	myflags.StringVar(&cfg.Pkg, "pkg", "", "import path of the package (default from go list)")
+	myflags.StringVar(&cfg.Prefix, "prefix", "", "prefix for zygo function names (default the package name)")
+	myflags.Parse(os.Args[1:])
+	myflags.Visit(func(f *flag.Flag) {
+		if f.Name == "prefix" {
+			cfg.prefixSet = true
+		}
+	})
+	cfg.Types = splitList(*typeList)
+	cfg.Funcs = splitList(*funcList)
+	if len(cfg.Types) == 0 && len(cfg.Funcs) == 0 {
+		usage(myflags)
+	}
+
+	src, err := Generate(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "zygo-bind: %v\n", err)
+		os.Exit(1)
+	}
+	err = ioutil.WriteFile(filepath.Join(cfg.Dir, cfg.Out), src, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "zygo-bind: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func splitList(s string) []string {
+	var r []string
+	for _, x := range strings.Split(s, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			r = append(r, x)
+		}
+	}
+	return r
+}
+
+// Generate type checks the package in cfg.Dir, leaving out cfg.Out
+// and tests, and returns the formatted source of its bindings.
+func Generate(cfg *Config) ([]byte, error) {
+	fset := token.NewFileSet()
+	skip := func(fi os.FileInfo) bool {
+		return fi.Name() != cfg.Out && !strings.HasSuffix(fi.Name(), "_test.go")
+	}
+	pkgs, err := parser.ParseDir(fset, cfg.Dir, skip, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expected one package in '%s', but found %d", cfg.Dir, len(pkgs))
+	}
+	var files []*ast.File
+	var name string
+	for name = range pkgs {
+		for _, f := range pkgs[name].Files {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return fset.File(files[i].Pos()).Name() < fset.File(files[j].Pos()).Name()
+	})
+
+	tc := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	pkg, err := tc.Check(name, fset, files, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Pkg == "" {
+		cfg.Pkg = importPath(cfg.Dir, name)
+	}
+	if !cfg.prefixSet {
+		cfg.Prefix = name
+	}
+
+	g := &generator{cfg: cfg, pkg: pkg, imports: map[string]string{}}
+	if err := g.bind(); err != nil {
+		return nil, err
+	}
+	return g.source()
+}
+
+// importPath asks go list for the import path of dir, and settles
+// for the package name if it cannot say.
+func importPath(dir, name string) string {
+	cmd := exec.Command("go", "list", "-f", "{{.ImportPath}}", ".")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return name
+	}
+	return strings.TrimSpace(string(out))
+}
+
+type generator struct {
+	cfg     *Config
+	pkg     *types.Package
+	imports map[string]string // path -> name, of packages the code names
+	body    bytes.Buffer
+	types   bytes.Buffer
+	funcs   bytes.Buffer
+}
+
+func (g *generator) bind() error {
+	for _, tname := range g.cfg.Types {
+		obj, isType := g.pkg.Scope().Lookup(tname).(*types.TypeName)
+		if !isType {
+			return fmt.Errorf("no type %s in package %s", tname, g.pkg.Name())
+		}
+		if _, isStruct := obj.Type().Underlying().(*types.Struct); !isStruct {
+			return fmt.Errorf("type %s is not a struct", tname)
+		}
+		fmt.Fprintf(&g.types, "\t\t{\n\t\t\tName: %q,\n", ZygoName(tname))
+		fmt.Fprintf(&g.types, "\t\t\tFactory: func(env *zygo.Glisp) (interface{}, error) {\n"+
+			"\t\t\t\treturn &%s{}, nil\n\t\t\t},\n", tname)
+		fmt.Fprintf(&g.types, "\t\t\tMethods: map[string]zygo.GlispUserFunction{\n")
+
+		mset := types.NewMethodSet(types.NewPointer(obj.Type()))
+		for i := 0; i < mset.Len(); i++ {
+			m := mset.At(i).Obj().(*types.Func)
+			if !m.Exported() {
+				continue
+			}
+			sig := m.Type().(*types.Signature)
+			if bad := g.unusable(sig); bad != "" {
+				fmt.Fprintf(os.Stderr, "zygo-bind: skipping method %s.%s, which uses %s\n",
+					tname, m.Name(), bad)
+				continue
+			}
+			wrapper := "zygoBind" + tname + m.Name()
+			g.wrap(wrapper, tname, m.Name(), sig)
+			fmt.Fprintf(&g.types, "\t\t\t\t%q: %s,\n", m.Name(), wrapper)
+		}
+		fmt.Fprintf(&g.types, "\t\t\t},\n\t\t},\n")
+	}
+
+	for _, fname := range g.cfg.Funcs {
+		fn, isFunc := g.pkg.Scope().Lookup(fname).(*types.Func)
+		if !isFunc {
+			return fmt.Errorf("no func %s in package %s", fname, g.pkg.Name())
+		}
+		sig := fn.Type().(*types.Signature)
+		if bad := g.unusable(sig); bad != "" {
+			return fmt.Errorf("func %s uses %s, which cannot be named outside its package",
+				fname, bad)
+		}
+		zname := ZygoName(fname)
+		if g.cfg.Prefix != "" {
+			zname = g.cfg.Prefix + "-" + zname
+		}
+		wrapper := "zygoBind" + fname
+		g.wrap(wrapper, "", fname, sig)
+		fmt.Fprintf(&g.funcs, "\t\t{Name: %q, Fn: %s},\n", zname, wrapper)
+	}
+	return nil
+}
+
+// unusable returns the first type in sig that generated code outside
+// its package could not name, or "".
+func (g *generator) unusable(sig *types.Signature) string {
+	var bad string
+	var walk func(t types.Type)
+	walk = func(t types.Type) {
+		if bad != "" {
+			return
+		}
+		switch t := t.(type) {
+		case *types.Named:
+			obj := t.Obj()
+			if obj.Pkg() != nil && obj.Pkg() != g.pkg && !obj.Exported() {
+				bad = obj.Pkg().Name() + "." + obj.Name()
+			}
+		case *types.Pointer:
+			walk(t.Elem())
+		case *types.Slice:
+			walk(t.Elem())
+		case *types.Array:
+			walk(t.Elem())
+		case *types.Chan:
+			walk(t.Elem())
+		case *types.Map:
+			walk(t.Key())
+			walk(t.Elem())
+		case *types.Signature:
+			walkTuple(t.Params(), walk)
+			walkTuple(t.Results(), walk)
+		case *types.Struct:
+			for i := 0; i < t.NumFields(); i++ {
+				walk(t.Field(i).Type())
+			}
+		}
+	}
+	walk(sig)
+	return bad
+}
+
+func walkTuple(tup *types.Tuple, walk func(types.Type)) {
+	for i := 0; i < tup.Len(); i++ {
+		walk(tup.At(i).Type())
+	}
+}
+
+// typeString names t as the generated file, in g.pkg, sees it.
+func (g *generator) typeString(t types.Type) string {
+	return types.TypeString(t, func(p *types.Package) string {
+		if p == g.pkg {
+			return ""
+		}
+		g.imports[p.Path()] = p.Name()
+		return p.Name()
+	})
+}
+
+func isContext(t types.Type) bool {
+	named, isNamed := t.(*types.Named)
+	return isNamed && named.Obj().Pkg() != nil &&
+		named.Obj().Pkg().Path() == "context" && named.Obj().Name() == "Context"
+}
+
+func isError(t types.Type) bool {
+	return types.Identical(t, types.Universe.Lookup("error").Type())
+}
+
+// wrap writes a GlispUserFunction that calls the func, or if recv is
+// set, the method of *recv, called goname, with signature sig.
+func (g *generator) wrap(wrapper, recv, goname string, sig *types.Signature) {
+	b := &g.body
+	params := sig.Params()
+	nparam := params.Len()
+	if sig.Variadic() {
+		nparam--
+	}
+	// want counts the args the script passes, receiver included.
+	want := 0
+	if recv != "" {
+		want++
+	}
+	for i := 0; i < nparam; i++ {
+		if !isContext(params.At(i).Type()) {
+			want++
+		}
+	}
+
+	if recv != "" {
+		fmt.Fprintf(b, "// %s calls (*%s).%s.\n", wrapper, recv, goname)
+	} else {
+		fmt.Fprintf(b, "// %s calls %s.\n", wrapper, goname)
+	}
+	fmt.Fprintf(b, "func %s(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {\n", wrapper)
+	switch {
+	case !sig.Variadic():
+		fmt.Fprintf(b, "\tif len(args) != %d {\n", want)
+		fmt.Fprintf(b, "\t\treturn zygo.SexpNull, zygo.WrongNargs\n\t}\n")
+	case want > 0:
+		fmt.Fprintf(b, "\tif len(args) < %d {\n", want)
+		fmt.Fprintf(b, "\t\treturn zygo.SexpNull, zygo.WrongNargs\n\t}\n")
+	}
+
+	next := 0
+	call := goname
+	if recv != "" {
+		fmt.Fprintf(b, "\tvar recv *%s\n", recv)
+		fmt.Fprintf(b, "\tif err := zygo.BindReceiver(env, name, args[0], &recv); err != nil {\n")
+		fmt.Fprintf(b, "\t\treturn zygo.SexpNull, err\n\t}\n")
+		call = "recv." + goname
+		next++
+	}
+
+	var callArgs []string
+	for i := 0; i < nparam; i++ {
+		a := fmt.Sprintf("a%d", i)
+		callArgs = append(callArgs, a)
+		t := params.At(i).Type()
+		if isContext(t) {
+			fmt.Fprintf(b, "\t%s := env.Context()\n", a)
+			continue
+		}
+		fmt.Fprintf(b, "\tvar %s %s\n", a, g.typeString(t))
+		fmt.Fprintf(b, "\tif err := zygo.BindArg(env, name, %d, args[%d], &%s); err != nil {\n", next, next, a)
+		fmt.Fprintf(b, "\t\treturn zygo.SexpNull, err\n\t}\n")
+		next++
+	}
+	if sig.Variadic() {
+		a := fmt.Sprintf("a%d", nparam)
+		callArgs = append(callArgs, a+"...")
+		st := params.At(nparam).Type().(*types.Slice)
+		fmt.Fprintf(b, "\tvar %s %s\n", a, g.typeString(st))
+		fmt.Fprintf(b, "\tfor i := %d; i < len(args); i++ {\n", next)
+		fmt.Fprintf(b, "\t\tvar v %s\n", g.typeString(st.Elem()))
+		fmt.Fprintf(b, "\t\tif err := zygo.BindArg(env, name, i, args[i], &v); err != nil {\n")
+		fmt.Fprintf(b, "\t\t\treturn zygo.SexpNull, err\n\t\t}\n")
+		fmt.Fprintf(b, "\t\t%s = append(%s, v)\n\t}\n", a, a)
+	}
+	callExpr := fmt.Sprintf("%s(%s)", call, strings.Join(callArgs, ", "))
+
+	results := sig.Results()
+	nres := results.Len()
+	var rs []string
+	for i := 0; i < nres; i++ {
+		rs = append(rs, fmt.Sprintf("r%d", i))
+	}
+
+	// methods return all their results, as _method does
+	if recv != "" {
+		g.writeCall(b, rs, results, callExpr)
+		fmt.Fprintf(b, "\treturn zygo.BindResults(env%s)\n}\n\n", prependComma(rs))
+		return
+	}
+
+	// funcs turn a trailing error into a script error
+	if nres > 0 && isError(results.At(nres-1).Type()) {
+		rs[nres-1] = "err"
+		g.writeCall(b, rs, results, callExpr)
+		fmt.Fprintf(b, "\tif err != nil {\n\t\treturn zygo.SexpNull, err\n\t}\n")
+		rs = rs[:nres-1]
+	} else {
+		g.writeCall(b, rs, results, callExpr)
+	}
+	switch len(rs) {
+	case 0:
+		fmt.Fprintf(b, "\treturn zygo.SexpNull, nil\n}\n\n")
+	case 1:
+		fmt.Fprintf(b, "\treturn zygo.BindResult(env, %s)\n}\n\n", rs[0])
+	default:
+		fmt.Fprintf(b, "\treturn zygo.BindResults(env%s)\n}\n\n", prependComma(rs))
+	}
+}
+
+// writeCall writes the call itself, through env.CallGo so that any
+// zygo callbacks the Go code calls can run, and declares its results
+// rs.
+func (g *generator) writeCall(b *bytes.Buffer, rs []string, results *types.Tuple, callExpr string) {
+	if len(rs) == 0 {
+		fmt.Fprintf(b, "\tenv.CallGo(func() { %s })\n", callExpr)
+		return
+	}
+	for i, r := range rs {
+		fmt.Fprintf(b, "\tvar %s %s\n", r, g.typeString(results.At(i).Type()))
+	}
+	fmt.Fprintf(b, "\tenv.CallGo(func() { %s = %s })\n", strings.Join(rs, ", "), callExpr)
+}
+
+func prependComma(xs []string) string {
+	if len(xs) == 0 {
+		return ""
+	}
+	return ", " + strings.Join(xs, ", ")
+}
+
+func (g *generator) source() ([]byte, error) {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by zygo-bind; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&out, "package %s\n\n", g.pkg.Name())
+
+	paths := []string{"github.com/glycerine/zygomys/repl"}
+	for path := range g.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	fmt.Fprintf(&out, "import (\n")
+	for _, path := range paths {
+		fmt.Fprintf(&out, "\t%q\n", path)
+	}
+	fmt.Fprintf(&out, ")\n\n")
+
+	fmt.Fprintf(&out, "// ZygoBindings is to be passed to zygo.RegisterPackage.\n")
+	fmt.Fprintf(&out, "var ZygoBindings = &zygo.Bindings{\n\tPackage: %q,\n", g.cfg.Pkg)
+	fmt.Fprintf(&out, "\tTypes: []zygo.TypeBinding{\n%s\t},\n", g.types.String())
+	fmt.Fprintf(&out, "\tFuncs: []zygo.FuncBinding{\n%s\t},\n}\n\n", g.funcs.String())
+	out.Write(g.body.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %v\n%s", err, out.String())
+	}
+	return src, nil
+}
+
+// ZygoName turns a Go name into a zygo one: ParsePoint becomes
+// parse-point, and HTTPServer, http-server.
+func ZygoName(goname string) string {
+	rs := []rune(goname)
+	var b strings.Builder
+	for i, r := range rs {
+		if unicode.IsUpper(r) && i > 0 {
+			prevLower := unicode.IsLower(rs[i-1]) || unicode.IsDigit(rs[i-1])
+			nextLower := i+1 < len(rs) && unicode.IsLower(rs[i+1])
+			if prevLower || (unicode.IsUpper(rs[i-1]) && nextLower) {
+				b.WriteByte('-')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
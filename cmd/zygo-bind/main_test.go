@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestZygoName(t *testing.T) {
+
+	cv.Convey(`ZygoName should dash-separate the words of Go names`, t, func() {
+		cv.So(ZygoName("Dist"), cv.ShouldEqual, "dist")
+		cv.So(ZygoName("ParsePoint"), cv.ShouldEqual, "parse-point")
+		cv.So(ZygoName("HTTPServer"), cv.ShouldEqual, "http-server")
+		cv.So(ZygoName("ReadUint32"), cv.ShouldEqual, "read-uint32")
+	})
+}
+
+func TestGenerateMatchesExample(t *testing.T) {
+
+	cv.Convey(`Generating example/geom's bindings again should give`+
+		` the committed zygo_bind.go`, t, func() {
+		cfg := &Config{
+			Dir:   "example/geom",
+			Out:   "zygo_bind.go",
+			Types: []string{"Point", "Rect"},
+			Funcs: []string{"Dist", "Centroid", "ParsePoint", "Walk"},
+		}
+		src, err := Generate(cfg)
+		cv.So(err, cv.ShouldBeNil)
+		want, err := ioutil.ReadFile("example/geom/zygo_bind.go")
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(string(src), cv.ShouldEqual, string(want))
+	})
+}
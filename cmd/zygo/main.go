@@ -7,6 +7,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/glycerine/zygomys/repl"
+	"io/ioutil"
 	"os"
 )
 
@@ -16,7 +17,40 @@ func usage(myflags *flag.FlagSet) {
 	os.Exit(1)
 }
 
+// genGo is `zygo gen-go [-pkg name] [-o out.go] file.zy`: it runs
+// file.zy and writes Go declarations for the structs it declares.
+func genGo(args []string) {
+	myflags := flag.NewFlagSet("gen-go", flag.ExitOnError)
+	pkg := myflags.String("pkg", "main", "package clause for the generated Go file")
+	out := myflags.String("o", "", "file to write the Go source to (default stdout)")
+	myflags.Parse(args)
+	if myflags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "use: zygo gen-go [-pkg name] [-o out.go] file.zy\n")
+		usage(myflags)
+	}
+
+	src, err := zygo.GenGoFile(myflags.Arg(0), *pkg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "zygo gen-go error: '%v'\n", err)
+		os.Exit(1)
+	}
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	err = ioutil.WriteFile(*out, src, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "zygo gen-go error: '%v'\n", err)
+		os.Exit(1)
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "gen-go" {
+		genGo(os.Args[2:])
+		return
+	}
+
 	cfg := zygo.NewGlispConfig("zygo")
 	cfg.DefineFlags()
 	err := cfg.Flags.Parse(os.Args[1:])
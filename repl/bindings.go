@@ -0,0 +1,147 @@
+package zygo
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Bindings describe a Go package to zygo: the struct types it makes
+// available as records, and the functions it makes callable. The
+// zygo-bind tool (see cmd/zygo-bind) generates them from a package's
+// source, and RegisterPackage puts them to use.
+type Bindings struct {
+	// Package is the import path of the bound package.
+	Package string
+	Types   []TypeBinding
+	Funcs   []FuncBinding
+}
+
+// TypeBinding registers a struct type under the record name Name.
+// Factory returns a pointer to a new zero struct, as for
+// GoStructRegistry. Methods maps Go method names to wrappers that
+// take the record as their first argument; _method calls these
+// instead of going through reflection, and they return an array of
+// all the method's results, as _method always has.
+type TypeBinding struct {
+	Name    string
+	Factory MakeGoStructFunc
+	Methods map[string]GlispUserFunction
+}
+
+// FuncBinding makes Fn callable from zygo as Name.
+type FuncBinding struct {
+	Name string
+	Fn   GlispUserFunction
+}
+
+// GoPackages holds every package given to RegisterPackage, by
+// import path.
+var GoPackages = map[string]*Bindings{}
+
+// RegisterPackage makes the types and functions of b available to
+// every env set up afterwards with StandardSetup (or ImportPackages),
+// each env getting the types in its own registry. It is meant to be
+// called from init() or main() before any env is made, and fails if
+// b would redefine a type name.
+func RegisterPackage(b *Bindings) error {
+	if _, dup := GoPackages[b.Package]; dup {
+		return fmt.Errorf("RegisterPackage: package '%s' already registered", b.Package)
+	}
+	for _, t := range b.Types {
+		if GoStructRegistry.Lookup(t.Name) != nil || packageType(t.Name) {
+			return fmt.Errorf("RegisterPackage '%s': type '%s' already registered",
+				b.Package, t.Name)
+		}
+	}
+	GoPackages[b.Package] = b
+	return nil
+}
+
+// packageType tells if a registered package has a type called name.
+func packageType(name string) bool {
+	for _, b := range GoPackages {
+		for _, t := range b.Types {
+			if t.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ImportPackages adds the types of all registered packages to
+// env.Registry(), and their functions to env.
+func (env *Glisp) ImportPackages() {
+	paths := make([]string, 0, len(GoPackages))
+	for path := range GoPackages {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		for _, t := range GoPackages[path].Types {
+			rt := &RegisteredType{GenDefMap: true, Factory: t.Factory, Methods: t.Methods}
+			env.Registry().RegisterUserdef(t.Name, rt, true)
+			env.AddGlobal(t.Name, rt)
+		}
+		for _, f := range GoPackages[path].Funcs {
+			env.AddFunction(f.Name, f.Fn)
+		}
+	}
+}
+
+// BindArg converts the i-th (from 0) argument x of the function
+// name into the Go variable that ptr points to, with SexpToGoValue.
+// Generated bindings call it for each parameter.
+func BindArg(env *Glisp, name string, i int, x Sexp, ptr interface{}) error {
+	p := reflect.ValueOf(ptr).Elem()
+	v, err := SexpToGoValue(env, x, p.Type())
+	if err != nil {
+		return fmt.Errorf("%s: argument %d: %v", name, i+1, err)
+	}
+	p.Set(v)
+	return nil
+}
+
+// BindReceiver sets the method receiver that ptr points to from the
+// record x. Like _method, it first refreshes the record's Go shadow
+// struct with togo, unless the record is live, and calls on that.
+func BindReceiver(env *Glisp, name string, x Sexp, ptr interface{}) error {
+	h, isHash := x.(*SexpHash)
+	if !isHash {
+		return fmt.Errorf("%s: receiver must be a record, but we had %T / val = '%s'",
+			name, x, x.SexpString())
+	}
+	_, err := ToGoFunction(env, "togo", []Sexp{h})
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	p := reflect.ValueOf(ptr).Elem()
+	shadow := h.GoShadowStructVa
+	if shadow.Type() != p.Type() && shadow.Kind() == reflect.Ptr {
+		shadow = shadow.Elem()
+	}
+	if shadow.Type() != p.Type() {
+		return fmt.Errorf("%s: cannot use record '%s' as Go %s", name, h.TypeName, p.Type())
+	}
+	p.Set(shadow)
+	return nil
+}
+
+// BindResult converts a result of a bound Go function with
+// GoValueToSexp. BindResults puts several results in an array.
+func BindResult(env *Glisp, v interface{}) (Sexp, error) {
+	return GoValueToSexp(env, reflect.ValueOf(v))
+}
+
+func BindResults(env *Glisp, vs ...interface{}) (Sexp, error) {
+	res := make([]Sexp, len(vs))
+	for i, v := range vs {
+		sx, err := BindResult(env, v)
+		if err != nil {
+			return SexpNull, err
+		}
+		res[i] = sx
+	}
+	return &SexpArray{Val: res}, nil
+}
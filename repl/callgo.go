@@ -49,6 +49,22 @@ func CallGoMethodFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 			return SexpNull, fmt.Errorf("_method error: second argument must be a method name in symbol or string form (got %T)", args[1])
 		}
 
+		// methods defined in zygo
+		m, recv, err := obj.userMethod(env, methodname)
+		if err != nil {
+			return SexpNull, err
+		}
+		if m != nil {
+			return m.Call(env, recv, args[2:])
+		}
+
+		// generated bindings skip the reflection below
+		if rt := env.Registry().Lookup(obj.TypeName); rt != nil {
+			if bound, isBound := rt.Methods[methodname]; isBound {
+				return bound(env, methodname, append([]Sexp{obj}, args[2:]...))
+			}
+		}
+
 		// get the method list, verify the method exists and get its type
 		if obj.NumMethod == -1 {
 			err := obj.SetMethodList(env)
@@ -73,8 +89,8 @@ func CallGoMethodFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 		}
 		// INVAR: var method holds our call target
 
-		// ready the struct
-		_, err := ToGoFunction(env, "togo", []Sexp{obj})
+		// ready the struct; togo leaves a live record's alone
+		_, err = ToGoFunction(env, "togo", []Sexp{obj})
 		if err != nil {
 			return SexpNull, fmt.Errorf("error converting object to Go struct: '%s'", err)
 		}
@@ -92,6 +108,16 @@ func CallGoMethodFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 		var va reflect.Value
 		for i := 2; i < narg; i++ {
 			typ := method.Type.In(i - 1)
+			if typ.Kind() == reflect.Func {
+				// zygo functions become Go callbacks
+				fv, err := SexpToGoValue(env, args[i], typ)
+				if err != nil {
+					return SexpNull, fmt.Errorf("error converting %d-th "+
+						"argument to Go: '%s'", i-2, err)
+				}
+				inputVa = append(inputVa, fv)
+				continue
+			}
 			pdepth := PointerDepth(typ)
 			// we only handle 0 and 1 for now
 			Q("pdepth = %v\n", pdepth)
@@ -122,7 +148,8 @@ func CallGoMethodFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 
 		Q("_method: about to .Call by reflection!\n")
 
-		out := method.Func.Call(inputVa)
+		var out []reflect.Value
+		env.CallGo(func() { out = method.Func.Call(inputVa) })
 
 		var iout []interface{}
 		for _, o := range out {
@@ -155,7 +182,7 @@ func CallGoMethodFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 			default:
 				// go through the type registry
 				found := false
-				for hashName, factory := range GoStructRegistry.Registry {
+				for hashName, factory := range env.Registry().All() {
 					st, err := factory.Factory(env)
 					if err != nil {
 						return SexpNull, fmt.Errorf("MakeHash '%s' problem on Factory call: %s",
@@ -188,6 +215,9 @@ func CallGoMethodFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 		return &SexpArray{Val: r}, nil
 	}()
 	if wasPanic {
+		if cp, isCallback := recovered.(callbackPanic); isCallback {
+			return SexpNull, cp.err
+		}
 		return SexpNull, fmt.Errorf("\n recovered from panic "+
 			"during CallGo. panic on = '%v'\n"+
 			"stack trace:\n%s\n", recovered, string(*trace))
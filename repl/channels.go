@@ -56,11 +56,13 @@ func ChanTxFunction(env *Glisp, name string,
 		if len(args) != 2 {
 			return SexpNull, WrongNargs
 		}
-		channel <- args[1]
+		env.CallGo(func() { channel <- args[1] })
 		return SexpNull, nil
 	}
 
-	return <-channel, nil
+	var x Sexp
+	env.CallGo(func() { x = <-channel })
+	return x, nil
 }
 
 func (env *Glisp) ImportChannels() {
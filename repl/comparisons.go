@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"reflect"
+	"time"
 )
 
 func signumFloat(f float64) int {
@@ -169,6 +171,17 @@ func comparePointers(a *SexpPointer, bs Sexp) (int, error) {
 	return 1, nil
 }
 
+// compareIdentity is for values that are only equal to themselves.
+func compareIdentity(a Sexp, b Sexp) (int, error) {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return 0, fmt.Errorf("cannot compare %T to %T", a, b)
+	}
+	if a == b {
+		return 0, nil
+	}
+	return 1, nil
+}
+
 func Compare(a Sexp, b Sexp) (int, error) {
 	switch at := a.(type) {
 	case *SexpInt:
@@ -189,6 +202,22 @@ func Compare(a Sexp, b Sexp) (int, error) {
 		return compareArray(at, b)
 	case *SexpHash:
 		return compareHash(at, b)
+	case *SexpPVec:
+		return comparePVec(at, b)
+	case *SexpPMap:
+		return comparePMap(at, b)
+	case *SexpSet:
+		return compareSet(at, b)
+	case SexpRaw:
+		if bt, ok := b.(SexpRaw); ok {
+			return bytes.Compare(at.Val, bt.Val), nil
+		}
+	case SexpTime:
+		if bt, ok := b.(SexpTime); ok {
+			return signumInt(time.Time(at).Sub(time.Time(bt)).Nanoseconds()), nil
+		}
+	case *SexpFunction, *SexpRegexp, SexpChannel, *SexpLazySeq, *SexpIterator, *SexpTemplate, *SexpPort, *SexpProcess, *SexpModule:
+		return compareIdentity(a, b)
 	case *RegisteredType:
 		return compareRegisteredTypes(at, b)
 	case *SexpPointer:
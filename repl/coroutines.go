@@ -29,6 +29,7 @@ func StartGoroutineFunction(env *Glisp, name string,
 func CreateGoroutineMacro(env *Glisp, name string,
 	args []Sexp) (Sexp, error) {
 	goroenv := env.Duplicate()
+	goroenv.tok = tokenExempt
 	err := goroenv.LoadExpressions(args)
 	if err != nil {
 		return SexpNull, nil
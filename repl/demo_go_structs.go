@@ -63,6 +63,15 @@ func (p *Snoopy) Fly(ev *Weather) (s string, err error) {
 	return
 }
 
+// Chorus joins what cry says for each of 0..n-1.
+func (p *Snoopy) Chorus(n int, cry func(i int) string) string {
+	s := ""
+	for i := 0; i < n; i++ {
+		s += cry(i)
+	}
+	return s
+}
+
 func (p *Snoopy) GetCry() string {
 	return p.Cry
 }
@@ -75,6 +84,12 @@ func (p *Snoopy) Sideeffect() {
 	fmt.Printf("Sideeffect() called! p = %p\n", p)
 }
 
+// Grow adds kg to the hornet's mass, and returns the new mass.
+func (b *Hornet) Grow(kg float64) float64 {
+	b.Mass += kg
+	return b.Mass
+}
+
 func (b *Hornet) Fly(ev *Weather) (s string, err error) {
 	fmt.Printf("Hornet sees weather %v", ev)
 	return
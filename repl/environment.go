@@ -3,6 +3,7 @@ package zygo
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -33,7 +34,6 @@ type Glisp struct {
 	curfunc     *SexpFunction
 	mainfunc    *SexpFunction
 	pc          int
-	nextsymbol  int
 	before      []PreHook
 	after       []PostHook
 
@@ -42,6 +42,26 @@ type Glisp struct {
 
 	showGlobalScope bool
 	baseTypeCtor    *SexpFunction
+
+	// modules: what import has loaded, shared by clones.
+	modules *moduleTable
+
+	// ctx is handed to Go functions that take a context.Context.
+	ctx context.Context
+
+	// interp is the token that whoever runs zygo code for env and
+	// its duplicates holds; tok says if this env holds it. See
+	// MakeGoFunc.
+	interp interpToken
+	tok    tokenState
+
+	// registry holds the types this env can see; see Registry.
+	registry *GoStructRegistryType
+
+	// doseqIters are the iterators of the doseq loops running in
+	// this env, innermost last. Run closes those a failing
+	// instruction leaves behind.
+	doseqIters []*SexpIterator
 }
 
 const CallStackSize = 25
@@ -50,7 +70,7 @@ const DataStackSize = 100
 const StackStackSize = 5
 const LoopStackSize = 5
 
-var ReservedWords = []string{"byte", "defbuild", "builder", "field", "and", "or", "cond", "quote", "def", "mdef", "fn", "defn", "begin", "let", "let*", "assert", "defmac", "macexpand", "syntax-quote", "include", "for", "set", "break", "continue", "new-scope", "_ls", "int8", "int16", "int32", "int64", "uint8", "uint16", "uint32", "uint64", "float32", "float64", "complex64", "complex128", "bool", "string", "any", "break", "case", "chan", "const", "continue", "default", "else", "defer", "fallthrough", "for", "func", "go", "goto", "if", "import", "interface", "map", "package", "range", "return", "select", "struct", "switch", "type", "var", "append", "cap", "close", "complex", "copy", "delete", "imag", "len", "make", "new", "panic", "print", "println", "real", "recover", "null", "nil"}
+var ReservedWords = []string{"byte", "defbuild", "builder", "field", "and", "or", "cond", "quote", "def", "mdef", "fn", "defn", "begin", "let", "let*", "assert", "defmac", "macexpand", "syntax-quote", "include", "for", "doseq", "set", "break", "continue", "new-scope", "_ls", "int8", "int16", "int32", "int64", "uint8", "uint16", "uint32", "uint64", "float32", "float64", "complex64", "complex128", "bool", "string", "any", "break", "case", "chan", "const", "continue", "default", "else", "defer", "fallthrough", "for", "func", "go", "goto", "if", "import", "interface", "map", "package", "range", "return", "select", "struct", "switch", "type", "var", "append", "cap", "close", "complex", "copy", "delete", "imag", "len", "make", "new", "panic", "print", "println", "real", "recover", "null", "nil"}
 
 func NewGlisp() *Glisp {
 	return NewGlispWithFuncs(AllBuiltinFunctions())
@@ -59,11 +79,19 @@ func NewGlisp() *Glisp {
 // NewGlispSandbox returns a new *Glisp instance that does not allow the
 // user to get to the outside world
 func NewGlispSandbox() *Glisp {
-	return NewGlispWithFuncs(SandboxSafeFunctions())
+	// a sandbox gets no port I/O, so no std ports either.
+	return newGlisp(SandboxSafeFunctions(), false)
 }
 
-// NewGlispWithFuncs returns a new *Glisp instance with access to only the given builtin functions
+// NewGlispWithFuncs returns a new *Glisp instance with access to only the given builtin functions,
+// and to *stdin*, *stdout* and *stderr*.
 func NewGlispWithFuncs(funcs map[string]GlispUserFunction) *Glisp {
+	return newGlisp(funcs, true)
+}
+
+// newGlisp makes an env with funcs as its builtins, binding the
+// std ports if withPorts is set.
+func newGlisp(funcs map[string]GlispUserFunction, withPorts bool) *Glisp {
 	env := new(Glisp)
 	env.baseTypeCtor = MakeUserFunction("__basetype_ctor", BaseTypeConstructorFunction)
 	env.parser = env.NewParser()
@@ -81,16 +109,25 @@ func NewGlispWithFuncs(funcs map[string]GlispUserFunction) *Glisp {
 	env.macros = make(map[int]*SexpFunction)
 	env.symtable = make(map[string]int)
 	env.revsymtable = make(map[int]string)
-	env.nextsymbol = 1
 	env.before = []PreHook{}
 	env.after = []PostHook{}
+	env.modules = newModuleTable()
+	env.interp = make(interpToken, 1)
+	env.registry = GoStructRegistry.Fork()
 
 	env.AddGlobal("null", SexpNull)
 	env.AddGlobal("nil", SexpNull)
+	env.AddGlobal("*argv*", &SexpArray{})
+	if withPorts {
+		env.ImportPorts()
+	}
 
+	shadowable := ShadowableFunctions()
 	for key, function := range funcs {
-		sym := env.MakeSymbol(key)
-		env.builtins[sym.number] = MakeUserFunction(key, function)
+		if _, canShadow := shadowable[key]; !canShadow {
+			sym := env.MakeSymbol(key)
+			env.builtins[sym.number] = MakeUserFunction(key, function)
+		}
 		env.AddFunction(key, function)
 	}
 
@@ -117,15 +154,20 @@ func (env *Glisp) Clone() *Glisp {
 	dupenv.datastack = env.datastack.Clone()
 	dupenv.linearstack = env.linearstack.Clone()
 	dupenv.addrstack = env.addrstack.Clone()
+	dupenv.loopstack = dupenv.NewStack(LoopStackSize)
 
 	dupenv.builtins = env.builtins
 	dupenv.reserved = env.reserved
 	dupenv.macros = env.macros
 	dupenv.symtable = env.symtable
 	dupenv.revsymtable = env.revsymtable
-	dupenv.nextsymbol = env.nextsymbol
 	dupenv.before = env.before
 	dupenv.after = env.after
+	dupenv.modules = env.modules
+	dupenv.ctx = env.ctx
+	dupenv.interp = env.interp
+	dupenv.tok = env.tok
+	dupenv.registry = env.Registry().Fork()
 
 	dupenv.linearstack.Push(env.linearstack.elements[0])
 
@@ -145,14 +187,19 @@ func (env *Glisp) Duplicate() *Glisp {
 	dupenv.datastack = dupenv.NewStack(DataStackSize)
 	dupenv.linearstack = dupenv.NewStack(ScopeStackSize)
 	dupenv.addrstack = dupenv.NewStack(CallStackSize)
+	dupenv.loopstack = dupenv.NewStack(LoopStackSize)
 	dupenv.builtins = env.builtins
 	dupenv.reserved = env.reserved
 	dupenv.macros = env.macros
 	dupenv.symtable = env.symtable
 	dupenv.revsymtable = env.revsymtable
-	dupenv.nextsymbol = env.nextsymbol
 	dupenv.before = env.before
 	dupenv.after = env.after
+	dupenv.modules = env.modules
+	dupenv.ctx = env.ctx
+	dupenv.interp = env.interp
+	dupenv.tok = env.tok
+	dupenv.registry = env.registry
 
 	dupenv.linearstack.Push(env.linearstack.elements[0])
 
@@ -177,15 +224,16 @@ func (env *Glisp) MakeSymbol(name string) SexpSymbol {
 	if ok {
 		return SexpSymbol{name: name, number: symnum}
 	}
-	symbol := SexpSymbol{name: name, number: env.nextsymbol}
+	// the table is shared with duplicates, so number from its size
+	// rather than from a counter of our own.
+	symbol := SexpSymbol{name: name, number: len(env.symtable) + 1}
 	env.symtable[name] = symbol.number
 	env.revsymtable[symbol.number] = name
-	env.nextsymbol++
 	return symbol
 }
 
 func (env *Glisp) GenSymbol(prefix string) SexpSymbol {
-	symname := prefix + strconv.Itoa(env.nextsymbol)
+	symname := prefix + strconv.Itoa(len(env.symtable)+1)
 	return env.MakeSymbol(symname)
 }
 
@@ -283,8 +331,7 @@ func (env *Glisp) CallUserFunction(
 
 	args, err := env.datastack.PopExpressions(nargs)
 	if err != nil {
-		return 0, errors.New(
-			fmt.Sprintf("Error calling '%s': %v", name, err))
+		return 0, fmt.Errorf("Error calling '%s': %w", name, err)
 	}
 
 	env.addrstack.PushAddr(env.curfunc, env.pc+1)
@@ -315,8 +362,7 @@ func (env *Glisp) CallUserFunction(
 			name, recovered, string(*trace))
 	}
 	if err != nil {
-		return 0, errors.New(
-			fmt.Sprintf("Error calling '%s': %v", name, err))
+		return 0, fmt.Errorf("Error calling '%s': %w", name, err)
 	}
 
 	env.datastack.PushExpr(res)
@@ -400,6 +446,31 @@ func (env *Glisp) LoadString(str string) error {
 	return env.LoadStream(bytes.NewBuffer([]byte(str)))
 }
 
+// Registry returns the registry of the types env can see: a fork of
+// GoStructRegistry, shared with env's Duplicates, that the structs
+// its scripts define go into. Clone gives the new env a fork of it.
+func (env *Glisp) Registry() *GoStructRegistryType {
+	if env == nil || env.registry == nil {
+		return &GoStructRegistry
+	}
+	return env.registry
+}
+
+// Context returns the context given to Go functions registered with
+// RegisterGoFunc that take one; see SetContext.
+func (env *Glisp) Context() context.Context {
+	if env.ctx == nil {
+		return context.Background()
+	}
+	return env.ctx
+}
+
+// SetContext sets the context returned by Context, typically so
+// that Go functions called from a script see its cancellation.
+func (env *Glisp) SetContext(ctx context.Context) {
+	env.ctx = ctx
+}
+
 func (env *Glisp) AddFunction(name string, function GlispUserFunction) {
 	env.AddGlobal(name, MakeUserFunction(name, function))
 }
@@ -533,6 +604,15 @@ func (env *Glisp) Apply(fun *SexpFunction, args []Sexp) (Sexp, error) {
 
 func (env *Glisp) Run() (Sexp, error) {
 
+	if env.tok == tokenFree {
+		env.interp.acquire()
+		env.tok = tokenHeld
+		defer func() {
+			env.tok = tokenFree
+			env.interp.release()
+		}()
+	}
+	mark := len(env.doseqIters)
 	for env.pc != -1 && !env.ReachedEnd() {
 		instr := env.curfunc.fun[env.pc]
 		if env.debugExec {
@@ -544,6 +624,7 @@ func (env *Glisp) Run() (Sexp, error) {
 		}
 		err := instr.Execute(env)
 		if err != nil {
+			env.closeDoseqIters(mark)
 			return SexpNull, err
 		}
 		if env.debugExec {
@@ -672,6 +753,12 @@ func (env *Glisp) LexicalLookupSymbol(sym SexpSymbol, undot bool) (Sexp, error,
 		break
 	}
 
+	// (3) m.name, where m is an imported module
+	exp, found, err := env.lookupQualified(sym)
+	if found {
+		return exp, err, nil
+	}
+
 	return SexpNull, fmt.Errorf("symbol `%s` not found", sym.name), nil
 }
 
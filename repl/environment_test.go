@@ -23,6 +23,14 @@ func Test400SandboxFunctions(t *testing.T) {
 				cv.So(err, cv.ShouldResemble, nil)
 			}
 
+			// nor the std ports
+			for _, name := range []string{"*stdin*", "*stdout*", "*stderr*"} {
+				env.Clear()
+				res, err := env.EvalString(fmt.Sprintf("(defined? '%s)", name))
+				cv.So(res, cv.ShouldResemble, SexpBool{Val: false})
+				cv.So(err, cv.ShouldResemble, nil)
+			}
+
 			// all sandSafeFuncs should be fine
 			for name := range sandSafeFuncs {
 				env.Clear()
@@ -56,5 +64,42 @@ func Test400SandboxFunctions(t *testing.T) {
 			}
 
 		}
+
+		{
+			// the std ports come with NewGlispWithFuncs, whichever
+			// functions it is given.
+			env := NewGlispWithFuncs(SandboxSafeFunctions())
+			res, err := env.EvalString("(defined? '*stdout*)")
+			cv.So(res, cv.ShouldResemble, SexpBool{Val: true})
+			cv.So(err, cv.ShouldEqual, nil)
+		}
+	})
+}
+
+func Test401ScriptMainGetsArgvAndGivesExitCode(t *testing.T) {
+
+	cv.Convey(`Given a script that starts with a #! line and defines main,`+
+		` RunMain should call main with *argv* and return its int result`+
+		` as the exit code.`, t, func() {
+
+		env := NewGlisp()
+		defer env.parser.Stop()
+		env.StandardSetup()
+		env.SetArgv([]string{"a", "bb"})
+
+		_, err := env.EvalString("#!/usr/bin/env zygo\n(defn main [args] (len (concat (aget args 0) (aget args 1))))")
+		panicOn(err)
+		ran, code, err := env.RunMain()
+		panicOn(err)
+		cv.So(ran, cv.ShouldBeTrue)
+		cv.So(code, cv.ShouldEqual, 3)
+
+		env.Clear()
+		_, err = env.EvalString(`(defn main [] (no-such-fn))`)
+		panicOn(err)
+		ran, code, err = env.RunMain()
+		cv.So(ran, cv.ShouldBeTrue)
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(code, cv.ShouldEqual, ExitCodeScriptError)
 	})
 }
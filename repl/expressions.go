@@ -28,7 +28,7 @@ type SexpPointer struct {
 
 func NewSexpPointer(pointedTo Sexp, pointedToType *RegisteredType) *SexpPointer {
 
-	ptrRt := GoStructRegistry.GetOrCreatePointerType(pointedToType)
+	ptrRt := pointerTypeOf(pointedToType)
 	Q("pointer type is ptrRt = '%#v'", ptrRt)
 	p := &SexpPointer{
 		Target:        pointedTo,
@@ -207,7 +207,7 @@ func (r *SexpArray) Type() *RegisteredType {
 			// take type from first element
 			ty := r.Val[0].Type()
 			if ty != nil {
-				r.Typ = GoStructRegistry.GetOrCreateSliceType(ty)
+				r.Typ = sliceTypeOf(ty)
 			}
 		}
 	}
@@ -271,6 +271,10 @@ type SexpHash struct {
 	GoShadowStruct   interface{}
 	GoShadowStructVa reflect.Value
 
+	// GoLive records read and write fields straight through to
+	// GoShadowStruct; see golive.go.
+	GoLive bool
+
 	// json tag name -> pointers to example values, as factories for SexpToGoStructs()
 	JsonTagMap map[string]*HashFieldDet
 	DetOrder   []*HashFieldDet
@@ -0,0 +1,178 @@
+package zygo
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// FlagsExit is called to end the program after defflags prints
+// usage, with 0 for -h and 2 for a bad command line, as a FlagSet
+// with flag.ExitOnError would. Embedders and tests may replace it.
+var FlagsExit = os.Exit
+
+// flagSpec is one [name type default "help"] entry of defflags.
+type flagSpec struct {
+	name string
+	typ  string
+	val  interface{} // pointer filled in by the FlagSet
+}
+
+// (defflags [name type default "help"] ...) is a macro that hands
+// its quoted specs and *argv* to __defflags. Types are string, int,
+// bool, float and duration; a duration default is a string like
+// "5s" or a number of seconds, and its value is in seconds. The
+// result is a (flags ...) record of the values, with the arguments
+// left after the flags in args.
+func DefflagsFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	specList, err := SeqToArray(args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	argv, isArr := args[1].(*SexpArray)
+	if !isArr {
+		return SexpNull, fmt.Errorf("defflags: *argv* must be an array of strings")
+	}
+	cmdline := make([]string, len(argv.Val))
+	for i, a := range argv.Val {
+		s, isStr := a.(SexpStr)
+		if !isStr {
+			return SexpNull, fmt.Errorf("defflags: *argv* must be an array of strings")
+		}
+		cmdline[i] = s.S
+	}
+
+	script := "zygo"
+	if s, found := env.FindObject("*script*"); found {
+		if str, isStr := s.(SexpStr); isStr {
+			script = str.S
+		}
+	}
+	fs := flag.NewFlagSet(script, flag.ContinueOnError)
+	fs.SetOutput(env.StderrPort())
+
+	specs := make([]*flagSpec, 0, len(specList))
+	for _, x := range specList {
+		spec, err := defineFlag(fs, x)
+		if err != nil {
+			return SexpNull, err
+		}
+		specs = append(specs, spec)
+	}
+
+	err = fs.Parse(cmdline)
+	if err == flag.ErrHelp {
+		FlagsExit(0)
+		return SexpNull, err
+	}
+	if err != nil {
+		FlagsExit(2)
+		return SexpNull, err
+	}
+
+	fields := make([]Sexp, 0, 2*len(specs)+2)
+	for _, spec := range specs {
+		var val Sexp
+		switch p := spec.val.(type) {
+		case *string:
+			val = SexpStr{S: *p}
+		case *int64:
+			val = &SexpInt{Val: *p}
+		case *bool:
+			val = SexpBool{Val: *p}
+		case *float64:
+			val = SexpFloat{Val: *p}
+		case *time.Duration:
+			val = SexpFloat{Val: p.Seconds()}
+		}
+		fields = append(fields, env.MakeSymbol(spec.name), val)
+	}
+	fields = append(fields, env.MakeSymbol("args"), stringsToArray(fs.Args()))
+	return MakeHash(fields, "flags", env)
+}
+
+func defineFlag(fs *flag.FlagSet, x Sexp) (*flagSpec, error) {
+	arr, isArr := x.(*SexpArray)
+	if !isArr || len(arr.Val) < 3 || len(arr.Val) > 4 {
+		return nil, fmt.Errorf("defflags: each flag must be [name type default \"help\"], "+
+			"but we had '%s'", x.SexpString())
+	}
+	nameSym, isSym := arr.Val[0].(SexpSymbol)
+	typSym, isSym2 := arr.Val[1].(SexpSymbol)
+	if !isSym || !isSym2 {
+		return nil, fmt.Errorf("defflags: flag name and type must be symbols in '%s'",
+			x.SexpString())
+	}
+	spec := &flagSpec{name: nameSym.name, typ: typSym.name}
+	if spec.name == "args" {
+		return nil, fmt.Errorf("defflags: 'args' is taken by the remaining arguments")
+	}
+	help := ""
+	if len(arr.Val) == 4 {
+		h, isStr := arr.Val[3].(SexpStr)
+		if !isStr {
+			return nil, fmt.Errorf("defflags: help for flag '%s' must be a string", spec.name)
+		}
+		help = h.S
+	}
+
+	dflt := arr.Val[2]
+	bad := fmt.Errorf("defflags: default for %s flag '%s' cannot be '%s'",
+		spec.typ, spec.name, dflt.SexpString())
+	switch spec.typ {
+	case "string":
+		d, isStr := dflt.(SexpStr)
+		if !isStr {
+			return nil, bad
+		}
+		spec.val = fs.String(spec.name, d.S, help)
+	case "int":
+		d, isInt := dflt.(*SexpInt)
+		if !isInt {
+			return nil, bad
+		}
+		spec.val = fs.Int64(spec.name, d.Val, help)
+	case "bool":
+		d, isBool := dflt.(SexpBool)
+		if !isBool {
+			return nil, bad
+		}
+		spec.val = fs.Bool(spec.name, d.Val, help)
+	case "float":
+		var f float64
+		switch d := dflt.(type) {
+		case SexpFloat:
+			f = d.Val
+		case *SexpInt:
+			f = float64(d.Val)
+		default:
+			return nil, bad
+		}
+		spec.val = fs.Float64(spec.name, f, help)
+	case "duration":
+		var dur time.Duration
+		switch d := dflt.(type) {
+		case SexpStr:
+			var err error
+			dur, err = time.ParseDuration(d.S)
+			if err != nil {
+				return nil, bad
+			}
+		case *SexpInt:
+			dur = time.Duration(d.Val) * time.Second
+		case SexpFloat:
+			dur = time.Duration(d.Val * float64(time.Second))
+		default:
+			return nil, bad
+		}
+		spec.val = fs.Duration(spec.name, dur, help)
+	default:
+		return nil, fmt.Errorf("defflags: flag '%s' has unknown type '%s'; "+
+			"use string, int, bool, float or duration", spec.name, spec.typ)
+	}
+	return spec, nil
+}
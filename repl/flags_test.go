@@ -0,0 +1,52 @@
+package zygo
+
+import (
+	"bytes"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test051DefflagsPrintsUsageAndExits(t *testing.T) {
+
+	cv.Convey(`Given -h or a bad flag, defflags should print usage to`+
+		` *stderr* and call FlagsExit with 0 or 2, as a FlagSet with`+
+		` flag.ExitOnError would exit.`, t, func() {
+
+		var codes []int
+		defer func(orig func(int)) { FlagsExit = orig }(FlagsExit)
+		FlagsExit = func(code int) { codes = append(codes, code) }
+
+		env := NewGlisp()
+		defer env.parser.Stop()
+		env.StandardSetup()
+		var stderr bytes.Buffer
+		env.AddGlobal("*stderr*", NewWritePort("*stderr*", &stderr, nil))
+		env.AddGlobal("*script*", SexpStr{S: "myscript"})
+		defflags := `(defflags [n int 3 "how many"] [name string "bob" "who"])`
+
+		env.SetArgv([]string{"-h"})
+		_, err := env.EvalString(defflags)
+		env.Clear()
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(codes, cv.ShouldResemble, []int{0})
+		usage := stderr.String()
+		cv.So(usage, cv.ShouldStartWith, "Usage of myscript:\n")
+		cv.So(usage, cv.ShouldContainSubstring, "-n int\n    \thow many (default 3)")
+		cv.So(usage, cv.ShouldContainSubstring, "-name string\n    \twho (default \"bob\")")
+
+		stderr.Reset()
+		env.SetArgv([]string{"-bogus", "x"})
+		_, err = env.EvalString(defflags)
+		env.Clear()
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(codes, cv.ShouldResemble, []int{0, 2})
+		cv.So(stderr.String(), cv.ShouldStartWith, "flag provided but not defined: -bogus\nUsage of myscript:\n")
+
+		env.SetArgv([]string{"-n", "7", "rest"})
+		x, err := env.EvalString(`(def fl ` + defflags + `) [(:n fl) (:args fl)]`)
+		panicOn(err)
+		cv.So(x.SexpString(), cv.ShouldEqual, `[7 ["rest"]]`)
+		cv.So(codes, cv.ShouldResemble, []int{0, 2})
+	})
+}
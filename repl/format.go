@@ -0,0 +1,223 @@
+package zygo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// formatVerbs are the Go verbs sprintf passes through to fmt.
+// %S is ours: it formats the SexpString() of its argument.
+const formatVerbs = "vTtbcdoOqxXUeEfFgGsp"
+
+// unboxSexp returns the plain Go value behind x, for handing to
+// fmt. Values without one format as their SexpString().
+func unboxSexp(x Sexp) interface{} {
+	switch t := x.(type) {
+	case *SexpInt:
+		return t.Val
+	case SexpFloat:
+		return t.Val
+	case SexpStr:
+		return t.S
+	case SexpChar:
+		return t.Val
+	case SexpBool:
+		return t.Val
+	case SexpRaw:
+		return []byte(t.Val)
+	case SexpReflect:
+		v := reflect.Value(t)
+		if v.IsValid() && v.CanInterface() {
+			return v.Interface()
+		}
+	}
+	return x.SexpString()
+}
+
+// stringArg gives what %s prints for x: the contents of strings,
+// chars and raw bytes, Go values as fmt prints them, and the
+// SexpString() of anything else.
+func stringArg(x Sexp) interface{} {
+	switch t := x.(type) {
+	case SexpStr:
+		return t.S
+	case SexpChar:
+		return string(t.Val)
+	case SexpRaw:
+		return []byte(t.Val)
+	case SexpReflect:
+		return unboxSexp(x)
+	}
+	return x.SexpString()
+}
+
+// verbSuits says if fmt could apply verb to x, given its output s.
+// Strings and raw bytes are judged by the verb alone, since their
+// contents may themselves start with "%!".
+func verbSuits(verb byte, x Sexp, s string) bool {
+	switch x.(type) {
+	case SexpStr, SexpRaw:
+		return strings.IndexByte("sqvxXT", verb) >= 0
+	}
+	return !strings.HasPrefix(s, "%!")
+}
+
+// SexpFormat implements sprintf: each directive in format is
+// handed to fmt with the unboxed value of the matching argument.
+// A '*' width or precision takes an integer argument. Unlike fmt,
+// a bad verb, a verb that does not suit its argument, or the
+// wrong number of arguments is an error.
+func SexpFormat(name string, format string, args []Sexp) (string, error) {
+	var out bytes.Buffer
+	argi := 0
+	nextArg := func(verb string) (Sexp, error) {
+		if argi >= len(args) {
+			return nil, fmt.Errorf("%s: missing argument for %s in format %q",
+				name, verb, format)
+		}
+		argi++
+		return args[argi-1], nil
+	}
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			out.WriteByte(c)
+			continue
+		}
+		start := i
+		i++
+		if i < len(format) && format[i] == '%' {
+			out.WriteByte('%')
+			continue
+		}
+
+		// flags, width and precision; a '*' is replaced by
+		// the next argument, which must be an integer.
+		var spec bytes.Buffer
+		spec.WriteByte('%')
+		for i < len(format) && strings.IndexByte("+-# 0", format[i]) >= 0 {
+			spec.WriteByte(format[i])
+			i++
+		}
+		for part := 0; part < 2; part++ {
+			if part == 1 {
+				if i >= len(format) || format[i] != '.' {
+					break
+				}
+				spec.WriteByte('.')
+				i++
+			}
+			if i < len(format) && format[i] == '*' {
+				a, err := nextArg("*")
+				if err != nil {
+					return "", err
+				}
+				n, isInt := a.(*SexpInt)
+				if !isInt {
+					return "", fmt.Errorf("%s: '*' in format %q needs an integer, "+
+						"but we had %T / val = '%s'", name, format, a, a.SexpString())
+				}
+				fmt.Fprintf(&spec, "%d", n.Val)
+				i++
+				continue
+			}
+			for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+				spec.WriteByte(format[i])
+				i++
+			}
+		}
+
+		if i >= len(format) {
+			return "", fmt.Errorf("%s: format %q ends in the middle of a directive",
+				name, format)
+		}
+		verb := format[i]
+		directive := format[start : i+1]
+		if verb != 'S' && strings.IndexByte(formatVerbs, verb) < 0 {
+			return "", fmt.Errorf("%s: bad verb %s in format %q", name, directive, format)
+		}
+		a, err := nextArg(directive)
+		if err != nil {
+			return "", err
+		}
+
+		if verb == 'S' {
+			spec.WriteByte('s')
+			fmt.Fprintf(&out, spec.String(), a.SexpString())
+			continue
+		}
+		spec.WriteByte(verb)
+		v := unboxSexp(a)
+		if verb == 's' {
+			v = stringArg(a)
+		}
+		s := fmt.Sprintf(spec.String(), v)
+		if !verbSuits(verb, a, s) {
+			return "", fmt.Errorf("%s: verb %s does not suit %T / val = '%s'",
+				name, directive, a, a.SexpString())
+		}
+		out.WriteString(s)
+	}
+
+	if argi < len(args) {
+		return "", fmt.Errorf("%s: format %q uses %d argument(s), but %d were given",
+			name, format, argi, len(args))
+	}
+	return out.String(), nil
+}
+
+// (sprintf fmt args...) returns the formatted string.
+func SprintfFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 1 {
+		return SexpNull, WrongNargs
+	}
+	format, isStr := args[0].(SexpStr)
+	if !isStr {
+		return SexpNull, fmt.Errorf("%s: format must be a string", name)
+	}
+	s, err := SexpFormat(name, format.S, args[1:])
+	if err != nil {
+		return SexpNull, err
+	}
+	return SexpStr{S: s}, nil
+}
+
+// writerArg returns the io.Writer that x refers to.
+func writerArg(name string, x Sexp) (io.Writer, error) {
+	if p, isPort := x.(*SexpPort); isPort {
+		return p, nil
+	}
+	if r, isReflect := x.(SexpReflect); isReflect {
+		v := reflect.Value(r)
+		if v.IsValid() && v.CanInterface() {
+			if w, isWriter := v.Interface().(io.Writer); isWriter {
+				return w, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s: cannot write to %T / val = '%s'", name, x, x.SexpString())
+}
+
+// (fprintf dest fmt args...) writes the formatted string to dest.
+func FprintfFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 2 {
+		return SexpNull, WrongNargs
+	}
+	w, err := writerArg(name, args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	s, err := SprintfFunction(env, name, args[1:])
+	if err != nil {
+		return SexpNull, err
+	}
+	_, err = io.WriteString(w, s.(SexpStr).S)
+	if err != nil {
+		return SexpNull, err
+	}
+	return SexpNull, nil
+}
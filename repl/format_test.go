@@ -0,0 +1,31 @@
+package zygo
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test035FprintfWritesToAGoWriter(t *testing.T) {
+
+	cv.Convey(`Given a Go io.Writer bound in the environment, fprintf`+
+		` should format with Go verb semantics and write the result`+
+		` to it.`, t, func() {
+
+		env := NewGlisp()
+		defer env.parser.Stop()
+		env.StandardSetup()
+
+		var buf bytes.Buffer
+		env.AddGlobal("buf", SexpReflect(reflect.ValueOf(&buf)))
+
+		_, err := env.EvalString(`(fprintf buf "%05d|%-*s|%S\n" 42 4 "ab" [1 "x"])`)
+		panicOn(err)
+		cv.So(buf.String(), cv.ShouldEqual, "00042|ab  |[1 \"x\"]\n")
+
+		_, err = env.EvalString(`(fprintf 3 "%d" 1)`)
+		cv.So(err, cv.ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,249 @@
+package zygo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// The filesystem builtins below report failures of the filesystem
+// itself, such as a missing file or a permission problem, by
+// returning an error value, which (error? x) detects, rather than
+// stopping the script. Mistakes in the call itself, like a path
+// that is not a string, are raised as usual.
+
+func pathArgs(name string, args []Sexp) ([]string, error) {
+	paths := make([]string, len(args))
+	for i, a := range args {
+		s, isStr := a.(SexpStr)
+		if !isStr {
+			return nil, fmt.Errorf("%s: argument %d must be a string path, "+
+				"but we had %T / val = '%s'", name, i+1, a, a.SexpString())
+		}
+		paths[i] = s.S
+	}
+	return paths, nil
+}
+
+// statRecord describes a file as a (stat ...) record.
+func statRecord(env *Glisp, path string, fi os.FileInfo) (Sexp, error) {
+	return MakeHash([]Sexp{
+		env.MakeSymbol("path"), SexpStr{S: path},
+		env.MakeSymbol("name"), SexpStr{S: fi.Name()},
+		env.MakeSymbol("size"), &SexpInt{Val: fi.Size()},
+		env.MakeSymbol("mode"), SexpStr{S: fi.Mode().String()},
+		env.MakeSymbol("perm"), &SexpInt{Val: int64(fi.Mode().Perm())},
+		env.MakeSymbol("modtime"), SexpTime(fi.ModTime()),
+		env.MakeSymbol("isdir"), SexpBool{Val: fi.IsDir()},
+	}, "stat", env)
+}
+
+// (ls [dir]) returns the sorted names in dir, by default the
+// current directory. (read-dir dir) returns a stat record for
+// each entry instead.
+func ReadDirFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) > 1 || (name == "read-dir" && len(args) != 1) {
+		return SexpNull, WrongNargs
+	}
+	paths, err := pathArgs(name, args)
+	if err != nil {
+		return SexpNull, err
+	}
+	dir := "."
+	if len(paths) == 1 {
+		dir = paths[0]
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return SexpError{err}, nil
+	}
+
+	res := make([]Sexp, len(infos))
+	for i, fi := range infos {
+		if name == "ls" {
+			res[i] = SexpStr{S: fi.Name()}
+			continue
+		}
+		res[i], err = statRecord(env, filepath.Join(dir, fi.Name()), fi)
+		if err != nil {
+			return SexpNull, err
+		}
+	}
+	return &SexpArray{Val: res}, nil
+}
+
+// (stat path) returns a record with the path, name, size, mode,
+// perm, modtime and isdir of a file.
+func StatFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	paths, err := pathArgs(name, args)
+	if err != nil {
+		return SexpNull, err
+	}
+	fi, err := os.Stat(paths[0])
+	if err != nil {
+		return SexpError{err}, nil
+	}
+	return statRecord(env, paths[0], fi)
+}
+
+// (mkdir-p path), (rm path [recursive]) and (rename from to)
+// return nil on success.
+func FileOpFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	nargs := 1
+	if name == "rename" {
+		nargs = 2
+	}
+	if len(args) < nargs || len(args) > 2 {
+		return SexpNull, WrongNargs
+	}
+	paths, err := pathArgs(name, args[:nargs])
+	if err != nil {
+		return SexpNull, err
+	}
+
+	switch name {
+	case "mkdir-p":
+		if len(args) != 1 {
+			return SexpNull, WrongNargs
+		}
+		err = os.MkdirAll(paths[0], 0777)
+	case "rm":
+		if len(args) == 2 && IsTruthy(args[1]) {
+			err = os.RemoveAll(paths[0])
+		} else {
+			err = os.Remove(paths[0])
+		}
+	case "rename":
+		err = os.Rename(paths[0], paths[1])
+	}
+	if err != nil {
+		return SexpError{err}, nil
+	}
+	return SexpNull, nil
+}
+
+// (glob pattern) returns the sorted paths matching pattern.
+func GlobFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	paths, err := pathArgs(name, args)
+	if err != nil {
+		return SexpNull, err
+	}
+	matches, err := filepath.Glob(paths[0])
+	if err != nil {
+		return SexpError{err}, nil
+	}
+	sort.Strings(matches)
+	return stringsToArray(matches), nil
+}
+
+// (walk root f) calls (f path stat) for root and everything below
+// it, in lexical order. If f returns false for a directory, walk
+// does not descend into it.
+func WalkFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	paths, err := pathArgs(name, args[:1])
+	if err != nil {
+		return SexpNull, err
+	}
+	fun, isFun := args[1].(*SexpFunction)
+	if !isFun {
+		return SexpNull, fmt.Errorf("%s: second argument must be a function", name)
+	}
+
+	// errors from f are the script's own, and are raised;
+	// errors from the filesystem are returned as data.
+	var callErr error
+	err = filepath.Walk(paths[0], func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		st, err := statRecord(env, path, fi)
+		if err != nil {
+			callErr = err
+			return err
+		}
+		res, err := env.Apply(fun, []Sexp{SexpStr{S: path}, st})
+		if err != nil {
+			callErr = err
+			return err
+		}
+		if b, isBool := res.(SexpBool); isBool && !b.Val && fi.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if callErr != nil {
+		return SexpNull, callErr
+	}
+	if err != nil {
+		return SexpError{err}, nil
+	}
+	return SexpNull, nil
+}
+
+// (tempdir [prefix]) makes a new temporary directory and returns
+// its path.
+func TempdirFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) > 1 {
+		return SexpNull, WrongNargs
+	}
+	paths, err := pathArgs(name, args)
+	if err != nil {
+		return SexpNull, err
+	}
+	prefix := "zygo"
+	if len(paths) == 1 {
+		prefix = paths[0]
+	}
+	dir, err := ioutil.TempDir("", prefix)
+	if err != nil {
+		return SexpError{err}, nil
+	}
+	return SexpStr{S: dir}, nil
+}
+
+// (path-join a b ...), (basename p), (dirname p), (ext p) and
+// (abs p) wrap filepath.Join, Base, Dir, Ext and Abs.
+func PathFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if name == "path-join" {
+		paths, err := pathArgs(name, args)
+		if err != nil {
+			return SexpNull, err
+		}
+		return SexpStr{S: filepath.Join(paths...)}, nil
+	}
+
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	paths, err := pathArgs(name, args)
+	if err != nil {
+		return SexpNull, err
+	}
+	p := paths[0]
+	switch name {
+	case "basename":
+		return SexpStr{S: filepath.Base(p)}, nil
+	case "dirname":
+		return SexpStr{S: filepath.Dir(p)}, nil
+	case "ext":
+		return SexpStr{S: filepath.Ext(p)}, nil
+	case "abs":
+		a, err := filepath.Abs(p)
+		if err != nil {
+			return SexpError{err}, nil
+		}
+		return SexpStr{S: a}, nil
+	}
+	return SexpNull, fmt.Errorf("unknown function %s", name)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"reflect"
 	"runtime"
 )
 
@@ -224,6 +225,11 @@ func ArrayAccessFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 	switch t := args[0].(type) {
 	case *SexpArray:
 		arr = t
+	case *SexpPVec:
+		if name == "aset!" {
+			return SexpNull, errors.New("aset! cannot modify a pvec; use assoc")
+		}
+		arr = &SexpArray{Val: t.Elems()}
 	default:
 		return SexpNull, errors.New("First argument of aget must be array")
 	}
@@ -305,6 +311,8 @@ func HashAccessFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 	switch e := args[0].(type) {
 	case *SexpHash:
 		hash = e
+	case *SexpPMap:
+		return pmapAccessFunction(e, name, args)
 	default:
 		return SexpNull, errors.New("first argument of to h* function must be hash")
 	}
@@ -433,6 +441,12 @@ func LenFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 		return &SexpInt{Val: int64(len(t.S))}, nil
 	case *SexpHash:
 		return &SexpInt{Val: int64(HashCountKeys(t))}, nil
+	case *SexpPVec:
+		return &SexpInt{Val: int64(t.Len())}, nil
+	case *SexpPMap:
+		return &SexpInt{Val: int64(t.Len())}, nil
+	case *SexpSet:
+		return &SexpInt{Val: int64(t.NumKeys)}, nil
 	case SexpPair:
 		n, err := ListLen(t)
 		return &SexpInt{Val: int64(n)}, err
@@ -526,6 +540,12 @@ func TypeQueryFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 
 	switch name {
 	case "type?":
+		// types made by var may be known only to this env
+		if r, isReflect := args[0].(SexpReflect); isReflect {
+			if rt := env.Registry().Lookup(reflectName(reflect.Value(r))); rt != nil {
+				return SexpStr{S: rt.RegisteredName}, nil
+			}
+		}
 		return TypeOf(args[0]), nil
 	case "list?":
 		result = IsList(args[0])
@@ -553,16 +573,30 @@ func TypeQueryFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 		result = IsEmpty(args[0])
 	case "func?":
 		result = IsFunc(args[0])
+	case "error?":
+		result = IsError(args[0])
 	}
 
 	return SexpBool{Val: result}, nil
 }
 
+// (print [port] x), (println [port] x) and (printf [port] fmt args...)
+// write to port, or else to the current *stdout*.
 func PrintFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 	if len(args) < 1 {
 		return SexpNull, WrongNargs
 	}
 
+	port, isPort := args[0].(*SexpPort)
+	if isPort {
+		args = args[1:]
+		if len(args) < 1 {
+			return SexpNull, WrongNargs
+		}
+	} else {
+		port = env.StdoutPort()
+	}
+
 	var str string
 
 	switch expr := args[0].(type) {
@@ -572,22 +606,22 @@ func PrintFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 		str = expr.SexpString()
 	}
 
+	var err error
 	switch name {
 	case "println":
-		fmt.Println(str)
+		_, err = fmt.Fprintln(port, str)
 	case "print":
-		fmt.Print(str)
+		_, err = fmt.Fprint(port, str)
 	case "printf":
-		if len(args) == 1 {
-			fmt.Printf(str)
-		} else {
-			ar := make([]interface{}, len(args)-1)
-			for i := 0; i < len(ar); i++ {
-				ar[i] = args[i+1]
-			}
-			fmt.Printf(str, ar...)
+		var out string
+		out, err = SexpFormat(name, str, args[1:])
+		if err == nil {
+			_, err = fmt.Fprint(port, out)
 		}
 	}
+	if err != nil {
+		return SexpNull, err
+	}
 
 	return SexpNull, nil
 }
@@ -792,12 +826,51 @@ func MergeFuncMap(funcs ...map[string]GlispUserFunction) map[string]GlispUserFun
 	return n
 }
 
+// ShadowableFunctions returns the sequence, persistent and set
+// builtins, the string library and the file system and process
+// helpers. Scripts written before these existed often define their
+// own filter, reduce, union, join, title, abs, wait and so on, so
+// they are bound as ordinary globals that defn and def may shadow,
+// rather than as protected built-ins. The iterator protocol that
+// doseq compiles to stays protected.
+func ShadowableFunctions() map[string]GlispUserFunction {
+	m := MergeFuncMap(
+		SequenceFunctions(),
+		PersistentFunctions(),
+		SetFunctions(),
+	)
+	for _, name := range []string{"__range", "iter", "__doseq-iter", "iter-next!", "iter-val", "iter-close"} {
+		delete(m, name)
+	}
+	pickFuncs(m, StrFunctions(), "upper", "lower", "title", "fields",
+		"string-reverse", "starts-with?", "ends-with?", "trim-prefix",
+		"trim-suffix", "trim-chars", "replace", "replace-all", "index-of",
+		"last-index-of", "repeat", "pad-left", "pad-right", "join",
+		"substr", "contains?")
+	pickFuncs(m, SystemFunctions(), "ls", "read-dir", "stat", "mkdir-p",
+		"rm", "rename", "glob", "walk", "tempdir", "basename", "dirname",
+		"ext", "abs")
+	pickFuncs(m, SystemFunctions(), "exec", "pipeline", "spawn", "wait",
+		"kill", "pid")
+	return m
+}
+
+// pickFuncs copies the functions named from src into dst.
+func pickFuncs(dst, src map[string]GlispUserFunction, names ...string) {
+	for _, name := range names {
+		dst[name] = src[name]
+	}
+}
+
 // SandboxSafeFuncs returns all functions that are safe to run in a sandbox
 func SandboxSafeFunctions() map[string]GlispUserFunction {
 	return MergeFuncMap(
 		CoreFunctions(),
 		StrFunctions(),
 		EncodingFunctions(),
+		SequenceFunctions(),
+		PersistentFunctions(),
+		SetFunctions(),
 	)
 }
 
@@ -807,6 +880,9 @@ func AllBuiltinFunctions() map[string]GlispUserFunction {
 		CoreFunctions(),
 		StrFunctions(),
 		EncodingFunctions(),
+		SequenceFunctions(),
+		PersistentFunctions(),
+		SetFunctions(),
 		SystemFunctions(),
 		ReflectionFunctions(),
 	)
@@ -856,6 +932,7 @@ func CoreFunctions() map[string]GlispUserFunction {
 		"zero?":      TypeQueryFunction,
 		"empty?":     TypeQueryFunction,
 		"func?":      TypeQueryFunction,
+		"error?":     TypeQueryFunction,
 		"not":        NotFunction,
 		"apply":      ApplyFunction,
 		"map":        MapFunction,
@@ -901,11 +978,36 @@ func StrFunctions() map[string]GlispUserFunction {
 		"println": PrintFunction,
 		"print":   PrintFunction,
 		"printf":  PrintFunction,
+		"sprintf": SprintfFunction,
 		"raw2str": RawToStringFunction,
 		"str2sym": Str2SymFunction,
 		"sym2str": Sym2StrFunction,
 		"gensym":  GensymFunction,
 		"symnum":  SymnumFunction,
+
+		"upper":           StringCaseFunction,
+		"lower":           StringCaseFunction,
+		"title":           StringCaseFunction,
+		"fields":          StringCaseFunction,
+		"string-reverse":  StringCaseFunction,
+		"starts-with?":    StringAffixFunction,
+		"ends-with?":      StringAffixFunction,
+		"trim-prefix":     StringAffixFunction,
+		"trim-suffix":     StringAffixFunction,
+		"trim-chars":      StringAffixFunction,
+		"replace":         StringReplaceFunction,
+		"replace-all":     StringReplaceFunction,
+		"index-of":        StringIndexFunction,
+		"last-index-of":   StringIndexFunction,
+		"repeat":          StringRepeatFunction,
+		"pad-left":        StringPadFunction,
+		"pad-right":       StringPadFunction,
+		"join":            StringJoinFunction,
+		"substr":          SubstrFunction,
+		"template-parse":  TemplateParseFunction,
+		"template-render": TemplateRenderFunction,
+		// contains? also tests membership in sets and hashes
+		"contains?": ContainsFunction,
 	}
 
 }
@@ -917,6 +1019,9 @@ func EncodingFunctions() map[string]GlispUserFunction {
 		"msgpack":   JsonFunction,
 		"unmsgpack": JsonFunction,
 		"gob":       GobEncodeFunction,
+		"ungob":     GobDecodeFunction,
+		"decode":    DecodeFunction,
+		"encode":    EncodeFunction,
 		"msgmap":    ConstructorFunction,
 	}
 }
@@ -925,22 +1030,73 @@ func ReflectionFunctions() map[string]GlispUserFunction {
 	return map[string]GlispUserFunction{
 		"methodls": GoMethodListFunction,
 		"_method":  CallGoMethodFunction,
+
+		"golive":       GoLiveFunction,
+		"golive?":      GoLiveFunction,
+		"sync-to-go":   SyncGoFunction,
+		"sync-from-go": SyncGoFunction,
+		"togo-source":  TogoSourceFunction,
+		"satisfies?":   SatisfiesFunction,
 	}
 }
 
 func SystemFunctions() map[string]GlispUserFunction {
 	return map[string]GlispUserFunction{
-		"source":    SourceFileFunction,
-		"togo":      ToGoFunction,
-		"dump":      GoonDumpFunction,
-		"slurpf":    SlurpfileFunction,
-		"writef":    WriteToFileFunction,
-		"owritef":   WriteToFileFunction,
-		"system":    SystemFunction,
-		"exit":      ExitFunction,
-		"_closdump": DumpClosureEnvFunction,
-		"rmsym":     RemoveSymFunction,
-		"typelist":  TypeListFunction,
+		"source":         SourceFileFunction,
+		"__import":       ImportFunction,
+		"__ns":           NsFunction,
+		"__export":       ExportFunction,
+		"module-exports": ModuleExportsFunction,
+		"togo":           ToGoFunction,
+		"dump":           GoonDumpFunction,
+		"slurpf":         SlurpfileFunction,
+		"writef":         WriteToFileFunction,
+		"owritef":        WriteToFileFunction,
+		"fprintf":        FprintfFunction,
+		"template-load":  TemplateLoadFunction,
+		"open":           OpenFunction,
+		"__write":        WriteFunction,
+		"__write-sym":    WriteSymFunction,
+		"read-line":      PortReadFunction,
+		"read-bytes":     PortReadFunction,
+		"read-all":       PortReadFunction,
+		"flush":          PortFlushCloseFunction,
+		"close":          PortFlushCloseFunction,
+		"__with-open":    WithOpenFunction,
+		"json-decoder":   StreamFunction,
+		"json-encoder":   StreamFunction,
+		"msgp-reader":    StreamFunction,
+		"msgp-writer":    StreamFunction,
+		"ls":             ReadDirFunction,
+		"read-dir":       ReadDirFunction,
+		"stat":           StatFunction,
+		"mkdir-p":        FileOpFunction,
+		"rm":             FileOpFunction,
+		"rename":         FileOpFunction,
+		"glob":           GlobFunction,
+		"walk":           WalkFunction,
+		"tempdir":        TempdirFunction,
+		"path-join":      PathFunction,
+		"basename":       PathFunction,
+		"dirname":        PathFunction,
+		"ext":            PathFunction,
+		"abs":            PathFunction,
+		"system":         SystemFunction,
+		"exec":           ExecFunction,
+		"pipeline":       PipelineFunction,
+		"spawn":          SpawnFunction,
+		"wait":           ProcessFunction,
+		"kill":           ProcessFunction,
+		"pid":            ProcessFunction,
+		"exit":           ExitFunction,
+		"getenv":         GetenvFunction,
+		"setenv":         GetenvFunction,
+		"unsetenv":       GetenvFunction,
+		"environ":        EnvironFunction,
+		"__defflags":     DefflagsFunction,
+		"_closdump":      DumpClosureEnvFunction,
+		"rmsym":          RemoveSymFunction,
+		"typelist":       TypeListFunction,
 		// not done "_call":     CallZMethodOnRecordFunction,
 	}
 }
@@ -1055,6 +1211,10 @@ func GenericAccessFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 		return HashAccessFunction(env, name, args)
 	case *SexpArray:
 		return ArrayAccessFunction(env, name, args)
+	case *SexpPVec:
+		return ArrayAccessFunction(env, name, args)
+	case *SexpPMap:
+		return HashAccessFunction(env, name, args)
 	}
 	return SexpNull, errors.New("first argument of to hget function must be hash or array")
 }
@@ -1232,6 +1392,25 @@ func dotGetSetHelper(env *Glisp, name string, setVal *Sexp) (Sexp, error) {
 		Q("\n in dotGetSetHelper(), '%s' not found\n", key)
 		return SexpNull, err
 	}
+
+	// .m.name reaches into an imported module the way
+	// .h.field reaches into a record.
+	for lenpath > 1 {
+		mod, isMod := ret.(*SexpModule)
+		if !isMod {
+			break
+		}
+		if setVal != nil && lenpath == 2 {
+			return SexpNull, fmt.Errorf("cannot set '%s': a module's "+
+				"definitions are read-only outside it", name)
+		}
+		ret, err = mod.Lookup(env, path[1][1:])
+		if err != nil {
+			return SexpNull, err
+		}
+		path = path[1:]
+		lenpath--
+	}
 	if lenpath == 1 {
 		// single path element get, return it.
 		return ret, err
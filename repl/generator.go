@@ -575,6 +575,8 @@ func (gen *Generator) GenerateCallBySymbol(sym SexpSymbol, args []Sexp, orig Sex
 		return gen.GenerateInclude(args)
 	case "for":
 		return gen.GenerateForLoop(args)
+	case "doseq":
+		return gen.GenerateDoseq(args)
 	case "set":
 		return gen.GenerateSet(args)
 	case "break":
@@ -935,6 +937,62 @@ func (gen *Generator) GenerateForLoop(args []Sexp) error {
 	return nil
 }
 
+// (doseq [x seq] (expr)*) runs the body once for each element
+// of seq, which may be anything that MakeIterator accepts,
+// including lazy sequences and channels. (doseq [[k v] seq] ...)
+// binds the parts of each element, handy for hashes.
+//
+// doseq compiles to a plain for loop over an iterator, so
+// elements are pulled one at a time and break and continue
+// work as usual:
+//
+//	(let [it (__doseq-iter seq)]
+//	  (for [nil (iter-next! it) nil]
+//	    (def x (iter-val it)) (expr)*)
+//	  (iter-close it))
+//
+// If the body fails, Run closes the iterator instead.
+func (gen *Generator) GenerateDoseq(args []Sexp) error {
+	if len(args) < 1 {
+		return errors.New("malformed doseq")
+	}
+	control, isArray := args[0].(*SexpArray)
+	if !isArray || len(control.Val) != 2 {
+		return errors.New("doseq: first argument must be a vector of [binding sequence]")
+	}
+
+	env := gen.env
+	it := env.GenSymbol("__doseq")
+	val := MakeList([]Sexp{env.MakeSymbol("iter-val"), it})
+
+	var bind Sexp
+	switch target := control.Val[0].(type) {
+	case SexpSymbol:
+		bind = MakeList([]Sexp{env.MakeSymbol("def"), target, val})
+	case *SexpArray:
+		parts := make([]Sexp, 0, len(target.Val)+2)
+		parts = append(parts, env.MakeSymbol("mdef"))
+		parts = append(parts, target.Val...)
+		parts = append(parts, val)
+		bind = MakeList(parts)
+	default:
+		return errors.New("doseq: binding must be a symbol or a vector of symbols")
+	}
+
+	loopCtl := &SexpArray{Val: []Sexp{SexpNull,
+		MakeList([]Sexp{env.MakeSymbol("iter-next!"), it}), SexpNull}}
+	body := append([]Sexp{env.MakeSymbol("for"), loopCtl, bind}, args[1:]...)
+
+	expr := MakeList([]Sexp{
+		env.MakeSymbol("let"),
+		&SexpArray{Val: []Sexp{it,
+			MakeList([]Sexp{env.MakeSymbol("__doseq-iter"), control.Val[1]})}},
+		MakeList(body),
+		MakeList([]Sexp{env.MakeSymbol("iter-close"), it}),
+	})
+	return gen.Generate(expr)
+}
+
 func (gen *Generator) GenerateSet(args []Sexp) error {
 	narg := len(args)
 	if narg != 2 {
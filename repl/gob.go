@@ -4,38 +4,269 @@ import (
 	"bytes"
 	"encoding/gob"
 	"fmt"
+	"reflect"
+	"time"
 )
 
-func GobEncodeFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
-	if len(args) != 1 {
-		return SexpNull, WrongNargs
+// GobSexp is the gob-friendly form of a zygo value. Go code that
+// already persists with gob can hold GobSexp in its own structs,
+// or use GobEncodeSexp and GobDecodeSexp for the bytes.
+//
+// Kind says which of the other fields are used:
+//
+//	int, char     Int
+//	float         Float
+//	bool          Bool
+//	string        Str, and Bool if it was a `backtick` string
+//	symbol        Str
+//	raw, time     Bytes
+//	null          nothing
+//	list          Elems holds the heads then the final tail
+//	array, set,
+//	pvec          Elems
+//	hash, pmap    Elems holds key, value, key, value, ...; a hash
+//	              keeps its key order and TypeName
+type GobSexp struct {
+	Kind     string
+	Int      int64
+	Float    float64
+	Bool     bool
+	Str      string
+	Bytes    []byte
+	TypeName string
+	Elems    []GobSexp
+}
+
+func init() {
+	// a fixed name, so gob streams holding a GobSexp in an
+	// interface{} don't depend on the package path.
+	gob.RegisterName("zygo.GobSexp", GobSexp{})
+}
+
+// ToGobSexp converts x to its gob-friendly form. Functions, ports
+// and other values that only make sense in a running env are
+// refused.
+func ToGobSexp(x Sexp) (GobSexp, error) {
+	switch e := x.(type) {
+	case *SexpInt:
+		return GobSexp{Kind: "int", Int: e.Val}, nil
+	case SexpChar:
+		return GobSexp{Kind: "char", Int: int64(e.Val)}, nil
+	case SexpFloat:
+		return GobSexp{Kind: "float", Float: e.Val}, nil
+	case SexpBool:
+		return GobSexp{Kind: "bool", Bool: e.Val}, nil
+	case SexpStr:
+		return GobSexp{Kind: "string", Str: e.S, Bool: e.backtick}, nil
+	case SexpSymbol:
+		return GobSexp{Kind: "symbol", Str: e.name}, nil
+	case SexpRaw:
+		return GobSexp{Kind: "raw", Bytes: e.Val}, nil
+	case SexpTime:
+		by, err := time.Time(e).MarshalBinary()
+		if err != nil {
+			return GobSexp{}, err
+		}
+		return GobSexp{Kind: "time", Bytes: by}, nil
+	case SexpSentinel:
+		if e == SexpNull {
+			return GobSexp{Kind: "null"}, nil
+		}
+	case SexpPair:
+		g := GobSexp{Kind: "list"}
+		var tail Sexp = e
+		for {
+			pair, isPair := tail.(SexpPair)
+			if !isPair {
+				break
+			}
+			h, err := ToGobSexp(pair.Head)
+			if err != nil {
+				return GobSexp{}, err
+			}
+			g.Elems = append(g.Elems, h)
+			tail = pair.Tail
+		}
+		t, err := ToGobSexp(tail)
+		if err != nil {
+			return GobSexp{}, err
+		}
+		g.Elems = append(g.Elems, t)
+		return g, nil
+	case *SexpArray:
+		return gobElems("array", e.Val)
+	case *SexpSet:
+		return gobElems("set", e.Elems())
+	case *SexpPVec:
+		return gobElems("pvec", e.Elems())
+	case *SexpPMap:
+		var kv []Sexp
+		for _, p := range e.Pairs() {
+			kv = append(kv, p.Head, p.Tail)
+		}
+		return gobElems("pmap", kv)
+	case *SexpHash:
+		kv := make([]Sexp, 0, 2*len(e.KeyOrder))
+		for _, key := range e.KeyOrder {
+			val, err := e.HashGet(nil, key)
+			if err != nil {
+				return GobSexp{}, err
+			}
+			kv = append(kv, key, val)
+		}
+		g, err := gobElems("hash", kv)
+		g.TypeName = e.TypeName
+		return g, err
+	}
+	return GobSexp{}, fmt.Errorf("cannot gob a %T", x)
+}
+
+func gobElems(kind string, elems []Sexp) (GobSexp, error) {
+	g := GobSexp{Kind: kind, Elems: make([]GobSexp, len(elems))}
+	for i, x := range elems {
+		var err error
+		g.Elems[i], err = ToGobSexp(x)
+		if err != nil {
+			return GobSexp{}, err
+		}
+	}
+	return g, nil
+}
+
+// ToSexp rebuilds the value g was made from. Symbols are interned
+// in env, and records are remade with MakeHash so that they pick
+// up the struct registered under their TypeName.
+func (g *GobSexp) ToSexp(env *Glisp) (Sexp, error) {
+	switch g.Kind {
+	case "int":
+		return &SexpInt{Val: g.Int}, nil
+	case "char":
+		return SexpChar{Val: rune(g.Int)}, nil
+	case "float":
+		return SexpFloat{Val: g.Float}, nil
+	case "bool":
+		return SexpBool{Val: g.Bool}, nil
+	case "string":
+		return SexpStr{S: g.Str, backtick: g.Bool}, nil
+	case "symbol":
+		return env.MakeSymbol(g.Str), nil
+	case "raw":
+		return SexpRaw{Val: g.Bytes}, nil
+	case "time":
+		var t time.Time
+		if err := t.UnmarshalBinary(g.Bytes); err != nil {
+			return SexpNull, err
+		}
+		return SexpTime(t), nil
+	case "null":
+		return SexpNull, nil
 	}
 
-	h, isHash := args[0].(*SexpHash)
-	if !isHash {
-		return SexpNull, fmt.Errorf("gob argument must be a hash or defmap")
+	elems := make([]Sexp, len(g.Elems))
+	for i := range g.Elems {
+		var err error
+		elems[i], err = g.Elems[i].ToSexp(env)
+		if err != nil {
+			return SexpNull, err
+		}
+	}
+	switch g.Kind {
+	case "list":
+		if len(elems) == 0 {
+			return SexpNull, fmt.Errorf("gob list has no tail")
+		}
+		res := elems[len(elems)-1]
+		for i := len(elems) - 2; i >= 0; i-- {
+			res = Cons(elems[i], res)
+		}
+		return res, nil
+	case "array":
+		return &SexpArray{Val: elems}, nil
+	case "set":
+		s := NewSet()
+		for _, x := range elems {
+			if err := s.Add(x); err != nil {
+				return SexpNull, err
+			}
+		}
+		return s, nil
+	case "pvec":
+		return NewPVec(elems), nil
+	case "pmap":
+		m := NewPMap()
+		for i := 0; i+1 < len(elems); i += 2 {
+			var err error
+			m, err = m.Assoc(elems[i], elems[i+1])
+			if err != nil {
+				return SexpNull, err
+			}
+		}
+		return m, nil
+	case "hash":
+		return MakeHash(elems, g.TypeName, env)
 	}
+	return SexpNull, fmt.Errorf("unknown gob kind '%s'", g.Kind)
+}
 
-	// fill the go shadow struct
-	_, err := ToGoFunction(env, "togo", []Sexp{h})
+// GobEncodeSexp returns the gob encoding of x.
+func GobEncodeSexp(x Sexp) ([]byte, error) {
+	g, err := ToGobSexp(x)
 	if err != nil {
-		return SexpNull, fmt.Errorf("error converting object to Go struct: '%s'", err)
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = gob.NewEncoder(&buf).Encode(&g); err != nil {
+		return nil, err
 	}
+	return buf.Bytes(), nil
+}
 
-	// serialize to gob
-	var gobBytes bytes.Buffer
+// GobDecodeSexp reads back a value written by GobEncodeSexp.
+func GobDecodeSexp(env *Glisp, by []byte) (Sexp, error) {
+	var g GobSexp
+	if err := gob.NewDecoder(bytes.NewBuffer(by)).Decode(&g); err != nil {
+		return SexpNull, err
+	}
+	return g.ToSexp(env)
+}
 
-	enc := gob.NewEncoder(&gobBytes)
-	err = enc.Encode(h.GoShadowStruct)
+// (gob value) returns the gob encoding of value as raw bytes. A
+// record with a Go struct behind it is encoded as that struct, as
+// filled by togo, so that Go code can decode it straight into its
+// own type; other values, including records inside them, are
+// encoded as a GobSexp. ungob reads back either form.
+func GobEncodeFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	if h, isHash := args[0].(*SexpHash); isHash {
+		if rt := env.Registry().Lookup(h.TypeName); rt != nil && rt.hasShadowStruct {
+			// fill the go shadow struct
+			_, err := ToGoFunction(env, "togo", []Sexp{h})
+			if err != nil {
+				return SexpNull, fmt.Errorf("error converting object to Go struct: '%s'", err)
+			}
+			var buf bytes.Buffer
+			if err = gob.NewEncoder(&buf).Encode(h.GoShadowStruct); err != nil {
+				return SexpNull, fmt.Errorf("gob encode error: '%s'", err)
+			}
+			return SexpRaw{Val: buf.Bytes()}, nil
+		}
+	}
+	by, err := GobEncodeSexp(args[0])
 	if err != nil {
 		return SexpNull, fmt.Errorf("gob encode error: '%s'", err)
 	}
-
-	return SexpRaw{Val: gobBytes.Bytes()}, nil
+	return SexpRaw{Val: by}, nil
 }
 
+// (ungob raw) turns bytes made by gob back into a value. Bytes that
+// hold a Go struct, as gob makes of a record with a Go struct, or Go
+// code makes of its own, become a record of the type registered for
+// that struct. (ungob raw type) names the record type, for when
+// several registered Go structs share the struct's name.
 func GobDecodeFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
-	if len(args) != 1 {
+	if len(args) != 1 && len(args) != 2 {
 		return SexpNull, WrongNargs
 	}
 
@@ -43,17 +274,116 @@ func GobDecodeFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 	if !isRaw {
 		return SexpNull, fmt.Errorf("ungob argument must be raw []byte")
 	}
+	if len(args) == 2 {
+		rt, isRt := args[1].(*RegisteredType)
+		if !isRt || !rt.hasShadowStruct {
+			return SexpNull, fmt.Errorf("ungob: second argument must be a record type with a Go struct, but we had '%s'",
+				args[1].SexpString())
+		}
+		return gobDecodeRecord(env, rt, raw.Val)
+	}
+	if sname, isStruct := gobStructName(raw.Val); isStruct && sname != "GobSexp" {
+		rt, err := gobRecordType(env, sname)
+		if err != nil {
+			return SexpNull, fmt.Errorf("ungob: %v", err)
+		}
+		return gobDecodeRecord(env, rt, raw.Val)
+	}
+	x, err := GobDecodeSexp(env, raw.Val)
+	if err != nil {
+		return SexpNull, fmt.Errorf("gob decode error: '%s'", err)
+	}
+	return x, nil
+}
 
-	rawBuf := bytes.NewBuffer(raw.Val)
-	dec := gob.NewDecoder(rawBuf)
-	var iface interface{}
-	err := dec.Decode(iface)
+// gobDecodeRecord decodes by into a fresh Go struct of type rt, and
+// returns a record of that type filled from it.
+func gobDecodeRecord(env *Glisp, rt *RegisteredType, by []byte) (Sexp, error) {
+	shadow, err := rt.Factory(env)
 	if err != nil {
+		return SexpNull, err
+	}
+	if err = gob.NewDecoder(bytes.NewBuffer(by)).Decode(shadow); err != nil {
 		return SexpNull, fmt.Errorf("gob decode error: '%s'", err)
 	}
+	h, err := MakeHash(nil, rt.RegisteredName, env)
+	if err != nil {
+		return SexpNull, err
+	}
+	if err = h.FillHashFromShadow(env, shadow); err != nil {
+		return SexpNull, err
+	}
+	return h, nil
+}
 
-	// TODO convert to hash
-	panic("not done yet!")
+// gobRecordType finds the one record type whose Go struct is named
+// sname.
+func gobRecordType(env *Glisp, sname string) (*RegisteredType, error) {
+	reg := env.Registry()
+	var found *RegisteredType
+	for _, tn := range reg.Names() {
+		rt := reg.Lookup(tn)
+		if rt == nil || !rt.hasShadowStruct || rt == found {
+			continue
+		}
+		t := rt.TypeCache
+		if t == nil || t.Kind() != reflect.Ptr || t.Elem().Name() != sname {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("record types '%s' and '%s' both have a Go struct named %s; "+
+				"use (ungob raw type)", found.RegisteredName, rt.RegisteredName, sname)
+		}
+		found = rt
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no record type has a Go struct named %s", sname)
+	}
+	return found, nil
+}
 
-	//return SexpNull, nil
+// gobStructName reads the name of the struct type defined at the
+// start of a gob stream. A stream begins with the definition of the
+// type of the value encoded: a message holding a negative type id
+// and a wireType, whose StructT field (field 3) starts with the
+// CommonType (field 1) and its Name (field 1). See the encoding/gob
+// documentation.
+func gobStructName(by []byte) (string, bool) {
+	var ok bool
+	next := func() uint64 {
+		if !ok || len(by) == 0 {
+			ok = false
+			return 0
+		}
+		b := by[0]
+		by = by[1:]
+		if b < 0x80 {
+			return uint64(b)
+		}
+		n := int(-int8(b))
+		if n > 8 || n > len(by) {
+			ok = false
+			return 0
+		}
+		var u uint64
+		for _, c := range by[:n] {
+			u = u<<8 | uint64(c)
+		}
+		by = by[n:]
+		return u
+	}
+	ok = true
+	next() // message length
+	if id := next(); id&1 == 0 {
+		// a value, not a type definition
+		return "", false
+	}
+	if next() != 3 || next() != 1 || next() != 1 {
+		return "", false
+	}
+	n := next()
+	if !ok || n > uint64(len(by)) {
+		return "", false
+	}
+	return string(by[:n]), true
 }
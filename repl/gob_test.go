@@ -0,0 +1,73 @@
+package zygo
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test041GobSexpInsideAGoStructRoundTrips(t *testing.T) {
+
+	cv.Convey(`Given a Go struct holding a GobSexp, encoding it with gob`+
+		` and decoding it into a fresh env should give back an equal value.`, t, func() {
+
+		env := NewGlisp()
+		defer env.parser.Stop()
+		env.StandardSetup()
+		x, err := env.EvalString(`(hash name:"pat" tags:['a 'b] score:9.5 nest:(hash n:1))`)
+		panicOn(err)
+
+		type saved struct {
+			Id    int
+			State GobSexp
+		}
+		g, err := ToGobSexp(x)
+		panicOn(err)
+		var buf bytes.Buffer
+		panicOn(gob.NewEncoder(&buf).Encode(saved{Id: 3, State: g}))
+
+		env2 := NewGlisp()
+		defer env2.parser.Stop()
+		env2.StandardSetup()
+		var back saved
+		panicOn(gob.NewDecoder(&buf).Decode(&back))
+		y, err := back.State.ToSexp(env2)
+		panicOn(err)
+
+		cv.So(back.Id, cv.ShouldEqual, 3)
+		cv.So(SexpToJson(y), cv.ShouldEqual, SexpToJson(x))
+	})
+}
+
+func Test046GobOfAGoBackedRecordIsItsGoStruct(t *testing.T) {
+
+	cv.Convey(`Given a record with a Go struct, gob should encode the Go`+
+		` struct itself, and ungob should read Go's encoding of one back`+
+		` as the record type registered for it.`, t, func() {
+
+		env := NewGlisp()
+		defer env.parser.Stop()
+		env.StandardSetup()
+
+		// zygo to Go
+		x, err := env.EvalString(`(gob (weather type:"stormy" size:222))`)
+		panicOn(err)
+		var w Weather
+		panicOn(gob.NewDecoder(bytes.NewBuffer(x.(SexpRaw).Val)).Decode(&w))
+		cv.So(w.Type, cv.ShouldEqual, "stormy")
+		cv.So(w.Size, cv.ShouldEqual, 222)
+
+		// Go to zygo
+		var buf bytes.Buffer
+		panicOn(gob.NewEncoder(&buf).Encode(&Weather{Type: "calm", Size: 7}))
+		env.AddGlobal("from-go", SexpRaw{Val: buf.Bytes()})
+		x, err = env.EvalString(`(def w2 (ungob from-go)) [(type? w2) (:type w2) (:size w2)]`)
+		panicOn(err)
+		cv.So(x.SexpString(), cv.ShouldEqual, `["weather" "calm" 7]`)
+		x, err = env.EvalString(`(:size (ungob from-go weather))`)
+		panicOn(err)
+		cv.So(x.(*SexpInt).Val, cv.ShouldEqual, 7)
+	})
+}
@@ -0,0 +1,569 @@
+package zygo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+)
+
+// RegisterGoFunc makes the Go function fn callable from zygo as name.
+// Arguments are converted to fn's parameter types with SexpToGoValue,
+// and results back with GoValueToSexp. A context.Context parameter is
+// not taken from the script, but filled in with env.Context(). If
+// fn's last result is an error, a non-nil one stops the script, and
+// a nil one is dropped. The remaining result comes back as it is,
+// or, if there are several, as an array.
+func (env *Glisp) RegisterGoFunc(name string, fn interface{}) error {
+	wrapper, err := WrapGoFunc(fn)
+	if err != nil {
+		return fmt.Errorf("RegisterGoFunc '%s': %v", name, err)
+	}
+	env.AddFunction(name, wrapper)
+	return nil
+}
+
+// WrapGoFunc returns the GlispUserFunction that RegisterGoFunc
+// registers for fn.
+func WrapGoFunc(fn interface{}) (GlispUserFunction, error) {
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func || fv.IsNil() {
+		return nil, fmt.Errorf("expected a Go func, but we had %T", fn)
+	}
+	ft := fv.Type()
+	return func(env *Glisp, name string, args []Sexp) (res Sexp, err error) {
+		in, err := goCallArgs(env, name, ft, args)
+		if err != nil {
+			return SexpNull, err
+		}
+		defer recoverCallback(&err)
+		var out []reflect.Value
+		env.CallGo(func() { out = fv.Call(in) })
+		return goCallResults(env, ft, out)
+	}, nil
+}
+
+// goCallArgs converts the script's args for a call to a Go func of
+// type ft. Variadic arguments are passed one by one, as
+// reflect.Value.Call expects.
+func goCallArgs(env *Glisp, name string, ft reflect.Type, args []Sexp) ([]reflect.Value, error) {
+	nfixed := ft.NumIn()
+	if ft.IsVariadic() {
+		nfixed--
+	}
+	want := 0
+	for i := 0; i < nfixed; i++ {
+		if ft.In(i) != contextType {
+			want++
+		}
+	}
+	if len(args) < want || (len(args) > want && !ft.IsVariadic()) {
+		if ft.IsVariadic() {
+			return nil, fmt.Errorf("%s: expected at least %d arguments, but got %d",
+				name, want, len(args))
+		}
+		return nil, fmt.Errorf("%s: expected %d arguments, but got %d", name, want, len(args))
+	}
+
+	in := make([]reflect.Value, 0, nfixed+len(args)-want)
+	next := 0
+	convert := func(t reflect.Type) error {
+		v, err := SexpToGoValue(env, args[next], t)
+		if err != nil {
+			return fmt.Errorf("%s: argument %d: %v", name, next+1, err)
+		}
+		in = append(in, v)
+		next++
+		return nil
+	}
+	for i := 0; i < nfixed; i++ {
+		t := ft.In(i)
+		if t == contextType {
+			in = append(in, reflect.ValueOf(env.Context()))
+			continue
+		}
+		if err := convert(t); err != nil {
+			return nil, err
+		}
+	}
+	if ft.IsVariadic() {
+		et := ft.In(nfixed).Elem()
+		for next < len(args) {
+			if err := convert(et); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return in, nil
+}
+
+func goCallResults(env *Glisp, ft reflect.Type, out []reflect.Value) (Sexp, error) {
+	if n := len(out); n > 0 && ft.Out(n-1) == errorType {
+		if e := out[n-1]; !e.IsNil() {
+			return SexpNull, e.Interface().(error)
+		}
+		out = out[:n-1]
+	}
+	switch len(out) {
+	case 0:
+		return SexpNull, nil
+	case 1:
+		return GoValueToSexp(env, out[0])
+	}
+	res := make([]Sexp, len(out))
+	for i, o := range out {
+		sx, err := GoValueToSexp(env, o)
+		if err != nil {
+			return SexpNull, err
+		}
+		res[i] = sx
+	}
+	return &SexpArray{Val: res}, nil
+}
+
+// SexpToGoValue converts x to a Go value of type t. Numbers convert
+// to any numeric type that can hold them, strings and symbols to
+// strings, raw bytes and strings to []byte, arrays and lists to
+// slices, hashes to maps with string keys, and functions to Go funcs
+// with MakeGoFunc. Records of a
+// registered type convert to their Go struct, or a pointer to it,
+// with SexpToGoStructs, and an empty interface{} gets SexpToGo(x).
+// A SexpReflect passes its value through, and any x that already
+// satisfies an interface type t, such as a port for an io.Writer,
+// is passed as it is.
+func SexpToGoValue(env *Glisp, x Sexp, t reflect.Type) (reflect.Value, error) {
+	if r, isReflect := x.(SexpReflect); isReflect {
+		v := reflect.Value(r)
+		if v.IsValid() && v.Type().AssignableTo(t) {
+			return v, nil
+		}
+	}
+	if t.Kind() == reflect.Interface && t.NumMethod() > 0 && reflect.TypeOf(x).Implements(t) {
+		v := reflect.New(t).Elem()
+		v.Set(reflect.ValueOf(x))
+		return v, nil
+	}
+	if x == SexpNull {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func, reflect.Chan:
+			return reflect.Zero(t), nil
+		}
+	}
+
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			if g := SexpToGo(x, env); g != nil {
+				v.Set(reflect.ValueOf(g))
+			}
+			return v, nil
+		}
+
+	case reflect.Bool:
+		if b, isBool := x.(SexpBool); isBool {
+			v.SetBool(b.Val)
+			return v, nil
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch i := x.(type) {
+		case *SexpInt:
+			n = i.Val
+		case SexpChar:
+			n = int64(i.Val)
+		default:
+			return v, cannotConvert(x, t)
+		}
+		if v.OverflowInt(n) {
+			return v, fmt.Errorf("%d overflows Go %s", n, t)
+		}
+		v.SetInt(n)
+		return v, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, isInt := x.(*SexpInt)
+		if !isInt {
+			break
+		}
+		if i.Val < 0 || v.OverflowUint(uint64(i.Val)) {
+			return v, fmt.Errorf("%d overflows Go %s", i.Val, t)
+		}
+		v.SetUint(uint64(i.Val))
+		return v, nil
+
+	case reflect.Float32, reflect.Float64:
+		switch f := x.(type) {
+		case SexpFloat:
+			v.SetFloat(f.Val)
+			return v, nil
+		case *SexpInt:
+			v.SetFloat(float64(f.Val))
+			return v, nil
+		}
+
+	case reflect.String:
+		switch s := x.(type) {
+		case SexpStr:
+			v.SetString(s.S)
+			return v, nil
+		case SexpSymbol:
+			v.SetString(s.name)
+			return v, nil
+		}
+
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			switch b := x.(type) {
+			case SexpRaw:
+				v.SetBytes(append([]byte(nil), b.Val...))
+				return v, nil
+			case SexpStr:
+				v.SetBytes([]byte(b.S))
+				return v, nil
+			}
+		}
+		switch x.(type) {
+		case *SexpArray, SexpPair:
+			elems, err := SeqToArray(x)
+			if err != nil {
+				return v, err
+			}
+			v.Set(reflect.MakeSlice(t, len(elems), len(elems)))
+			for i, e := range elems {
+				ev, err := SexpToGoValue(env, e, t.Elem())
+				if err != nil {
+					return v, fmt.Errorf("element %d: %v", i, err)
+				}
+				v.Index(i).Set(ev)
+			}
+			return v, nil
+		}
+
+	case reflect.Map:
+		h, isHash := x.(*SexpHash)
+		if !isHash || t.Key().Kind() != reflect.String {
+			break
+		}
+		v.Set(reflect.MakeMap(t))
+		for _, key := range h.KeyOrder {
+			val, err := h.HashGet(env, key)
+			if err != nil {
+				// deleted keys remain in KeyOrder
+				continue
+			}
+			ev, err := SexpToGoValue(env, val, t.Elem())
+			if err != nil {
+				return v, fmt.Errorf("key '%s': %v", templateKeyName(key), err)
+			}
+			kv := reflect.New(t.Key()).Elem()
+			kv.SetString(templateKeyName(key))
+			v.SetMapIndex(kv, ev)
+		}
+		return v, nil
+
+	case reflect.Struct:
+		if tm, isTime := x.(SexpTime); isTime && t == timeType {
+			v.Set(reflect.ValueOf(time.Time(tm)))
+			return v, nil
+		}
+		if h, isHash := x.(*SexpHash); isHash {
+			p, err := hashToGoStruct(env, h, t)
+			if err != nil {
+				return v, err
+			}
+			return p.Elem(), nil
+		}
+
+	case reflect.Ptr:
+		if h, isHash := x.(*SexpHash); isHash && t.Elem().Kind() == reflect.Struct {
+			return hashToGoStruct(env, h, t.Elem())
+		}
+
+	case reflect.Func:
+		if fun, isFunc := x.(*SexpFunction); isFunc {
+			return MakeGoFunc(env, fun, t), nil
+		}
+	}
+	return v, cannotConvert(x, t)
+}
+
+func cannotConvert(x Sexp, t reflect.Type) error {
+	return fmt.Errorf("cannot use %T / val = '%s' as Go %s", x, x.SexpString(), t)
+}
+
+// hashToGoStruct fills in a new struct of type t from the record h,
+// and returns a pointer to it.
+func hashToGoStruct(env *Glisp, h *SexpHash, t reflect.Type) (p reflect.Value, err error) {
+	p = reflect.New(t)
+	// SexpToGoStructs panics on records it cannot translate.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cannot use record '%s' as Go %s: %v", h.TypeName, t, r)
+		}
+	}()
+	_, err = SexpToGoStructs(h, p.Interface(), env)
+	return p, err
+}
+
+// registeredStruct returns the user-defined type that env has
+// registered for pointers of type t, or nil.
+func registeredStruct(env *Glisp, t reflect.Type) *RegisteredType {
+	for _, rt := range env.Registry().Userdefs() {
+		if rt.hasShadowStruct && rt.TypeCache == t {
+			return rt
+		}
+	}
+	return nil
+}
+
+// GoValueToSexp converts a Go value to zygo, as the reverse of
+// SexpToGoValue. Basic values go through GoToSexp. Structs and
+// pointers to structs of a registered type become records. Values
+// with nothing better to become are wrapped as SexpReflect.
+func GoValueToSexp(env *Glisp, v reflect.Value) (Sexp, error) {
+	if !v.IsValid() {
+		return SexpNull, nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Func, reflect.Chan:
+		if v.IsNil() {
+			return SexpNull, nil
+		}
+	}
+	if v.CanInterface() {
+		switch x := v.Interface().(type) {
+		case Sexp:
+			return x, nil
+		case time.Time:
+			return SexpTime(x), nil
+		case error:
+			return SexpError{x}, nil
+		case string, int, int32, int64, float64, []byte, bool:
+			return GoToSexp(x, env)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		return GoValueToSexp(env, v.Elem())
+	case reflect.Bool:
+		return SexpBool{Val: v.Bool()}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &SexpInt{Val: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &SexpInt{Val: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return SexpFloat{Val: v.Float()}, nil
+	case reflect.String:
+		return SexpStr{S: v.String()}, nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 && v.Kind() == reflect.Slice {
+			return SexpRaw{Val: append([]byte(nil), v.Bytes()...)}, nil
+		}
+		res := make([]Sexp, v.Len())
+		for i := range res {
+			sx, err := GoValueToSexp(env, v.Index(i))
+			if err != nil {
+				return SexpNull, err
+			}
+			res[i] = sx
+		}
+		return &SexpArray{Val: res}, nil
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		pairs := make([]Sexp, 0, 2*len(keys))
+		for _, k := range keys {
+			sx, err := GoValueToSexp(env, v.MapIndex(k))
+			if err != nil {
+				return SexpNull, err
+			}
+			pairs = append(pairs, env.MakeSymbol(k.String()), sx)
+		}
+		return MakeHash(pairs, "hash", env)
+
+	case reflect.Struct:
+		if rt := registeredStruct(env, reflect.PtrTo(v.Type())); rt != nil {
+			p := reflect.New(v.Type())
+			p.Elem().Set(v)
+			return shadowToHash(env, rt, p)
+		}
+
+	case reflect.Ptr:
+		if rt := registeredStruct(env, v.Type()); rt != nil {
+			return shadowToHash(env, rt, v)
+		}
+	}
+	return SexpReflect(v), nil
+}
+
+// shadowToHash makes a record of type rt holding the Go struct
+// that p points to.
+func shadowToHash(env *Glisp, rt *RegisteredType, p reflect.Value) (Sexp, error) {
+	h, err := MakeHash(nil, rt.RegisteredName, env)
+	if err != nil {
+		return SexpNull, err
+	}
+	err = h.FillHashFromShadow(env, p.Interface())
+	if err != nil {
+		return SexpNull, err
+	}
+	h.GoShadowStructVa = p
+	return h, nil
+}
+
+// callbackPanic carries the error of a zygo function, called through
+// MakeGoFunc, whose Go func type has no error result to return it in.
+type callbackPanic struct {
+	err error
+}
+
+// recoverCallback turns a callbackPanic back into the error it
+// carries. Any other panic keeps going.
+func recoverCallback(err *error) {
+	if r := recover(); r != nil {
+		cp, isCallback := r.(callbackPanic)
+		if !isCallback {
+			panic(r)
+		}
+		*err = cp.err
+	}
+}
+
+// MakeGoFunc wraps the zygo function fun as a Go func of type t, for
+// handing to Go code that wants a callback. Arguments are converted
+// with GoValueToSexp, and fun's result with SexpToGoValue; a t with
+// several results, not counting a trailing error, takes them from an
+// array. If fun fails, the error is returned when t's last result is
+// an error, and otherwise panics, to be caught by RegisterGoFunc and
+// _method.
+//
+// Each call runs on its own Duplicate of env, so the Go side may call
+// it from any goroutine. Zygo code for env and its duplicates runs
+// only while holding env's interpreter token, which a script lets go
+// of while it is inside a Go function called with CallGo, as those
+// registered with RegisterGoFunc and _method are, or blocked on a
+// channel. So a callback runs when the script is waiting on Go, or
+// is done, and never beside it or beside another callback; one made
+// from Go code that a callback called runs while that callback
+// waits. Coroutines started with go run beside the script as ever.
+func MakeGoFunc(env *Glisp, fun *SexpFunction, t reflect.Type) reflect.Value {
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		env.interp.acquire()
+		defer env.interp.release()
+		callenv := env.Duplicate()
+		callenv.tok = tokenHeld
+
+		out, err := callGoFunc(callenv, fun, t, in)
+		if err == nil {
+			return out
+		}
+		n := t.NumOut()
+		if n == 0 || t.Out(n-1) != errorType {
+			panic(callbackPanic{err: fmt.Errorf("%s: %v", fun.name, err)})
+		}
+		out = make([]reflect.Value, n)
+		for i := 0; i < n-1; i++ {
+			out[i] = reflect.Zero(t.Out(i))
+		}
+		out[n-1] = reflect.ValueOf(&err).Elem()
+		return out
+	})
+}
+
+// interpToken is held by whoever is running zygo code for an env
+// and its duplicates.
+type interpToken chan struct{}
+
+func (t interpToken) acquire() { t <- struct{}{} }
+func (t interpToken) release() { <-t }
+
+// tokenState says whether an env holds its interpreter token.
+type tokenState int
+
+const (
+	tokenFree   tokenState = iota // Run takes the token
+	tokenHeld                     // this env, or the one it duplicates, has it
+	tokenExempt                   // a coroutine, which runs without it
+)
+
+// CallGo calls f, Go code that may call zygo callbacks made by
+// MakeGoFunc, with env's interpreter token let go until f returns.
+// Go builtins should call out this way when the Go code they call
+// may call back, or may wait for callbacks on other goroutines.
+func (env *Glisp) CallGo(f func()) {
+	if env.tok == tokenHeld {
+		env.tok = tokenFree
+		env.interp.release()
+		defer func() {
+			env.interp.acquire()
+			env.tok = tokenHeld
+		}()
+	}
+	f()
+}
+
+// callGoFunc applies fun to the Go arguments in, and converts the
+// result to the results of t, leaving any trailing error nil.
+func callGoFunc(env *Glisp, fun *SexpFunction, t reflect.Type, in []reflect.Value) ([]reflect.Value, error) {
+	if t.IsVariadic() {
+		last := in[len(in)-1]
+		in = in[:len(in)-1]
+		for i := 0; i < last.Len(); i++ {
+			in = append(in, last.Index(i))
+		}
+	}
+	args := make([]Sexp, len(in))
+	for i, v := range in {
+		sx, err := GoValueToSexp(env, v)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = sx
+	}
+	res, err := env.Apply(fun, args)
+	if err != nil {
+		return nil, err
+	}
+
+	n := t.NumOut()
+	want := n
+	if n > 0 && t.Out(n-1) == errorType {
+		want--
+	}
+	results := []Sexp{res}
+	if want > 1 {
+		arr, isArray := res.(*SexpArray)
+		if !isArray || len(arr.Val) != want {
+			return nil, fmt.Errorf("expected an array of %d results, but we had %T / val = '%s'",
+				want, res, res.SexpString())
+		}
+		results = arr.Val
+	}
+	out := make([]reflect.Value, n)
+	for i := 0; i < want; i++ {
+		v, err := SexpToGoValue(env, results[i], t.Out(i))
+		if err != nil {
+			return nil, fmt.Errorf("result %d: %v", i+1, err)
+		}
+		out[i] = v
+	}
+	if want < n {
+		out[n-1] = reflect.Zero(errorType)
+	}
+	return out, nil
+}
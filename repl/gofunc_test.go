@@ -0,0 +1,231 @@
+package zygo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+type gofuncKey struct{}
+
+func Test037RegisterGoFuncConvertsArgumentsAndResults(t *testing.T) {
+
+	cv.Convey(`Given plain Go functions registered with RegisterGoFunc,`+
+		` scripts should call them with zygo values, get zygo values`+
+		` back, and see a returned error as a script error.`, t, func() {
+
+		env := NewGlisp()
+		defer env.parser.Stop()
+		env.StandardSetup()
+		env.SetContext(context.WithValue(context.Background(), gofuncKey{}, "from-ctx"))
+
+		panicOn(env.RegisterGoFunc("go-repeat", strings.Repeat))
+		panicOn(env.RegisterGoFunc("go-div", func(a, b int) (int, error) {
+			if b == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			return a / b, nil
+		}))
+		panicOn(env.RegisterGoFunc("go-sum", func(scale float64, xs ...float64) float64 {
+			sum := 0.0
+			for _, x := range xs {
+				sum += x
+			}
+			return scale * sum
+		}))
+		panicOn(env.RegisterGoFunc("go-ctx", func(ctx context.Context, suffix string) string {
+			return ctx.Value(gofuncKey{}).(string) + suffix
+		}))
+		panicOn(env.RegisterGoFunc("go-name", func(p *Person) string {
+			return p.First + " " + p.Last
+		}))
+		panicOn(env.RegisterGoFunc("go-person", func(first string) Person {
+			return Person{First: first, Last: "Z"}
+		}))
+		panicOn(env.RegisterGoFunc("go-counts", func(words []string) map[string]int {
+			m := map[string]int{}
+			for _, w := range words {
+				m[w]++
+			}
+			return m
+		}))
+		panicOn(env.RegisterGoFunc("go-split", func(s string) (string, string) {
+			i := strings.Index(s, "=")
+			return s[:i], s[i+1:]
+		}))
+		panicOn(env.RegisterGoFunc("go-byte", func(b uint8) uint8 { return b }))
+
+		cv.So(env.RegisterGoFunc("not-a-func", 3), cv.ShouldNotBeNil)
+
+		x, err := env.EvalString(`(go-repeat "ab" 3)`)
+		panicOn(err)
+		cv.So(x.(SexpStr).S, cv.ShouldEqual, "ababab")
+
+		x, err = env.EvalString(`(go-div 7 2)`)
+		panicOn(err)
+		cv.So(x.(*SexpInt).Val, cv.ShouldEqual, 3)
+		_, err = env.EvalString(`(go-div 7 0)`)
+		env.Clear()
+		cv.So(err.Error(), cv.ShouldEqual, "Error calling 'go-div': division by zero")
+		_, err = env.EvalString(`(go-div 7)`)
+		env.Clear()
+		cv.So(err.Error(), cv.ShouldEqual, "Error calling 'go-div': go-div: expected 2 arguments, but got 1")
+
+		x, err = env.EvalString(`(go-sum 2 1 2.5)`)
+		panicOn(err)
+		cv.So(x.(SexpFloat).Val, cv.ShouldEqual, 7.0)
+		x, err = env.EvalString(`(go-sum 2)`)
+		panicOn(err)
+		cv.So(x.(SexpFloat).Val, cv.ShouldEqual, 0.0)
+
+		x, err = env.EvalString(`(go-ctx "!")`)
+		panicOn(err)
+		cv.So(x.(SexpStr).S, cv.ShouldEqual, "from-ctx!")
+
+		x, err = env.EvalString(`(go-name (person-demo first:"Liz" last:"C"))`)
+		panicOn(err)
+		cv.So(x.(SexpStr).S, cv.ShouldEqual, "Liz C")
+
+		x, err = env.EvalString(`(def p (go-person "Al")) (:last p)`)
+		panicOn(err)
+		cv.So(x.(SexpStr).S, cv.ShouldEqual, "Z")
+
+		x, err = env.EvalString(`(def counts (go-counts ["a" "b" "a"])) [(hget counts (quote a)) (hget counts (quote b))]`)
+		panicOn(err)
+		cv.So(x.SexpString(), cv.ShouldEqual, "[2 1]")
+
+		x, err = env.EvalString(`(go-split "k=v")`)
+		panicOn(err)
+		cv.So(x.SexpString(), cv.ShouldEqual, `["k" "v"]`)
+
+		_, err = env.EvalString(`(go-byte 300)`)
+		env.Clear()
+		cv.So(err.Error(), cv.ShouldEqual, "Error calling 'go-byte': go-byte: argument 1: 300 overflows Go uint8")
+		_, err = env.EvalString(`(go-repeat 1 2)`)
+		env.Clear()
+		cv.So(err.Error(), cv.ShouldEqual, "Error calling 'go-repeat': go-repeat: argument 1: "+
+			"cannot use *zygo.SexpInt / val = '1' as Go string")
+	})
+}
+
+func Test038ZygoFunctionsPassToGoAsTypedFuncs(t *testing.T) {
+
+	cv.Convey(`Given Go functions that take callbacks,`+
+		` zygo functions should be usable as those callbacks,`+
+		` including from other goroutines.`, t, func() {
+
+		env := NewGlisp()
+		defer env.parser.Stop()
+		env.StandardSetup()
+
+		panicOn(env.RegisterGoFunc("go-sort", func(xs []int, less func(a, b int) bool) []int {
+			sort.Slice(xs, func(i, j int) bool { return less(xs[i], xs[j]) })
+			return xs
+		}))
+		panicOn(env.RegisterGoFunc("go-parse-all", func(xs []string, parse func(string) (int, error)) (int, error) {
+			sum := 0
+			for _, x := range xs {
+				n, err := parse(x)
+				if err != nil {
+					return 0, err
+				}
+				sum += n
+			}
+			return sum, nil
+		}))
+		panicOn(env.RegisterGoFunc("go-divmod", func(f func(a, b int) (int, int), a, b int) string {
+			q, r := f(a, b)
+			return fmt.Sprintf("%d r %d", q, r)
+		}))
+		panicOn(env.RegisterGoFunc("go-parallel", func(n int, f func(int) int) int {
+			var wg sync.WaitGroup
+			res := make([]int, n)
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					res[i] = f(i)
+				}(i)
+			}
+			wg.Wait()
+			sum := 0
+			for _, r := range res {
+				sum += r
+			}
+			return sum
+		}))
+		panicOn(env.RegisterGoFunc("go-call", func(f func(int) int, x int) int {
+			return f(x)
+		}))
+
+		x, err := env.EvalString(`(go-sort [3 1 2] (fn [a b] (> a b)))`)
+		panicOn(err)
+		cv.So(x.SexpString(), cv.ShouldEqual, "[3 2 1]")
+
+		x, err = env.EvalString(`(go-parse-all ["a" "bc"] (fn [s] (len s)))`)
+		panicOn(err)
+		cv.So(x.(*SexpInt).Val, cv.ShouldEqual, 3)
+		_, err = env.EvalString(`(defn badparse [s] (+ s 1)) (go-parse-all ["1"] badparse)`)
+		env.Clear()
+		cv.So(err.Error(), cv.ShouldEqual, "Error calling 'go-parse-all': Error calling '+': operands have invalid type")
+
+		x, err = env.EvalString(`(go-divmod (fn [a b] [(/ (- a (mod a b)) b) (mod a b)]) 7 2)`)
+		panicOn(err)
+		cv.So(x.(SexpStr).S, cv.ShouldEqual, "3 r 1")
+
+		_, err = env.EvalString(`(go-sort [2 1] (fn [a b] "yes"))`)
+		env.Clear()
+		cv.So(err.Error(), cv.ShouldStartWith, "Error calling 'go-sort': __anon")
+		cv.So(err.Error(), cv.ShouldEndWith, "result 1: cannot use zygo.SexpStr / val = '\"yes\"' as Go bool")
+
+		x, err = env.EvalString(`(go-parallel 20 (fn [i] (* i i)))`)
+		panicOn(err)
+		cv.So(x.(*SexpInt).Val, cv.ShouldEqual, 2470)
+
+		// a callback calling back into Go, which calls back again
+		x, err = env.EvalString(`(go-call (fn [a] (go-call (fn [b] (+ b 1)) (* a 10))) 4)`)
+		panicOn(err)
+		cv.So(x.(*SexpInt).Val, cv.ShouldEqual, 41)
+
+		// a callback calling a function made earlier, outside any
+		// callback, on the same goroutine
+		var stored func() int
+		panicOn(env.RegisterGoFunc("store", func(f func() int) { stored = f }))
+		panicOn(env.RegisterGoFunc("call-stored", func() int { return stored() }))
+		x, err = env.EvalString(`(store (fn [] 7)) (go-call (fn [a] (+ a (call-stored))) 1)`)
+		panicOn(err)
+		cv.So(x.(*SexpInt).Val, cv.ShouldEqual, 8)
+
+		// a callback from another goroutine waits while the script
+		// runs zygo code, and runs once the script waits on Go
+		start, done := make(chan bool), make(chan bool)
+		panicOn(env.RegisterGoFunc("fire-async", func(f func()) {
+			go func() {
+				<-start
+				f()
+				close(done)
+			}()
+		}))
+		env.AddFunction("release-async", func(env *Glisp, name string, args []Sexp) (Sexp, error) {
+			close(start)
+			return SexpNull, nil
+		})
+		panicOn(env.RegisterGoFunc("wait-async", func() { <-done }))
+		x, err = env.EvalString(`
+(def fired false)
+(fire-async (fn [] (set fired true)))
+(release-async)
+(def n 0)
+(for [(def i 0) (< i 2000) (set i (+ i 1))] (set n (+ n (len (sym2str (gensym))))))
+(def seen fired)
+(wait-async)
+[seen fired]`)
+		panicOn(err)
+		cv.So(x.SexpString(), cv.ShouldEqual, "[false true]")
+	})
+}
@@ -0,0 +1,185 @@
+package zygo
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A record made live with (golive rec) keeps one Go shadow struct
+// for good. Reading a field of the record reads the Go struct field,
+// writing one writes the Go field too, and _method calls methods on
+// that same struct, so what a method changes is what the record
+// shows next. Records are not live by default; togo and _method then
+// build a fresh shadow struct on each use, and (sync-to-go rec) and
+// (sync-from-go rec) copy fields across by hand.
+
+// liveField returns the field of h's Go shadow struct that key
+// names, if h is live and key names one.
+func (h *SexpHash) liveField(key Sexp) (reflect.Value, *HashFieldDet, bool) {
+	if !h.GoLive || !h.GoShadowStructVa.IsValid() {
+		return reflect.Value{}, nil, false
+	}
+	var name string
+	switch k := key.(type) {
+	case SexpSymbol:
+		name = k.name
+	case SexpStr:
+		name = k.S
+	default:
+		return reflect.Value{}, nil, false
+	}
+	det, found := h.JsonTagMap[name]
+	if !found && name != "" {
+		det, found = h.JsonTagMap[strings.ToUpper(name[:1])+name[1:]]
+	}
+	if !found {
+		return reflect.Value{}, nil, false
+	}
+	fld := h.GoShadowStructVa.Elem()
+	for _, p := range det.EmbedPath {
+		fld = fld.Field(p.ChildFieldNum)
+	}
+	return fld, det, true
+}
+
+// liveGet reads the Go field that key names, if h is live.
+func (h *SexpHash) liveGet(env *Glisp, key Sexp) (Sexp, bool, error) {
+	fld, det, isLive := h.liveField(key)
+	if !isLive {
+		return SexpNull, false, nil
+	}
+	if env == nil {
+		env = h.env
+	}
+	val, err := fillHashHelper(fld.Interface(), 0, env, false)
+	if err != nil {
+		return SexpNull, true, fmt.Errorf("error reading Go field '%s': '%s'", det.FieldName, err)
+	}
+	return val, true, nil
+}
+
+// liveSet writes val to the Go field that key names, if h is live.
+func (h *SexpHash) liveSet(key Sexp, val Sexp) (err error) {
+	fld, det, isLive := h.liveField(key)
+	if !isLive {
+		return nil
+	}
+	// SexpToGoStructs panics on values it cannot translate.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cannot set Go field '%s' to '%s': %v", det.FieldName, val.SexpString(), r)
+		}
+	}()
+	_, err = SexpToGoStructs(val, fld.Addr().Interface(), h.env)
+	return err
+}
+
+// recordArg checks that x is a record of a type with a Go struct
+// behind it.
+func recordArg(env *Glisp, name string, x Sexp) (*SexpHash, error) {
+	h, isHash := x.(*SexpHash)
+	if !isHash {
+		return nil, fmt.Errorf("%s: argument must be a record, but we had %T / val = '%s'",
+			name, x, x.SexpString())
+	}
+	rt := env.Registry().Lookup(h.TypeName)
+	if rt == nil || !rt.hasShadowStruct {
+		return nil, fmt.Errorf("%s: record type '%s' has no Go struct", name, h.TypeName)
+	}
+	return h, nil
+}
+
+// (golive rec) makes rec live, building its Go shadow struct from
+// its fields, and returns it; (golive rec false) copies the Go
+// struct's fields into rec and makes it a plain record again.
+// (golive? rec) says if rec is live.
+func GoLiveFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if name == "golive?" {
+		if len(args) != 1 {
+			return SexpNull, WrongNargs
+		}
+		h, isHash := args[0].(*SexpHash)
+		return SexpBool{Val: isHash && h.GoLive}, nil
+	}
+	if len(args) != 1 && len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	h, err := recordArg(env, name, args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	on := true
+	if len(args) == 2 {
+		b, isBool := args[1].(SexpBool)
+		if !isBool {
+			return SexpNull, fmt.Errorf("%s: second argument must be a boolean", name)
+		}
+		on = b.Val
+	}
+	if on == h.GoLive {
+		return h, nil
+	}
+	if on {
+		if err := syncToGo(env, h); err != nil {
+			return SexpNull, err
+		}
+		h.GoShadowStructVa = reflect.ValueOf(h.GoShadowStruct)
+		h.GoLive = true
+		return h, nil
+	}
+	h.GoLive = false
+	if err := h.FillHashFromShadow(env, h.GoShadowStruct); err != nil {
+		return SexpNull, err
+	}
+	return h, nil
+}
+
+// (sync-to-go rec) copies rec's fields into its Go shadow struct,
+// and (sync-from-go rec) copies the Go struct's fields back into rec.
+// Both return rec.
+func SyncGoFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	h, err := recordArg(env, name, args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	switch name {
+	case "sync-to-go":
+		err = syncToGo(env, h)
+	case "sync-from-go":
+		if h.GoShadowStruct == nil {
+			return SexpNull, fmt.Errorf("%s: record has no Go struct yet; see togo", name)
+		}
+		live := h.GoLive
+		h.GoLive = false
+		err = h.FillHashFromShadow(env, h.GoShadowStruct)
+		h.GoLive = live
+	}
+	if err != nil {
+		return SexpNull, err
+	}
+	return h, nil
+}
+
+// syncToGo fills in h's Go shadow struct from h's fields, keeping the
+// struct h already has, so that Go code holding on to it sees the
+// change. A live record's fields are in its struct already.
+func syncToGo(env *Glisp, h *SexpHash) (err error) {
+	if h.GoLive {
+		return nil
+	}
+	if h.GoShadowStruct == nil {
+		_, err = ToGoFunction(env, "togo", []Sexp{h})
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("sync-to-go: %v", r)
+		}
+	}()
+	_, err = SexpToGoStructs(h, h.GoShadowStruct, env)
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	tm "github.com/glycerine/tmframe"
 	"reflect"
+	"sync"
 	"time"
 )
 
@@ -27,6 +28,10 @@ import (
 // for each record defined in the registry. e.g.
 // for snoopy, hornet, hellcat, etc.
 //
+// The types registered here are shared by all envs. Each env looks
+// at them through its own fork, env.Registry(), which is also where
+// the structs its scripts define are registered.
+//
 var GoStructRegistry GoStructRegistryType
 
 // the registry type
@@ -39,11 +44,81 @@ type GoStructRegistryType struct {
 
 	// later, user-defined types
 	Userdef map[string]*RegisteredType
+
+	// parent is the registry this one was forked from. Lookups
+	// that miss here go on to it; registering never does.
+	parent *GoStructRegistryType
+
+	// names lists the types registered here, in order, for typelist.
+	names []string
 }
 
-// consistently ordered list of all registered types (created at init time).
+// ListRegisteredTypes lists, in order, the names of the types in
+// GoStructRegistry.
+//
+// Deprecated: use GoStructRegistry.Names(), or env.Registry().Names()
+// to include the types an env has defined.
 var ListRegisteredTypes = []string{}
 
+// NewGoStructRegistry returns an empty registry.
+func NewGoStructRegistry() *GoStructRegistryType {
+	return &GoStructRegistryType{
+		Registry: make(map[string]*RegisteredType),
+		Builtin:  make(map[string]*RegisteredType),
+		Userdef:  make(map[string]*RegisteredType),
+	}
+}
+
+// Fork returns an empty registry layered over r: it sees all of r's
+// types, but types registered in it, including new definitions of
+// r's names, stay out of r. Each env gets a fork of GoStructRegistry
+// this way, so that the struct definitions of one env are not seen
+// by another.
+func (r *GoStructRegistryType) Fork() *GoStructRegistryType {
+	f := NewGoStructRegistry()
+	f.parent = r
+	return f
+}
+
+// Names lists, in order of registration, the names of all the types
+// r can see.
+func (r *GoStructRegistryType) Names() []string {
+	if r.parent == nil {
+		return append([]string{}, r.names...)
+	}
+	return append(r.parent.Names(), r.names...)
+}
+
+// All, Builtins and Userdefs return maps, by name, of all the types
+// r can see, of only the builtins, and of only the user defined
+// types. A name registered in r hides the same name in its parent.
+func (r *GoStructRegistryType) All() map[string]*RegisteredType {
+	return r.merged(func(g *GoStructRegistryType) map[string]*RegisteredType { return g.Registry })
+}
+
+func (r *GoStructRegistryType) Builtins() map[string]*RegisteredType {
+	return r.merged(func(g *GoStructRegistryType) map[string]*RegisteredType { return g.Builtin })
+}
+
+func (r *GoStructRegistryType) Userdefs() map[string]*RegisteredType {
+	return r.merged(func(g *GoStructRegistryType) map[string]*RegisteredType { return g.Userdef })
+}
+
+func (r *GoStructRegistryType) merged(which func(*GoStructRegistryType) map[string]*RegisteredType) map[string]*RegisteredType {
+	m := make(map[string]*RegisteredType)
+	if r.parent != nil {
+		for name, rt := range r.parent.merged(which) {
+			if _, hidden := r.Registry[name]; !hidden {
+				m[name] = rt
+			}
+		}
+	}
+	for name, rt := range which(r) {
+		m[name] = rt
+	}
+	return m
+}
+
 func (r *GoStructRegistryType) RegisterBuiltin(name string, e *RegisteredType) {
 	r.register(name, e, false)
 	e.IsUser = false
@@ -70,13 +145,15 @@ func (r *GoStructRegistryType) register(name string, e *RegisteredType, isUser b
 	e.Aliases[name] = true
 	e.Aliases[e.ReflectName] = true
 
-	_, found := r.Registry[name]
-	if !found {
-		ListRegisteredTypes = append(ListRegisteredTypes, name)
+	if r.Lookup(name) == nil {
+		r.names = append(r.names, name)
+	}
+	if r.Lookup(e.ReflectName) == nil {
+		r.names = append(r.names, e.ReflectName)
 	}
-	_, found2 := r.Registry[e.ReflectName]
-	if !found2 {
-		ListRegisteredTypes = append(ListRegisteredTypes, e.ReflectName)
+	e.registry = r
+	if r == &GoStructRegistry {
+		ListRegisteredTypes = r.Names()
 	}
 
 	if isUser {
@@ -128,8 +205,16 @@ func (r *GoStructRegistryType) RegisterUserdef(
 	}
 }
 
+// Lookup finds the type called name in r, or else in the registry
+// r was forked from. It returns nil if there is none.
 func (r *GoStructRegistryType) Lookup(name string) *RegisteredType {
-	return r.Registry[name]
+	if rt, found := r.Registry[name]; found {
+		return rt
+	}
+	if r.parent != nil {
+		return r.parent.Lookup(name)
+	}
+	return nil
 }
 
 // the type of all maker functions
@@ -164,6 +249,36 @@ type RegisteredType struct {
 	DisplayAs      string
 	UserStructDefn *RecordDefn
 	IsPointer      bool
+
+	// UserInterfaceDefn is set on types declared with (interface ...).
+	UserInterfaceDefn *InterfaceDefn
+
+	// Elem is the element type of a pointer, slice or array type made
+	// by ptr, slice-of or ([n] type); Kind says which of the three it
+	// is, and ArrayLen is the length of an array type.
+	Elem     *RegisteredType
+	Kind     reflect.Kind
+	ArrayLen int
+
+	// Methods are generated method wrappers; see TypeBinding.
+	Methods map[string]GlispUserFunction
+
+	// registry is where the type was registered.
+	registry *GoStructRegistryType
+
+	// ptrTo and sliceOf cache the pointer and slice types derived
+	// from this one; see GetOrCreatePointerType.
+	ptrTo   *RegisteredType
+	sliceOf *RegisteredType
+}
+
+// Registry returns the registry rt belongs to, or GoStructRegistry
+// if it has not been registered.
+func (rt *RegisteredType) Registry() *GoStructRegistryType {
+	if rt.registry == nil {
+		return &GoStructRegistry
+	}
+	return rt.registry
 }
 
 func (p *RegisteredType) TypeCheckRecord(hash *SexpHash) error {
@@ -194,6 +309,9 @@ func (p *RegisteredType) SexpString() string {
 	if p.UserStructDefn != nil {
 		return p.UserStructDefn.SexpString()
 	}
+	if p.UserInterfaceDefn != nil {
+		return p.UserInterfaceDefn.SexpString()
+	}
 	return p.DisplayAs
 }
 
@@ -215,11 +333,7 @@ func NewRegisteredType(f MakeGoStructFunc) *RegisteredType {
 //    Go integration.
 //
 func init() {
-	GoStructRegistry = GoStructRegistryType{
-		Registry: make(map[string]*RegisteredType),
-		Builtin:  make(map[string]*RegisteredType),
-		Userdef:  make(map[string]*RegisteredType),
-	}
+	GoStructRegistry = *NewGoStructRegistry()
 
 	gsr := &GoStructRegistry
 
@@ -345,7 +459,7 @@ func TypeListFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 	if narg != 0 {
 		return SexpNull, WrongNargs
 	}
-	r := ListRegisteredTypes
+	r := env.Registry().Names()
 	s := make([]Sexp, len(r))
 	for i := range r {
 		s[i] = SexpStr{S: r[i]}
@@ -354,11 +468,11 @@ func TypeListFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 }
 
 func (env *Glisp) ImportBaseTypes() {
-	for _, e := range GoStructRegistry.Builtin {
+	for _, e := range env.Registry().Builtins() {
 		env.AddGlobal(e.RegisteredName, e)
 	}
 
-	for _, e := range GoStructRegistry.Userdef {
+	for _, e := range env.Registry().Userdefs() {
 		env.AddGlobal(e.RegisteredName, e)
 	}
 }
@@ -380,40 +494,105 @@ func compareRegisteredTypes(a *RegisteredType, bs Sexp) (int, error) {
 	return 1, nil
 }
 
+// GetOrCreatePointerType and GetOrCreateSliceType return the pointer
+// or slice type derived from a type, and make it known by name to
+// gsr, which is meant to be the registry of the env asking,
+// env.Registry(). There is only one such type for each type, made on
+// first use and kept on the type it derives from, so derived types
+// compare equal in every env, while registries shared by several
+// envs, like GoStructRegistry, are left alone.
 func (gsr *GoStructRegistryType) GetOrCreatePointerType(pointedToType *RegisteredType) *RegisteredType {
-	Q("pointedToType = %#v", pointedToType)
-	ptrName := "*" + pointedToType.RegisteredName
-	ptrRt := gsr.Lookup(ptrName)
-	if ptrRt != nil {
-		Q("type named '%v' already registered, reusing the pointer type", ptrName)
-	} else {
-		Q("registering new pointer type '%v'", ptrName)
+	ptrRt := pointerTypeOf(pointedToType)
+	gsr.addDerived(ptrRt)
+	return ptrRt
+}
+
+func (gsr *GoStructRegistryType) GetOrCreateSliceType(rt *RegisteredType) *RegisteredType {
+	sliceRt := sliceTypeOf(rt)
+	gsr.addDerived(sliceRt)
+	return sliceRt
+}
+
+// derivedMu guards the ptrTo and sliceOf of all types.
+var derivedMu sync.Mutex
+
+func pointerTypeOf(pointedToType *RegisteredType) *RegisteredType {
+	derivedMu.Lock()
+	defer derivedMu.Unlock()
+	if pointedToType.ptrTo == nil {
+		Q("making new pointer type to '%v'", pointedToType.RegisteredName)
 		derivedType := reflect.PtrTo(pointedToType.TypeCache)
-		ptrRt = NewRegisteredType(func(env *Glisp) (interface{}, error) {
+		ptrRt := NewRegisteredType(func(env *Glisp) (interface{}, error) {
 			return reflect.New(derivedType), nil
 		})
 		ptrRt.DisplayAs = fmt.Sprintf("(* %s)", pointedToType.DisplayAs)
-		ptrRt.RegisteredName = ptrName
-		gsr.RegisterUserdef(ptrName, ptrRt, false)
+		ptrRt.Elem = pointedToType
+		ptrRt.Kind = reflect.Ptr
+		initDerived(ptrRt, "*"+pointedToType.RegisteredName)
+		pointedToType.ptrTo = ptrRt
 	}
-	return ptrRt
+	return pointedToType.ptrTo
 }
 
-func (gsr *GoStructRegistryType) GetOrCreateSliceType(rt *RegisteredType) *RegisteredType {
-	//sliceName := "slice-of-" + rt.RegisteredName
-	sliceName := "[]" + rt.RegisteredName
-	sliceRt := gsr.Lookup(sliceName)
-	if sliceRt != nil {
-		Q("type named '%v' already registered, re-using the type", sliceName)
-	} else {
-		Q("registering new slice type '%v'", sliceName)
+func sliceTypeOf(rt *RegisteredType) *RegisteredType {
+	derivedMu.Lock()
+	defer derivedMu.Unlock()
+	if rt.sliceOf == nil {
+		Q("making new slice type of '%v'", rt.RegisteredName)
+		sliceName := "[]" + rt.RegisteredName
 		derivedType := reflect.SliceOf(rt.TypeCache)
-		sliceRt = NewRegisteredType(func(env *Glisp) (interface{}, error) {
+		sliceRt := NewRegisteredType(func(env *Glisp) (interface{}, error) {
 			return reflect.MakeSlice(derivedType, 0, 0), nil
 		})
 		sliceRt.DisplayAs = fmt.Sprintf("(%s)", sliceName)
-		sliceRt.RegisteredName = sliceName
-		gsr.RegisterUserdef(sliceName, sliceRt, false)
+		sliceRt.Elem = rt
+		sliceRt.Kind = reflect.Slice
+		initDerived(sliceRt, sliceName)
+		rt.sliceOf = sliceRt
+	}
+	return rt.sliceOf
+}
+
+// adoptDerived makes the pointer and slice types derived from
+// placeholder, the stand-in for a struct while its fields are read,
+// those of rt, the struct's final type, so that fields referring to
+// the struct itself get the same types as its values.
+func (rt *RegisteredType) adoptDerived(placeholder *RegisteredType) {
+	derivedMu.Lock()
+	defer derivedMu.Unlock()
+	if d := placeholder.ptrTo; d != nil {
+		d.Elem = rt
+		rt.ptrTo = d
+	}
+	if d := placeholder.sliceOf; d != nil {
+		d.Elem = rt
+		rt.sliceOf = d
+	}
+}
+
+// initDerived names a derived type as RegisterUserdef would, without
+// putting it in any registry.
+func initDerived(rt *RegisteredType, name string) {
+	rt.RegisteredName = name
+	rt.Aliases[name] = true
+	rt.IsUser = true
+	rt.Constructor = MakeUserFunction("__struct_"+name, StructConstructorFunction)
+}
+
+// addDerived lists the derived type rt in r under its name, unless
+// r already sees it there.
+func (r *GoStructRegistryType) addDerived(rt *RegisteredType) {
+	name := rt.RegisteredName
+	prev := r.Lookup(name)
+	if prev == rt {
+		return
+	}
+	if prev == nil {
+		r.names = append(r.names, name)
+	}
+	r.Userdef[name] = rt
+	r.Registry[name] = rt
+	if r == &GoStructRegistry {
+		ListRegisteredTypes = r.Names()
 	}
-	return sliceRt
 }
@@ -0,0 +1,145 @@
+package zygo
+
+import (
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test039StructDefinitionsStayInTheirEnv(t *testing.T) {
+
+	cv.Convey(`Given two envs that define the same struct differently,`+
+		` each should see only its own definition, a Clone should see`+
+		` its parent's types without adding to them, and typelist`+
+		` should show only what the env can see.`, t, func() {
+
+		env1 := NewGlisp()
+		defer env1.parser.Stop()
+		env1.StandardSetup()
+		env2 := NewGlisp()
+		defer env2.parser.Stop()
+		env2.StandardSetup()
+
+		_, err := env1.EvalString(`(struct Car [(field Id: int64)])`)
+		panicOn(err)
+		_, err = env2.EvalString(`(struct Car [(field Name: string)])`)
+		panicOn(err)
+
+		x, err := env1.EvalString(`(:Id (Car Id: 7))`)
+		panicOn(err)
+		cv.So(x.(*SexpInt).Val, cv.ShouldEqual, 7)
+		x, err = env2.EvalString(`(:Name (Car Name: "zoom"))`)
+		panicOn(err)
+		cv.So(x.(SexpStr).S, cv.ShouldEqual, "zoom")
+		_, err = env1.EvalString(`(Car Name: "zoom")`)
+		env1.Clear()
+		cv.So(err, cv.ShouldNotBeNil)
+
+		cv.So(env1.Registry().Lookup("Car"), cv.ShouldNotEqual, env2.Registry().Lookup("Car"))
+		cv.So(GoStructRegistry.Lookup("Car"), cv.ShouldBeNil)
+		cv.So(env1.Registry().Lookup("snoopy"), cv.ShouldEqual, GoStructRegistry.Lookup("snoopy"))
+
+		clone := env1.Clone()
+		cv.So(clone.Registry().Lookup("Car"), cv.ShouldEqual, env1.Registry().Lookup("Car"))
+		clone.Registry().RegisterUserdef("Bike", NewRegisteredType(func(env *Glisp) (interface{}, error) {
+			return &Person{}, nil
+		}), true)
+		cv.So(clone.Registry().Lookup("Bike"), cv.ShouldNotBeNil)
+		cv.So(env1.Registry().Lookup("Bike"), cv.ShouldBeNil)
+
+		cv.So(typelistHas(env1, "Car"), cv.ShouldBeTrue)
+		cv.So(typelistHas(env1, "snoopy"), cv.ShouldBeTrue)
+		env3 := NewGlisp()
+		defer env3.parser.Stop()
+		env3.StandardSetup()
+		cv.So(typelistHas(env3, "snoopy"), cv.ShouldBeTrue)
+		cv.So(typelistHas(env3, "Car"), cv.ShouldBeFalse)
+	})
+}
+
+func typelistHas(env *Glisp, name string) bool {
+	x, err := env.EvalString(`(typelist)`)
+	panicOn(err)
+	for _, s := range x.(*SexpArray).Val {
+		if s.(SexpStr).S == name {
+			return true
+		}
+	}
+	return false
+}
+
+func Test044ListRegisteredTypesStillListsTheSharedTypes(t *testing.T) {
+
+	cv.Convey(`ListRegisteredTypes should still be filled, in order,`+
+		` from GoStructRegistry.`, t, func() {
+
+		cv.So(ListRegisteredTypes, cv.ShouldResemble, GoStructRegistry.Names())
+		cv.So(ListRegisteredTypes, cv.ShouldContain, "snoopy")
+	})
+}
+
+func Test047DerivedTypesStayInTheirEnv(t *testing.T) {
+
+	cv.Convey(`Pointer and slice types that an env derives from shared`+
+		` types should be listed in that env only, and be the same`+
+		` type in every env.`, t, func() {
+
+		env1 := NewGlisp()
+		defer env1.parser.Stop()
+		env1.StandardSetup()
+		env2 := NewGlisp()
+		defer env2.parser.Stop()
+		env2.StandardSetup()
+
+		x, err := env1.EvalString(`(def p (* snoopy)) (def s ([] snoopy)) (type? (& [(snoopy)]))`)
+		panicOn(err)
+		cv.So(x.(SexpStr).S, cv.ShouldEqual, "*[]snoopy")
+
+		cv.So(typelistHas(env1, "*snoopy"), cv.ShouldBeTrue)
+		cv.So(typelistHas(env1, "[]snoopy"), cv.ShouldBeTrue)
+		cv.So(typelistHas(env2, "*snoopy"), cv.ShouldBeFalse)
+		cv.So(typelistHas(env2, "[]snoopy"), cv.ShouldBeFalse)
+		cv.So(GoStructRegistry.Lookup("*snoopy"), cv.ShouldBeNil)
+		cv.So(GoStructRegistry.Lookup("[]snoopy"), cv.ShouldBeNil)
+		cv.So(GoStructRegistry.Lookup("*[]snoopy"), cv.ShouldBeNil)
+
+		p2, err := env2.EvalString(`(* snoopy)`)
+		panicOn(err)
+		cv.So(p2, cv.ShouldEqual, env1.Registry().Lookup("*snoopy"))
+	})
+}
+
+func Test048PackageTypesAreRegisteredPerEnv(t *testing.T) {
+
+	cv.Convey(`Types from RegisterPackage should go into the registry`+
+		` of each env set up afterwards, not into GoStructRegistry.`, t, func() {
+
+		b := &Bindings{
+			Package: "example.com/test048",
+			Types: []TypeBinding{{
+				Name: "test048-person",
+				Factory: func(env *Glisp) (interface{}, error) {
+					return &Person{}, nil
+				},
+			}},
+		}
+		panicOn(RegisterPackage(b))
+		defer delete(GoPackages, b.Package)
+		cv.So(RegisterPackage(&Bindings{Package: "example.com/other", Types: b.Types}), cv.ShouldNotBeNil)
+		cv.So(GoStructRegistry.Lookup("test048-person"), cv.ShouldBeNil)
+
+		env1 := NewGlisp()
+		defer env1.parser.Stop()
+		env1.StandardSetup()
+		env2 := NewGlisp()
+		defer env2.parser.Stop()
+		env2.StandardSetup()
+
+		x, err := env1.EvalString(`(:first (test048-person first: "Ann"))`)
+		panicOn(err)
+		cv.So(x.(SexpStr).S, cv.ShouldEqual, "Ann")
+		cv.So(env1.Registry().Lookup("test048-person"), cv.ShouldNotBeNil)
+		cv.So(env1.Registry().Lookup("test048-person"), cv.ShouldNotEqual, env2.Registry().Lookup("test048-person"))
+		cv.So(GoStructRegistry.Lookup("test048-person"), cv.ShouldBeNil)
+	})
+}
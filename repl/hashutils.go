@@ -1,44 +1,86 @@
 package zygo
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"reflect"
+	"sort"
+	"strings"
+	"time"
 )
 
 var NoAttachedGoStruct = fmt.Errorf("hash has no attach Go struct")
 
+// HashExpression returns the hash code of expr, used to find
+// its bucket in a SexpHash. With a non-nil env, a list key is
+// evaluated and the result hashed instead, as the threading
+// operator expects; HashGetDefault falls back to that only
+// after looking for the list itself.
 func HashExpression(env *Glisp, expr Sexp) (int, error) {
 
 	hashcode, isList, err := hashHelper(expr)
 	if err != nil {
 		return 0, err
 	}
-	if !isList {
+	if !isList || env == nil {
 		return hashcode, nil
 	}
 
 	// can we evaluate it?
-	if env != nil {
-		res, err := EvalFunction(env, "eval-hash-key", []Sexp{expr})
-		if err != nil {
-			return 0, fmt.Errorf("error during eval of "+
-				"hash key: %s", err)
-		}
-		// 2nd try
-		hashcode2, isList2, err := hashHelper(res)
+	res, err := EvalFunction(env, "eval-hash-key", []Sexp{expr})
+	if err != nil {
+		return 0, fmt.Errorf("error during eval of "+
+			"hash key: %s", err)
+	}
+	// 2nd try
+	hashcode2, _, err := hashHelper(res)
+	if err != nil {
+		return 0, fmt.Errorf("evaluated key function to '%s' but could not hash type %T: %s", res.SexpString(), res, err)
+	}
+	return hashcode2, nil
+}
+
+func hashString(s string) int {
+	hasher := fnv.New32()
+	hasher.Write([]byte(s))
+	return int(hasher.Sum32())
+}
+
+// hashSeq folds the hashes of elems, in order.
+func hashSeq(elems []Sexp) (int, error) {
+	hashcode := 17
+	for _, x := range elems {
+		h, _, err := hashHelper(x)
 		if err != nil {
-			return 0, fmt.Errorf("evaluated key function to '%s' but could not hash type %T: %s", res.SexpString(), res, err)
+			return 0, err
 		}
-		if !isList2 {
-			return hashcode2, nil
-		}
-		return 0, fmt.Errorf("list '%s' found where hash key needed", res.SexpString())
+		hashcode = hashcode*31 + h
 	}
-	return 0, fmt.Errorf("cannot hash type %T", expr)
+	return hashcode, nil
 }
 
+// hashEntry combines a key and value hash; entries are summed
+// so that the hash of a map does not depend on its order.
+func hashEntry(key, val Sexp) (int, error) {
+	hk, _, err := hashHelper(key)
+	if err != nil {
+		return 0, err
+	}
+	hv, _, err := hashHelper(val)
+	if err != nil {
+		return 0, err
+	}
+	return hk*31 ^ hv, nil
+}
+
+// hashHelper computes a structural hash that agrees with Compare:
+// whenever Compare(a, b) == 0, a and b hash the same. Values that
+// differ may still collide, so buckets must use Compare to tell
+// keys apart. isList reports a list key, which HashExpression
+// may want to evaluate.
 func hashHelper(expr Sexp) (hashcode int, isList bool, err error) {
 	switch e := expr.(type) {
 	case *SexpInt:
@@ -47,15 +89,96 @@ func hashHelper(expr Sexp) (hashcode int, isList bool, err error) {
 		return int(e.Val), false, nil
 	case SexpSymbol:
 		return e.number, false, nil
+	case SexpFloat:
+		// whole floats hash like the ints they compare equal to
+		if e.Val == math.Trunc(e.Val) && math.Abs(e.Val) < 1<<62 {
+			return int(e.Val), false, nil
+		}
+		return int(math.Float64bits(e.Val)), false, nil
+	case SexpBool:
+		if e.Val {
+			return 1, false, nil
+		}
+		return 0, false, nil
 	case SexpStr:
+		return hashString(e.S), false, nil
+	case SexpRaw:
 		hasher := fnv.New32()
-		_, err := hasher.Write([]byte(e.S))
-		if err != nil {
-			return 0, false, err
-		}
+		hasher.Write(e.Val)
 		return int(hasher.Sum32()), false, nil
+	case SexpSentinel:
+		return int(e), false, nil
+	case SexpTime:
+		return int(time.Time(e).UnixNano()), false, nil
 	case SexpPair:
-		return 0, true, nil
+		elems := []Sexp{}
+		var rest Sexp = e
+		for {
+			p, isPair := rest.(SexpPair)
+			if !isPair {
+				break
+			}
+			elems = append(elems, p.Head)
+			rest = p.Tail
+		}
+		// an improper list's last cdr counts too
+		elems = append(elems, rest)
+		hashcode, err = hashSeq(elems)
+		return hashcode, true, err
+	case *SexpArray:
+		hashcode, err = hashSeq(e.Val)
+		return hashcode, false, err
+	case *SexpPVec:
+		hashcode, err = hashSeq(e.Elems())
+		return hashcode, false, err
+	case *SexpHash:
+		// records of different types never compare equal,
+		// so fold in the type name.
+		hashcode = hashString(e.TypeName)
+		for _, bucket := range e.Map {
+			for _, pair := range bucket {
+				h, err := hashEntry(pair.Head, pair.Tail)
+				if err != nil {
+					return 0, false, err
+				}
+				hashcode += h
+			}
+		}
+		return hashcode, false, nil
+	case *SexpPMap:
+		for _, p := range e.Pairs() {
+			h, err := hashEntry(p.Head, p.Tail)
+			if err != nil {
+				return 0, false, err
+			}
+			hashcode += h
+		}
+		return hashcode, false, nil
+	case *SexpSet:
+		for _, bucket := range e.Map {
+			for _, x := range bucket {
+				h, _, err := hashHelper(x)
+				if err != nil {
+					return 0, false, err
+				}
+				hashcode += h
+			}
+		}
+		return hashcode, false, nil
+	case *SexpPointer:
+		// pointers are equal when their targets are the same
+		// object (or, for plain values, the same value).
+		rv := reflect.ValueOf(e.Target)
+		if rv.Kind() == reflect.Ptr {
+			return int(rv.Pointer()), false, nil
+		}
+		hashcode, _, err = hashHelper(e.Target)
+		return hashcode, false, err
+	case *SexpFunction, *SexpRegexp, *RegisteredType, *SexpLazySeq, *SexpIterator, *SexpTemplate, *SexpPort, *SexpProcess, *SexpModule:
+		// these are only ever equal to themselves
+		return int(reflect.ValueOf(e).Pointer()), false, nil
+	case SexpChannel:
+		return int(reflect.ValueOf(e.Val).Pointer()), false, nil
 	}
 	return 0, false, fmt.Errorf("cannot hash type %T", expr)
 }
@@ -77,7 +200,7 @@ func MakeHash(args []Sexp, typename string, env *Glisp) (*SexpHash, error) {
 	var iface interface{}
 	jsonMap := make(map[string]*HashFieldDet)
 
-	factory := GoStructRegistry.Lookup(typename)
+	factory := env.Registry().Lookup(typename)
 	if factory == nil {
 		factory = &RegisteredType{Factory: MakeGoStructFunc(func(env *Glisp) (interface{}, error) { return MakeHash(nil, typename, env) })}
 		factory.Aliases = make(map[string]bool)
@@ -124,9 +247,8 @@ func MakeHash(args []Sexp, typename string, env *Glisp) (*SexpHash, error) {
 		k++
 	}
 
-	Q("doing factory, foundRecordType := GoStructRegistry.Registry[typename]")
-	factoryShad, foundRecordType := GoStructRegistry.Registry[typename]
-	if foundRecordType {
+	factoryShad := env.Registry().Lookup(typename)
+	if factoryShad != nil {
 		Q("factoryShad = %#v\n", factoryShad)
 		if factoryShad.hasShadowStruct {
 			Q("\n in MakeHash: found struct associated with '%s'\n", typename)
@@ -149,7 +271,7 @@ func MakeHash(args []Sexp, typename string, env *Glisp) (*SexpHash, error) {
 		factory.ReflectName = typename
 		factory.DisplayAs = typename
 
-		GoStructRegistry.RegisterUserdef(typename, factory, false)
+		env.Registry().RegisterUserdef(typename, factory, false)
 	}
 
 	return &hash, nil
@@ -173,23 +295,49 @@ func (hash *SexpHash) HashGet(env *Glisp, key Sexp) (Sexp, error) {
 }
 
 func (hash *SexpHash) HashGetDefault(env *Glisp, key Sexp, defaultval Sexp) (Sexp, error) {
-	hashval, err := HashExpression(env, key)
+	if val, isLive, err := hash.liveGet(env, key); isLive {
+		return val, err
+	}
+	hashval, isList, err := hashHelper(key)
 	if err != nil {
 		return SexpNull, err
 	}
-	arr, ok := hash.Map[hashval]
-
-	if !ok {
+	arr := hash.Map[hashval]
+	if i := bucketFind(arr, key); i >= 0 {
+		return arr[i].Tail, nil
+	}
+	if !isList || env == nil {
 		return defaultval, nil
 	}
 
-	for _, pair := range arr {
+	// not there as a list; try evaluating the list to get the key.
+	res, err := EvalFunction(env, "eval-hash-key", []Sexp{key})
+	if err != nil {
+		return SexpNull, fmt.Errorf("error during eval of "+
+			"hash key: %s", err)
+	}
+	hashval, _, err = hashHelper(res)
+	if err != nil {
+		return SexpNull, err
+	}
+	arr = hash.Map[hashval]
+	if i := bucketFind(arr, res); i >= 0 {
+		return arr[i].Tail, nil
+	}
+	return defaultval, nil
+}
+
+// bucketFind returns the index of key within a bucket, or -1.
+// Keys of different types can share a bucket; Compare fails
+// for those, and they are simply not a match.
+func bucketFind(arr []SexpPair, key Sexp) int {
+	for i, pair := range arr {
 		res, err := Compare(pair.Head, key)
 		if err == nil && res == 0 {
-			return pair.Tail, nil
+			return i
 		}
 	}
-	return defaultval, nil
+	return -1
 }
 
 var KeyNotSymbol = fmt.Errorf("key is not a symbol")
@@ -218,7 +366,7 @@ func (h *SexpHash) TypeCheckField(key Sexp, val Sexp) error {
 		Q("SexpHash.TypeCheckField() sees nil has.GoStructFactory.UserStructDefn, bailing out.")
 
 		// check in the registry for this type!
-		rt := GoStructRegistry.Lookup(h.TypeName)
+		rt := h.env.Registry().Lookup(h.TypeName)
 
 		// was it found? If so, use it!
 		if rt != nil && rt.UserStructDefn != nil {
@@ -249,6 +397,16 @@ func (h *SexpHash) TypeCheckField(key Sexp, val Sexp) error {
 		if !ok {
 			return fmt.Errorf("%s has no field '%s'", p.UserStructDefn.Name, k)
 		}
+		if declaredTyp.IsInterface() {
+			if val == SexpNull {
+				return nil
+			}
+			if err := Satisfies(h.env, val, declaredTyp); err != nil {
+				return fmt.Errorf("field %v.%v is %v, cannot assign '%v': %v",
+					p.UserStructDefn.Name, k, declaredTyp.ShortName(), val.SexpString(), err)
+			}
+			return nil
+		}
 		obsTyp := val.Type()
 		if obsTyp == nil {
 			// allow certain types to be nil, e.g. [] and nil itself
@@ -287,37 +445,24 @@ func (hash *SexpHash) HashSet(key Sexp, val Sexp) error {
 			return err
 		}
 	}
+	err = hash.liveSet(key, val)
+	if err != nil {
+		return err
+	}
 
 	hashval, err := HashExpression(nil, key)
 	if err != nil {
 		return err
 	}
-	arr, ok := hash.Map[hashval]
-
-	if !ok {
-		hash.Map[hashval] = []SexpPair{Cons(key, val)}
-		hash.KeyOrder = append(hash.KeyOrder, key)
-		hash.NumKeys++
+	arr := hash.Map[hashval]
+	if i := bucketFind(arr, key); i >= 0 {
+		arr[i] = Cons(key, val)
 		return nil
 	}
 
-	found := false
-	for i, pair := range arr {
-		res, err := Compare(pair.Head, key)
-		if err == nil && res == 0 {
-			arr[i] = Cons(key, val)
-			found = true
-		}
-	}
-
-	if !found {
-		arr = append(arr, Cons(key, val))
-		hash.KeyOrder = append(hash.KeyOrder, key)
-		hash.NumKeys++
-	}
-
-	hash.Map[hashval] = arr
-
+	hash.Map[hashval] = append(arr, Cons(key, val))
+	hash.KeyOrder = append(hash.KeyOrder, key)
+	hash.NumKeys++
 	return nil
 }
 
@@ -326,22 +471,30 @@ func (hash *SexpHash) HashDelete(key Sexp) error {
 	if err != nil {
 		return err
 	}
-	arr, ok := hash.Map[hashval]
+	arr := hash.Map[hashval]
+	i := bucketFind(arr, key)
 
 	// if it doesn't exist, no need to delete it
-	if !ok {
+	if i < 0 {
 		return nil
 	}
 
+	if len(arr) == 1 {
+		delete(hash.Map, hashval)
+	} else {
+		rest := make([]SexpPair, 0, len(arr)-1)
+		rest = append(rest, arr[:i]...)
+		hash.Map[hashval] = append(rest, arr[i+1:]...)
+	}
 	hash.NumKeys--
-	for i, pair := range arr {
-		res, err := Compare(pair.Head, key)
+
+	for k, ko := range hash.KeyOrder {
+		res, err := Compare(ko, key)
 		if err == nil && res == 0 {
-			hash.Map[hashval] = append(arr[0:i], arr[i+1:]...)
+			hash.KeyOrder = append(hash.KeyOrder[:k:k], hash.KeyOrder[k+1:]...)
 			break
 		}
 	}
-
 	return nil
 }
 
@@ -415,6 +568,9 @@ func GoMethodListFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 	if !isHash {
 		return SexpNull, fmt.Errorf("hash/record required, but saw type %T/val=%#v", args[0], args[0])
 	}
+	if rt := env.Registry().Lookup(h.TypeName); rt != nil && rt.UserStructDefn != nil {
+		return &SexpArray{Val: userMethodList(rt.UserStructDefn)}, nil
+	}
 	if h.NumMethod != -1 {
 		// use cached results
 		return &h.GoMethSx, nil
@@ -567,6 +723,14 @@ func GenericHpairFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 			return SexpNull, fmt.Errorf("hpair position request %d out of bounds", pos)
 		}
 		return Cons(&SexpInt{Val: int64(pos)}, Cons(seq.Val[pos], SexpNull)), nil
+	case *SexpPVec:
+		val, found := seq.Nth(pos)
+		if !found {
+			return SexpNull, fmt.Errorf("hpair position request %d out of bounds", pos)
+		}
+		return Cons(&SexpInt{Val: int64(pos)}, Cons(val, SexpNull)), nil
+	case *SexpPMap:
+		return pmapAccessFunction(seq, name, args)
 	default:
 		return SexpNull, errors.New("first argument of to hpair function must be hash, list, or array")
 	}
@@ -580,7 +744,14 @@ func (h *SexpHash) FillHashFromShadow(env *Glisp, src interface{}) error {
 
 	for i, det := range h.DetOrder {
 		Q("\n looking at det for %s; %v-th entry in h.DetOrder\n", det.FieldJsonTag, i)
-		goField := vaSrc.Field(det.FieldNum)
+		// fields of embedded structs are found along their EmbedPath
+		goField := vaSrc
+		for _, p := range det.EmbedPath {
+			goField = goField.Field(p.ChildFieldNum)
+		}
+		if len(det.EmbedPath) == 0 {
+			goField = vaSrc.Field(det.FieldNum)
+		}
 		val, err := fillHashHelper(goField.Interface(), 0, env, false)
 		if err != nil {
 			Q("got err='%s' back from fillHashhelper", err)
@@ -601,11 +772,15 @@ func (h *SexpHash) FillHashFromShadow(env *Glisp, src interface{}) error {
 // for all Go structs
 func fillHashHelper(r interface{}, depth int, env *Glisp, preferSym bool) (Sexp, error) {
 	Q("fillHashHelper() at depth %d, decoded type is %T\n", depth, r)
+	if r == nil {
+		// e.g. a nil interface field
+		return SexpNull, nil
+	}
 
 	// check for one of our registered structs
 
 	// go through the type registry upfront
-	for hashName, factory := range GoStructRegistry.Registry {
+	for hashName, factory := range env.Registry().All() {
 		Q("fillHashHelper is trying hashName='%s'", hashName)
 		st, err := factory.Factory(env)
 		if err != nil {
@@ -820,7 +995,7 @@ func NamedHashSexpString(hash *SexpHash) string {
 }
 
 func (r *SexpHash) Type() *RegisteredType {
-	return GoStructRegistry.Registry[r.TypeName]
+	return r.env.Registry().Lookup(r.TypeName)
 }
 
 func compareHash(a *SexpHash, bs Sexp) (int, error) {
@@ -834,8 +1009,55 @@ func compareHash(a *SexpHash, bs Sexp) (int, error) {
 	}
 
 	if a.TypeName != b.TypeName {
-		return 1, nil
+		return bytes.Compare([]byte(a.TypeName), []byte(b.TypeName)), nil
+	}
+	if a.NumKeys != b.NumKeys {
+		return signumInt(int64(a.NumKeys - b.NumKeys)), nil
 	}
 
+	// same type and size: compare the entries in order of their
+	// keys, as sequences are compared.
+	ea, eb := sortedEntries(a), sortedEntries(b)
+	for i := range ea {
+		if res, _ := orderSexp(ea[i].Head, eb[i].Head); res != 0 {
+			return res, nil
+		}
+		res, err := orderSexp(ea[i].Tail, eb[i].Tail)
+		if err != nil || res != 0 {
+			return res, err
+		}
+	}
 	return 0, nil
 }
+
+// sortedEntries returns the entries of h sorted by key.
+func sortedEntries(h *SexpHash) []SexpPair {
+	entries := make([]SexpPair, 0, h.NumKeys)
+	for _, bucket := range h.Map {
+		entries = append(entries, bucket...)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		res, _ := orderSexp(entries[i].Head, entries[j].Head)
+		return res < 0
+	})
+	return entries
+}
+
+// orderSexp orders a and b by Compare where it can, and otherwise by
+// their Go types and then their printed forms, so that hashes with
+// keys or values of mixed types still have an order. It gives an
+// error only for values that Compare cannot order and that look the
+// same, such as two different functions with the same body.
+func orderSexp(a, b Sexp) (int, error) {
+	res, err := Compare(a, b)
+	if err == nil {
+		return res, nil
+	}
+	if ta, tb := fmt.Sprintf("%T", a), fmt.Sprintf("%T", b); ta != tb {
+		return strings.Compare(ta, tb), nil
+	}
+	if sa, sb := a.SexpString(), b.SexpString(); sa != sb {
+		return strings.Compare(sa, sb), nil
+	}
+	return 0, err
+}
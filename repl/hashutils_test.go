@@ -0,0 +1,92 @@
+package zygo
+
+import (
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test033StructuralHashAgreesWithCompare(t *testing.T) {
+
+	cv.Convey(`Given values of many kinds, any two that Compare `+
+		`reports as equal should have the same hash.`, t, func() {
+
+		env := NewGlisp()
+		defer env.parser.Stop()
+		env.StandardSetup()
+
+		x, err := env.EvalString(`
+(defmap pt)
+[1 1.0 #a 97 2.5 "a" a: true nil
+ [1 2] [1.0 2] '(1 2) '(1.0 2)
+ {a:1 b:2} {b:2.0 a:1} {a:1 b:3} (pt x:1) (pt x:1.0) (pt x:2)
+ #{1 2} #{2.0 1} (pvec 1 2) (pmap a: [1]) (pmap a: [1.0])
+ (raw "ab") (raw "ab") (raw "abc")
+ [[1 {a:#{2}}]] [[1.0 {a:#{2.0}}]]]
+`)
+		panicOn(err)
+		vals := x.(*SexpArray).Val
+		for _, a := range vals {
+			ha, _, err := hashHelper(a)
+			cv.So(err, cv.ShouldBeNil)
+			for _, b := range vals {
+				res, err := Compare(a, b)
+				if err == nil && res == 0 {
+					hb, _, _ := hashHelper(b)
+					cv.So(ha, cv.ShouldEqual, hb)
+				}
+			}
+		}
+	})
+}
+
+func Test034HashBucketsKeepCollidingKeysApart(t *testing.T) {
+
+	cv.Convey(`Given keys whose hashes collide, a SexpHash should `+
+		`store, find and delete each of them independently.`, t, func() {
+
+		env := NewGlisp()
+		defer env.parser.Stop()
+
+		h, err := MakeHash(nil, "hash", env)
+		panicOn(err)
+
+		// 1 and true both hash to 1, but Compare cannot equate them.
+		one := &SexpInt{Val: 1}
+		yes := SexpBool{Val: true}
+		panicOn(h.HashSet(one, SexpStr{S: "one"}))
+		panicOn(h.HashSet(yes, SexpStr{S: "yes"}))
+		cv.So(len(h.Map), cv.ShouldEqual, 1)
+		cv.So(h.NumKeys, cv.ShouldEqual, 2)
+
+		v, err := h.HashGet(nil, one)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(v.(SexpStr).S, cv.ShouldEqual, "one")
+		v, err = h.HashGet(nil, yes)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(v.(SexpStr).S, cv.ShouldEqual, "yes")
+
+		// overwriting does not add a key
+		panicOn(h.HashSet(SexpFloat{Val: 1.0}, SexpStr{S: "uno"}))
+		cv.So(h.NumKeys, cv.ShouldEqual, 2)
+		v, _ = h.HashGet(nil, one)
+		cv.So(v.(SexpStr).S, cv.ShouldEqual, "uno")
+
+		// deleting a missing key changes nothing
+		panicOn(h.HashDelete(&SexpInt{Val: 2}))
+		panicOn(h.HashDelete(SexpBool{Val: false}))
+		cv.So(HashCountKeys(h), cv.ShouldEqual, 2)
+
+		panicOn(h.HashDelete(one))
+		cv.So(HashCountKeys(h), cv.ShouldEqual, 1)
+		cv.So(len(h.KeyOrder), cv.ShouldEqual, 1)
+		_, err = h.HashGet(nil, one)
+		cv.So(err, cv.ShouldNotBeNil)
+		v, _ = h.HashGet(nil, yes)
+		cv.So(v.(SexpStr).S, cv.ShouldEqual, "yes")
+
+		panicOn(h.HashDelete(yes))
+		cv.So(HashCountKeys(h), cv.ShouldEqual, 0)
+		cv.So(len(h.Map), cv.ShouldEqual, 0)
+	})
+}
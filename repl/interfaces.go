@@ -0,0 +1,290 @@
+package zygo
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// An interface declared with
+//
+//   (interface Flyer [
+//       (func Fly [ev: (* weather)] [s:string err:error])
+//    ])
+//
+// records the signatures of the methods it asks for. A record
+// satisfies it if each method is there, either defined in zygo on
+// its struct (see methods.go), on the record's Go shadow struct, as
+// a bound method of its type, or as a function held in the record
+// field of that name.
+
+// MethodSig is one method of an interface. Param and result names
+// are optional.
+type MethodSig struct {
+	Name        string
+	ParamNames  []string
+	Params      []*RegisteredType
+	ResultNames []string
+	Results     []*RegisteredType
+}
+
+type InterfaceDefn struct {
+	Name    string
+	Methods []*MethodSig
+}
+
+func (d *InterfaceDefn) SexpString() string {
+	if len(d.Methods) == 0 {
+		return fmt.Sprintf("(interface %s)", d.Name)
+	}
+	s := fmt.Sprintf("(interface %s [\n", d.Name)
+	for _, m := range d.Methods {
+		s += fmt.Sprintf("  (func %s %s %s)\n", m.Name,
+			sigString(m.ParamNames, m.Params), sigString(m.ResultNames, m.Results))
+	}
+	return s + " ])"
+}
+
+func sigString(names []string, types []*RegisteredType) string {
+	s := "["
+	for i, t := range types {
+		if i > 0 {
+			s += " "
+		}
+		if names[i] != "" {
+			s += names[i] + ":"
+		}
+		s += t.SexpString()
+	}
+	return s + "]"
+}
+
+// parseMethodSig reads a (func name [params] [results]) entry of an
+// interface declaration, evaluating the types in env.
+func parseMethodSig(env *Glisp, x Sexp) (*MethodSig, error) {
+	elems, err := ListToArray(x)
+	if err != nil || len(elems) < 2 || len(elems) > 4 {
+		return nil, fmt.Errorf("method must be (func name [params] [results]), not '%s'", x.SexpString())
+	}
+	if head, isSym := elems[0].(SexpSymbol); !isSym || head.name != "func" {
+		return nil, fmt.Errorf("method must be (func name [params] [results]), not '%s'", x.SexpString())
+	}
+	nm, isSym := elems[1].(SexpSymbol)
+	if !isSym {
+		return nil, fmt.Errorf("method name must be a symbol, not '%s'", elems[1].SexpString())
+	}
+	sig := &MethodSig{Name: nm.name}
+	if len(elems) > 2 {
+		sig.ParamNames, sig.Params, err = parseSigTypes(env, elems[2])
+		if err != nil {
+			return nil, fmt.Errorf("method %s params: %v", nm.name, err)
+		}
+	}
+	if len(elems) > 3 {
+		sig.ResultNames, sig.Results, err = parseSigTypes(env, elems[3])
+		if err != nil {
+			return nil, fmt.Errorf("method %s results: %v", nm.name, err)
+		}
+	}
+	return sig, nil
+}
+
+// sigErrorType is Go's error. It is only a type inside method
+// signatures, so that error stays free for scripts to use as a name.
+var sigErrorType = newSigErrorType()
+
+func newSigErrorType() *RegisteredType {
+	rt := NewRegisteredType(func(env *Glisp) (interface{}, error) {
+		return new(error), nil
+	})
+	rt.RegisteredName = "error"
+	return rt
+}
+
+// parseSigTypes reads [a:int b:string] or [int string].
+func parseSigTypes(env *Glisp, x Sexp) ([]string, []*RegisteredType, error) {
+	arr, isArr := x.(*SexpArray)
+	if !isArr {
+		return nil, nil, fmt.Errorf("need an array, not '%s'", x.SexpString())
+	}
+	var names []string
+	var types []*RegisteredType
+	name := ""
+	for _, ele := range arr.Val {
+		if pair, isPair := ele.(SexpPair); isPair {
+			if sym, isQuo := isQuotedSymbol(pair); isQuo {
+				name = sym.(SexpSymbol).name
+				continue
+			}
+		}
+		if sym, isSym := ele.(SexpSymbol); isSym && sym.name == "error" {
+			names = append(names, name)
+			types = append(types, sigErrorType)
+			name = ""
+			continue
+		}
+		ev, err := env.Duplicate().EvalExpressions([]Sexp{ele})
+		if err != nil {
+			return nil, nil, err
+		}
+		rt, isRt := ev.(*RegisteredType)
+		if !isRt {
+			return nil, nil, fmt.Errorf("'%s' is not a type", ele.SexpString())
+		}
+		names = append(names, name)
+		types = append(types, rt)
+		name = ""
+	}
+	if name != "" {
+		return nil, nil, fmt.Errorf("'%s' has no type", name)
+	}
+	return names, types, nil
+}
+
+// IsInterface says if rt was declared with (interface ...) or is a
+// Go interface type.
+func (rt *RegisteredType) IsInterface() bool {
+	if rt.UserInterfaceDefn != nil {
+		return true
+	}
+	_, isGo := rt.goInterface()
+	return isGo
+}
+
+func (rt *RegisteredType) goInterface() (reflect.Type, bool) {
+	t := rt.TypeCache
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Interface {
+		return nil, false
+	}
+	return t.Elem(), true
+}
+
+// goTypeFor gives the Go type rt stands for, if it has one.
+func goTypeFor(rt *RegisteredType) (reflect.Type, bool) {
+	if rt.UserStructDefn != nil || rt.UserInterfaceDefn != nil {
+		return nil, false
+	}
+	if rt.Elem != nil {
+		elem, ok := goTypeFor(rt.Elem)
+		if !ok {
+			return nil, false
+		}
+		switch rt.Kind {
+		case reflect.Ptr:
+			return reflect.PtrTo(elem), true
+		case reflect.Slice:
+			return reflect.SliceOf(elem), true
+		case reflect.Array:
+			return reflect.ArrayOf(rt.ArrayLen, elem), true
+		}
+		return nil, false
+	}
+	t := rt.TypeCache
+	if t == nil || t.Kind() != reflect.Ptr {
+		return nil, false
+	}
+	return t.Elem(), true
+}
+
+// Satisfies returns nil if val has the methods iface asks for, and
+// otherwise an error saying what is missing.
+func Satisfies(env *Glisp, val Sexp, iface *RegisteredType) error {
+	if ptr, isPtr := val.(*SexpPointer); isPtr {
+		val = ptr.Target
+	}
+	h, isHash := val.(*SexpHash)
+	if !isHash {
+		return fmt.Errorf("%s is not a record", val.SexpString())
+	}
+	rt := env.Registry().Lookup(h.TypeName)
+
+	if it, isGo := iface.goInterface(); isGo {
+		if rt == nil || !rt.hasShadowStruct || !rt.TypeCache.Implements(it) {
+			return fmt.Errorf("%s does not implement %s", h.TypeName, iface.SexpString())
+		}
+		return nil
+	}
+	if iface.UserInterfaceDefn == nil {
+		return fmt.Errorf("%s is not an interface", iface.SexpString())
+	}
+	for _, sig := range iface.UserInterfaceDefn.Methods {
+		if err := hasMethod(env, h, rt, sig); err != nil {
+			return fmt.Errorf("%s does not implement %s: %v",
+				h.TypeName, iface.UserInterfaceDefn.Name, err)
+		}
+	}
+	return nil
+}
+
+func hasMethod(env *Glisp, h *SexpHash, rt *RegisteredType, sig *MethodSig) error {
+	if fn, err := h.HashGet(env, env.MakeSymbol(sig.Name)); err == nil {
+		if f, isFn := fn.(*SexpFunction); isFn {
+			if !f.user && (len(sig.Params) < f.nargs || (!f.varargs && len(sig.Params) > f.nargs)) {
+				return fmt.Errorf("method %s takes %d arguments, not %d", sig.Name, f.nargs, len(sig.Params))
+			}
+			return nil
+		}
+	}
+	if rt == nil {
+		return fmt.Errorf("missing method %s", sig.Name)
+	}
+	if rt.UserStructDefn != nil {
+		m, _, err := rt.UserStructDefn.FindMethod(sig.Name)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			return fmt.Errorf("missing method %s", sig.Name)
+		}
+		n := len(m.ParamNames)
+		if len(sig.Params) < n-1 || (!m.Varargs && len(sig.Params) != n) {
+			return fmt.Errorf("method %s takes %d arguments, not %d", sig.Name, n, len(sig.Params))
+		}
+		for i, p := range m.Params {
+			if p != nil && i < len(sig.Params) && p != sig.Params[i] {
+				return fmt.Errorf("method %s param %s is %s, not %s", sig.Name,
+					m.ParamNames[i], p.ShortName(), sig.Params[i].ShortName())
+			}
+		}
+		return nil
+	}
+	if _, isBound := rt.Methods[sig.Name]; isBound {
+		return nil
+	}
+	if !rt.hasShadowStruct {
+		return fmt.Errorf("missing method %s", sig.Name)
+	}
+	m, found := rt.TypeCache.MethodByName(sig.Name)
+	if !found {
+		return fmt.Errorf("missing method %s", sig.Name)
+	}
+	// m.Type has the receiver first.
+	mt := m.Type
+	if mt.NumIn()-1 != len(sig.Params) || mt.NumOut() != len(sig.Results) {
+		return fmt.Errorf("method %s has signature %v", sig.Name, mt)
+	}
+	for i, p := range sig.Params {
+		if t, ok := goTypeFor(p); ok && t != mt.In(i+1) {
+			return fmt.Errorf("method %s has signature %v", sig.Name, mt)
+		}
+	}
+	for i, r := range sig.Results {
+		if t, ok := goTypeFor(r); ok && t != mt.Out(i) {
+			return fmt.Errorf("method %s has signature %v", sig.Name, mt)
+		}
+	}
+	return nil
+}
+
+// (satisfies? value Flyer) says if value has the methods of the
+// interface Flyer.
+func SatisfiesFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	iface, isRt := args[1].(*RegisteredType)
+	if !isRt || !iface.IsInterface() {
+		return SexpNull, fmt.Errorf("%s: second argument must be an interface, but we had '%s'",
+			name, args[1].SexpString())
+	}
+	return SexpBool{Val: Satisfies(env, args[0], iface) == nil}, nil
+}
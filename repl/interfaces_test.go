@@ -0,0 +1,43 @@
+package zygo
+
+import (
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test045GoInterfaceTypesCheckWithImplements(t *testing.T) {
+
+	cv.Convey(`Given the Go interface Flyer registered as flyer in an env,`+
+		` satisfies? and interface-typed fields should use reflect's Implements.`, t, func() {
+
+		env := NewGlisp()
+		defer env.parser.Stop()
+		env.StandardSetup()
+
+		flyer := NewRegisteredType(func(env *Glisp) (interface{}, error) {
+			return new(Flyer), nil
+		})
+		env.Registry().RegisterUserdef("flyer", flyer, false)
+		env.AddGlobal("flyer", flyer)
+
+		x, err := env.EvalString(`(list (satisfies? (snoopy) flyer) (satisfies? (weather) flyer))`)
+		panicOn(err)
+		cv.So(x.SexpString(), cv.ShouldEqual, "(true false)")
+
+		x, err = env.EvalString(`(struct Hangar [(field Spare: flyer)]) (:Spare (Hangar Spare: (hellcat)))`)
+		panicOn(err)
+		cv.So(x.(*SexpHash).TypeName, cv.ShouldEqual, "hellcat")
+
+		_, err = env.EvalString(`(Hangar Spare: (weather))`)
+		env.Clear()
+		cv.So(err, cv.ShouldNotBeNil)
+
+		// a new env has no flyer
+		env2 := NewGlisp()
+		defer env2.parser.Stop()
+		x, err = env2.EvalString(`(def flyer "x") flyer`)
+		panicOn(err)
+		cv.So(x.(SexpStr).S, cv.ShouldEqual, "x")
+	})
+}
@@ -2,6 +2,7 @@ package zygo
 
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
 	"github.com/shurcooL/go-goon"
 	"github.com/ugorji/go/codec"
@@ -89,13 +90,64 @@ func SexpToJson(exp Sexp) string {
 		return e.jsonHashHelper()
 	case *SexpArray:
 		return e.jsonArrayHelper()
+	case *SexpSet:
+		// JSON has no sets; members go out as an array
+		return (&SexpArray{Val: e.Elems()}).jsonArrayHelper()
+	case *SexpPVec:
+		return (&SexpArray{Val: e.Elems()}).jsonArrayHelper()
+	case *SexpPMap:
+		return e.jsonObjectHelper()
 	case SexpSymbol:
 		return `"` + e.name + `"`
+	case SexpStr:
+		return jsonString(e.S)
+	case SexpChar:
+		return jsonString(string(e.Val))
+	case SexpSentinel:
+		if e == SexpNull {
+			return "null"
+		}
+		return exp.SexpString()
+	case SexpRaw:
+		return jsonString(base64.StdEncoding.EncodeToString(e.Val))
+	case SexpTime:
+		return jsonString(time.Time(e).Format(time.RFC3339Nano))
 	default:
 		return exp.SexpString()
 	}
 }
 
+// jsonString quotes s for JSON, which does not take all the escapes
+// of a Go string literal.
+func jsonString(s string) string {
+	return strings.TrimSuffix(string(GoToJson(s)), "\n")
+}
+
+// jsonObjectHelper writes m as a plain JSON object, with each key
+// as a string: symbols and strings as they are, anything else as
+// its SexpString.
+func (m *SexpPMap) jsonObjectHelper() string {
+	pairs := m.Pairs()
+	if len(pairs) == 0 {
+		return "{}"
+	}
+	strs := make([]string, len(pairs))
+	for i, p := range pairs {
+		strs[i] = jsonString(pmapKeyString(p.Head)) + ":" + SexpToJson(p.Tail)
+	}
+	return "{" + strings.Join(strs, ", ") + "}"
+}
+
+func pmapKeyString(key Sexp) string {
+	switch k := key.(type) {
+	case SexpSymbol:
+		return k.name
+	case SexpStr:
+		return k.S
+	}
+	return key.SexpString()
+}
+
 func (hash *SexpHash) jsonHashHelper() string {
 	str := fmt.Sprintf(`{"Atype":"%s", `, hash.TypeName)
 
@@ -162,6 +214,8 @@ func (m *msgpackHelper) init() {
 	m.mh.WriteExt = true
 	m.mh.SignedInteger = true
 	m.mh.Canonical = true // sort maps before writing them
+	err := m.mh.SetBytesExt(reflect.TypeOf(msgpSet{}), MsgpackSetExtTag, msgpSetExt{})
+	panicOn(err)
 
 	// JSON
 	m.jh.MapType = reflect.TypeOf(map[string]interface{}(nil))
@@ -181,14 +235,154 @@ func init() {
 // returns both the msgpack []bytes and the go intermediary
 func SexpToMsgpack(exp Sexp) ([]byte, interface{}) {
 
-	json := []byte(SexpToJson(exp))
-	iface, err := JsonToGo(json)
-	panicOn(err)
+	var iface interface{}
+	var err error
+	if hasSet(exp) {
+		iface = sexpToMsgpackGo(exp)
+	} else {
+		json := []byte(SexpToJson(exp))
+		iface, err = JsonToGo(json)
+		panicOn(err)
+	}
 	by, err := GoToMsgpack(iface)
 	panicOn(err)
 	return by, iface
 }
 
+// MsgpackSetExtTag is the msgpack extension type used for sets.
+// The members are stored as a msgpack array inside the extension.
+const MsgpackSetExtTag = 2
+
+//msgp:ignore msgpSet msgpSetExt
+
+// msgpSet is the Go stand-in for a SexpSet on its way to and from
+// msgpack.
+type msgpSet struct {
+	Elems []interface{}
+}
+
+type msgpSetExt struct{}
+
+func (x msgpSetExt) WriteExt(v interface{}) []byte {
+	var elems []interface{}
+	// depending on how it was reached, v may be behind
+	// pointers or an interface.
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	if s, isSet := rv.Interface().(msgpSet); isSet {
+		elems = s.Elems
+	}
+	by, err := GoToMsgpack(elems)
+	panicOn(err)
+	return by
+}
+
+func (x msgpSetExt) ReadExt(dst interface{}, src []byte) {
+	iface, err := MsgpackToGo(src)
+	panicOn(err)
+	elems, _ := iface.([]interface{})
+	dst.(*msgpSet).Elems = elems
+}
+
+func hasSet(exp Sexp) bool {
+	switch e := exp.(type) {
+	case *SexpSet:
+		return true
+	case *SexpArray:
+		for _, x := range e.Val {
+			if hasSet(x) {
+				return true
+			}
+		}
+	case *SexpHash:
+		for _, bucket := range e.Map {
+			for _, pair := range bucket {
+				if hasSet(pair.Tail) {
+					return true
+				}
+			}
+		}
+	case *SexpPVec:
+		return hasSet(&SexpArray{Val: e.Elems()})
+	case *SexpPMap:
+		for _, p := range e.Pairs() {
+			if hasSet(p.Tail) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sexpToMsgpackGo gives the same Go values as the trip through
+// JSON in SexpToMsgpack, except that sets become msgpSet so
+// they are written with their extension tag. It panics on anything
+// that is not data, and so takes exactly what SexpToJson writes as
+// valid JSON.
+func sexpToMsgpackGo(exp Sexp) interface{} {
+	switch e := exp.(type) {
+	case *SexpSet:
+		elems := e.Elems()
+		s := msgpSet{Elems: make([]interface{}, len(elems))}
+		for i, x := range elems {
+			s.Elems[i] = sexpToMsgpackGo(x)
+		}
+		return s
+	case *SexpArray:
+		ar := make([]interface{}, len(e.Val))
+		for i, x := range e.Val {
+			ar[i] = sexpToMsgpackGo(x)
+		}
+		return ar
+	case *SexpHash:
+		m := map[string]interface{}{"Atype": e.TypeName}
+		if len(e.KeyOrder) == 0 {
+			return m
+		}
+		ko := []interface{}{}
+		for _, key := range e.KeyOrder {
+			keyst := key.SexpString()
+			ko = append(ko, keyst)
+			val, err := e.HashGet(nil, key)
+			panicOn(err)
+			m[keyst] = sexpToMsgpackGo(val)
+		}
+		m["zKeyOrder"] = ko
+		return m
+	case *SexpPVec:
+		return sexpToMsgpackGo(&SexpArray{Val: e.Elems()})
+	case *SexpPMap:
+		m := make(map[string]interface{}, e.Len())
+		for _, p := range e.Pairs() {
+			m[pmapKeyString(p.Head)] = sexpToMsgpackGo(p.Tail)
+		}
+		return m
+	case SexpRaw:
+		return []byte(e.Val)
+	case SexpTime:
+		return time.Time(e).Format(time.RFC3339Nano)
+	case *SexpInt:
+		return e.Val
+	case SexpFloat:
+		return e.Val
+	case SexpBool:
+		return e.Val
+	case SexpStr:
+		return e.S
+	case SexpChar:
+		return string(e.Val)
+	case SexpSymbol:
+		return e.name
+	case SexpSentinel:
+		if e == SexpNull {
+			return nil
+		}
+	}
+	panic(fmt.Errorf("cannot translate %T to msgpack", exp))
+}
+
 // json -> go
 func JsonToGo(json []byte) (interface{}, error) {
 	var iface interface{}
@@ -331,6 +525,15 @@ func decodeGoToSexpHelper(r interface{}, depth int, env *Glisp, preferSym bool)
 		panicOn(err)
 		return hash
 
+	case msgpSet:
+		elems := make([]Sexp, len(val.Elems))
+		for i := range val.Elems {
+			elems[i] = decodeGoToSexpHelper(val.Elems[i], depth+1, env, preferSym)
+		}
+		set, err := MakeSet(elems)
+		panicOn(err)
+		return set
+
 	case []byte:
 		VPrintf("depth %d found []byte case: val = %#v\n", depth, val)
 
@@ -447,9 +650,13 @@ func ToGoFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 	default:
 		return SexpNull, fmt.Errorf("value must be a hash or defmap")
 	case *SexpHash:
+		if asHash.GoLive {
+			// keep the struct that Go code may already hold
+			return SexpStr{S: fmt.Sprintf("%#v", asHash.GoShadowStruct)}, nil
+		}
 		tn := asHash.TypeName
-		factory, hasMaker := GoStructRegistry.Registry[tn]
-		if !hasMaker {
+		factory := env.Registry().Lookup(tn)
+		if factory == nil {
 			return SexpNull, fmt.Errorf("type '%s' not registered in GoStructRegistry", tn)
 		}
 		newStruct, err := factory.Factory(env)
@@ -560,8 +767,8 @@ func SexpToGoStructs(sexp Sexp, target interface{}, env *Glisp) (interface{}, er
 		}
 
 		// use targVa, but check against the type in the registry for sanity/type checking.
-		factory, hasMaker := GoStructRegistry.Registry[tn]
-		if !hasMaker {
+		factory := env.Registry().Lookup(tn)
+		if factory == nil {
 			panic(fmt.Errorf("type '%s' not registered in GoStructRegistry", tn))
 			//return nil, fmt.Errorf("type '%s' not registered in GoStructRegistry", tn)
 		}
@@ -664,7 +871,8 @@ func SexpToGoStructs(sexp Sexp, target interface{}, env *Glisp) (interface{}, er
 		// no conversion done
 		//return src
 	case SexpSentinel:
-		targVa.Elem().Set(reflect.ValueOf(nil))
+		// nil zeroes the target, e.g. a nil interface field
+		targVa.Elem().Set(reflect.Zero(targVa.Elem().Type()))
 	case SexpTime:
 		targVa.Elem().Set(reflect.ValueOf(time.Time(src)))
 	default:
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"testing"
+	"time"
 
 	cv "github.com/glycerine/goconvey/convey"
 	"github.com/ugorji/go/codec"
@@ -148,3 +149,31 @@ type Event struct {
 		}
 	})
 }
+
+func Test049PersistentRawAndTimeValuesConvertToJson(t *testing.T) {
+
+	cv.Convey(`pvecs, pmaps, raw bytes and times should be written as`+
+		` JSON arrays, objects, base64 strings and RFC 3339 strings,`+
+		` and strings should be quoted as JSON strings.`, t, func() {
+
+		env := NewGlisp()
+		defer env.parser.Stop()
+		env.StandardSetup()
+
+		x, err := env.EvalString(`[(pvec 1 (pmap a: 2)) (raw "hi")]`)
+		panicOn(err)
+		when := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		x.(*SexpArray).Val = append(x.(*SexpArray).Val,
+			SexpStr{S: "tab\there\x01"}, SexpNull, SexpTime(when))
+
+		js := SexpToJson(x)
+		cv.So(js, cv.ShouldEqual, `[[1, {"a":2}], "aGk=", "tab\there\u0001", null, "2026-01-02T03:04:05Z"]`)
+		var back interface{}
+		cv.So(json.Unmarshal([]byte(js), &back), cv.ShouldBeNil)
+
+		iface := sexpToMsgpackGo(x).([]interface{})
+		cv.So(iface[0], cv.ShouldResemble, []interface{}{int64(1), map[string]interface{}{"a": int64(2)}})
+		cv.So(iface[1], cv.ShouldResemble, []byte("hi"))
+		cv.So(iface[4], cv.ShouldEqual, "2026-01-02T03:04:05Z")
+	})
+}
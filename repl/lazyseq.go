@@ -0,0 +1,519 @@
+package zygo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+)
+
+// lazyseq.go: the iterator protocol, and lazy sequences built
+// on top of it. Anything MakeIterator understands can be walked
+// one element at a time with doseq, iter, or the lazy lmap,
+// lfilter and ltake, without first building the whole sequence
+// in memory.
+
+// Iterator walks a sequence. Next returns the next element, or
+// false once the sequence is exhausted. Close releases anything
+// the iterator holds (such as a generator's goroutine) and may
+// be called more than once.
+type Iterator interface {
+	Next(env *Glisp) (Sexp, bool, error)
+	Close()
+}
+
+// SexpLazySeq is a sequence whose elements are computed only as
+// they are asked for. Each call to Open starts a fresh pass, so
+// a lazy sequence can be walked more than once.
+type SexpLazySeq struct {
+	Name string
+	Open func(env *Glisp) (Iterator, error)
+}
+
+func (s *SexpLazySeq) SexpString() string {
+	return "[lazy-seq " + s.Name + "]"
+}
+
+func (s *SexpLazySeq) Type() *RegisteredType {
+	return nil
+}
+
+// SexpIterator is the user visible handle returned by (iter seq).
+type SexpIterator struct {
+	it   Iterator
+	cur  Sexp
+	done bool
+}
+
+func (s *SexpIterator) SexpString() string {
+	return "[iterator]"
+}
+
+func (s *SexpIterator) Type() *RegisteredType {
+	return nil
+}
+
+var ErrGeneratorClosed = errors.New("generator closed")
+
+// MakeIterator returns an Iterator over a list, array, hash,
+// channel, lazy sequence, decoder, or a Go slice or array held in
+// a SexpReflect. Hash elements are (key value) pairs, in key order.
+// Channels are read until they are closed, decoders until their
+// stream ends.
+func MakeIterator(env *Glisp, seq Sexp) (Iterator, error) {
+	switch s := seq.(type) {
+	case SexpSentinel:
+		if s == SexpNull {
+			return &listIterator{next: SexpNull}, nil
+		}
+	case SexpPair:
+		return &listIterator{next: s}, nil
+	case *SexpArray:
+		return &arrayIterator{arr: s}, nil
+	case *SexpHash:
+		return &hashIterator{hash: s}, nil
+	case *SexpPVec:
+		return &arrayIterator{arr: &SexpArray{Val: s.Elems()}}, nil
+	case *SexpPMap:
+		return &arrayIterator{arr: &SexpArray{Val: pmapPairList(s)}}, nil
+	case *SexpSet:
+		return &arrayIterator{arr: &SexpArray{Val: s.Elems()}}, nil
+	case SexpChannel:
+		return &chanIterator{ch: s.Val}, nil
+	case *SexpLazySeq:
+		return s.Open(env)
+	case *SexpIterator:
+		return s.it, nil
+	case *SexpDecoder:
+		return s, nil
+	case SexpReflect:
+		rv := reflect.Value(s)
+		for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+			rv = rv.Elem()
+		}
+		switch rv.Kind() {
+		case reflect.Slice, reflect.Array:
+			return &reflectIterator{val: rv}, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot iterate over %T / val = '%s'", seq, seq.SexpString())
+}
+
+type listIterator struct {
+	next Sexp
+}
+
+func (it *listIterator) Next(env *Glisp) (Sexp, bool, error) {
+	switch p := it.next.(type) {
+	case SexpPair:
+		it.next = p.Tail
+		return p.Head, true, nil
+	case SexpSentinel:
+		if p == SexpNull {
+			return SexpNull, false, nil
+		}
+	}
+	return SexpNull, false, NotAList
+}
+
+func (it *listIterator) Close() {}
+
+type arrayIterator struct {
+	arr *SexpArray
+	i   int
+}
+
+func (it *arrayIterator) Next(env *Glisp) (Sexp, bool, error) {
+	if it.i >= len(it.arr.Val) {
+		return SexpNull, false, nil
+	}
+	it.i++
+	return it.arr.Val[it.i-1], true, nil
+}
+
+func (it *arrayIterator) Close() {}
+
+type hashIterator struct {
+	hash *SexpHash
+	i    int
+}
+
+func (it *hashIterator) Next(env *Glisp) (Sexp, bool, error) {
+	for it.i < len(it.hash.KeyOrder) {
+		key := it.hash.KeyOrder[it.i]
+		it.i++
+		val, err := it.hash.HashGet(nil, key)
+		if err != nil {
+			// deleted keys remain in KeyOrder; skip them.
+			continue
+		}
+		return Cons(key, Cons(val, SexpNull)), true, nil
+	}
+	return SexpNull, false, nil
+}
+
+func (it *hashIterator) Close() {}
+
+type chanIterator struct {
+	ch chan Sexp
+}
+
+func (it *chanIterator) Next(env *Glisp) (Sexp, bool, error) {
+	v, ok := <-it.ch
+	if !ok {
+		return SexpNull, false, nil
+	}
+	return v, true, nil
+}
+
+func (it *chanIterator) Close() {}
+
+type reflectIterator struct {
+	val reflect.Value
+	i   int
+}
+
+func (it *reflectIterator) Next(env *Glisp) (Sexp, bool, error) {
+	if it.i >= it.val.Len() {
+		return SexpNull, false, nil
+	}
+	it.i++
+	return reflectElemToSexp(it.val.Index(it.i - 1)), true, nil
+}
+
+func (it *reflectIterator) Close() {}
+
+// reflectElemToSexp converts the Go basic kinds to their zygo
+// counterparts, and wraps anything else in a SexpReflect.
+func reflectElemToSexp(v reflect.Value) Sexp {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &SexpInt{Val: v.Int()}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &SexpInt{Val: int64(v.Uint())}
+	case reflect.Float32, reflect.Float64:
+		return SexpFloat{Val: v.Float()}
+	case reflect.String:
+		return SexpStr{S: v.String()}
+	case reflect.Bool:
+		return SexpBool{Val: v.Bool()}
+	}
+	return SexpReflect(v)
+}
+
+// funcIterator adapts a next function to the Iterator interface.
+type funcIterator struct {
+	next  func(env *Glisp) (Sexp, bool, error)
+	close func()
+}
+
+func (it *funcIterator) Next(env *Glisp) (Sexp, bool, error) {
+	return it.next(env)
+}
+
+func (it *funcIterator) Close() {
+	if it.close != nil {
+		it.close()
+	}
+}
+
+// genIterator runs a producer function on its own goroutine and
+// environment. The producer and the consumer strictly take turns:
+// the producer only runs while the consumer is blocked in Next,
+// so they never touch the shared symbol table at the same time.
+//
+// The producer goroutine only holds the genYield half, so a
+// genIterator the consumer drops without closing can still be
+// garbage collected; its finalizer then stops the producer.
+type genIterator struct {
+	fun     *SexpFunction
+	penv    *Glisp
+	started bool
+	y       *genYield
+	done    chan error
+}
+
+// genYield is the producer's side of a generator.
+type genYield struct {
+	stopped bool
+	vals    chan Sexp
+	resume  chan bool
+}
+
+func (y *genYield) yield(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	if y.stopped {
+		return SexpNull, ErrGeneratorClosed
+	}
+	y.vals <- args[0]
+	if !<-y.resume {
+		y.stopped = true
+		return SexpNull, ErrGeneratorClosed
+	}
+	return SexpNull, nil
+}
+
+func (g *genIterator) Next(env *Glisp) (Sexp, bool, error) {
+	if g.done == nil {
+		return SexpNull, false, nil
+	}
+	if g.started {
+		g.y.resume <- true
+	} else {
+		g.started = true
+		penv, fun, yield, done := g.penv, g.fun, g.y.yield, g.done
+		go func() {
+			_, err := penv.Apply(fun, []Sexp{MakeUserFunction("yield", yield)})
+			done <- err
+		}()
+		runtime.SetFinalizer(g, func(g *genIterator) { go g.Close() })
+	}
+	select {
+	case v := <-g.y.vals:
+		return v, true, nil
+	case err := <-g.done:
+		g.done = nil
+		return SexpNull, false, err
+	}
+}
+
+func (g *genIterator) Close() {
+	if g.done == nil {
+		return
+	}
+	if g.started {
+		g.y.resume <- false
+		<-g.done
+	}
+	g.done = nil
+}
+
+func lazyIterArg(env *Glisp, name string, seq Sexp) (Iterator, error) {
+	it, err := MakeIterator(env, seq)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return it, nil
+}
+
+// (lmap f seq) applies f to each element as it is asked for.
+func LazyMapFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	fun, err := seqFunctionArg(name, "first argument", args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	seq := args[1]
+	return &SexpLazySeq{Name: name, Open: func(env *Glisp) (Iterator, error) {
+		src, err := lazyIterArg(env, name, seq)
+		if err != nil {
+			return nil, err
+		}
+		return &funcIterator{
+			next: func(env *Glisp) (Sexp, bool, error) {
+				x, ok, err := src.Next(env)
+				if !ok || err != nil {
+					return SexpNull, false, err
+				}
+				y, err := env.Apply(fun, []Sexp{x})
+				if err != nil {
+					return SexpNull, false, err
+				}
+				return y, true, nil
+			},
+			close: src.Close,
+		}, nil
+	}}, nil
+}
+
+// (lfilter f seq) yields only the elements for which f is true.
+func LazyFilterFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	fun, err := seqFunctionArg(name, "first argument", args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	seq := args[1]
+	return &SexpLazySeq{Name: name, Open: func(env *Glisp) (Iterator, error) {
+		src, err := lazyIterArg(env, name, seq)
+		if err != nil {
+			return nil, err
+		}
+		return &funcIterator{
+			next: func(env *Glisp) (Sexp, bool, error) {
+				for {
+					x, ok, err := src.Next(env)
+					if !ok || err != nil {
+						return SexpNull, false, err
+					}
+					keep, err := env.Apply(fun, []Sexp{x})
+					if err != nil {
+						return SexpNull, false, err
+					}
+					if IsTruthy(keep) {
+						return x, true, nil
+					}
+				}
+			},
+			close: src.Close,
+		}, nil
+	}}, nil
+}
+
+// (ltake n seq) yields at most the first n elements of seq, which
+// may be infinite. The source is closed as soon as n are taken.
+func LazyTakeFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	n, err := seqIntArg(name, args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	seq := args[1]
+	return &SexpLazySeq{Name: name, Open: func(env *Glisp) (Iterator, error) {
+		src, err := lazyIterArg(env, name, seq)
+		if err != nil {
+			return nil, err
+		}
+		taken := 0
+		return &funcIterator{
+			next: func(env *Glisp) (Sexp, bool, error) {
+				if taken >= n {
+					src.Close()
+					return SexpNull, false, nil
+				}
+				taken++
+				return src.Next(env)
+			},
+			close: src.Close,
+		}, nil
+	}}, nil
+}
+
+// (generator (fn [yield] ...)) makes a lazy sequence of the values
+// the producer passes to yield. The producer is started afresh for
+// each pass over the sequence, and is stopped (yield returns an
+// error) if the consumer closes the iterator early.
+func GeneratorFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	fun, err := seqFunctionArg(name, "first argument", args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	return &SexpLazySeq{Name: name, Open: func(env *Glisp) (Iterator, error) {
+		return &genIterator{
+			fun:  fun,
+			penv: env.Duplicate(),
+			y:    &genYield{vals: make(chan Sexp), resume: make(chan bool)},
+			done: make(chan error, 1),
+		}, nil
+	}}, nil
+}
+
+// (iter seq) returns an iterator; (iter-next! it) advances it and
+// returns false when exhausted, (iter-val it) is the current
+// element, and (iter-close it) releases it early.
+//
+// doseq opens its iterator with __doseq-iter, which also records
+// it in env so that it is closed even if the loop body fails.
+func IterFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if name == "iter" || name == "__doseq-iter" {
+		if len(args) != 1 {
+			return SexpNull, WrongNargs
+		}
+		it, err := lazyIterArg(env, "iter", args[0])
+		if err != nil {
+			return SexpNull, err
+		}
+		si := &SexpIterator{it: it, cur: SexpNull}
+		if name == "__doseq-iter" {
+			env.doseqIters = append(env.doseqIters, si)
+		}
+		return si, nil
+	}
+
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	si, isIter := args[0].(*SexpIterator)
+	if !isIter {
+		return SexpNull, fmt.Errorf("%s: argument must be an iterator, "+
+			"but we had %T / val = '%s'", name, args[0], args[0].SexpString())
+	}
+
+	switch name {
+	case "iter-next!":
+		if si.done {
+			return SexpBool{Val: false}, nil
+		}
+		x, ok, err := si.it.Next(env)
+		if err != nil || !ok {
+			si.done = true
+			si.cur = SexpNull
+			si.it.Close()
+			return SexpBool{Val: false}, err
+		}
+		si.cur = x
+		return SexpBool{Val: true}, nil
+	case "iter-val":
+		return si.cur, nil
+	case "iter-close":
+		si.done = true
+		si.it.Close()
+		for i := len(env.doseqIters) - 1; i >= 0; i-- {
+			if env.doseqIters[i] == si {
+				env.doseqIters = append(env.doseqIters[:i], env.doseqIters[i+1:]...)
+				break
+			}
+		}
+		return SexpNull, nil
+	}
+	return SexpNull, fmt.Errorf("unknown iterator function '%s'", name)
+}
+
+// closeDoseqIters closes the doseq iterators opened since there
+// were mark of them, as when an error cuts their loops short.
+func (env *Glisp) closeDoseqIters(mark int) {
+	for i := len(env.doseqIters) - 1; i >= mark; i-- {
+		si := env.doseqIters[i]
+		si.done = true
+		si.it.Close()
+	}
+	if mark < len(env.doseqIters) {
+		env.doseqIters = env.doseqIters[:mark]
+	}
+}
+
+// (realize seq) walks any iterable to its end and returns the
+// elements as an array.
+func RealizeFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	it, err := lazyIterArg(env, name, args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	defer it.Close()
+	res := make([]Sexp, 0)
+	for {
+		x, ok, err := it.Next(env)
+		if err != nil {
+			return SexpNull, err
+		}
+		if !ok {
+			break
+		}
+		res = append(res, x)
+	}
+	return &SexpArray{Val: res}, nil
+}
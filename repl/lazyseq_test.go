@@ -0,0 +1,46 @@
+package zygo
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+// goroutinesSettleTo waits a little for the goroutine count to
+// come back down to n.
+func goroutinesSettleTo(n int) bool {
+	for i := 0; i < 100; i++ {
+		runtime.GC()
+		if runtime.NumGoroutine() <= n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func Test042GeneratorsStopWhenTheirConsumerFailsOrDropsThem(t *testing.T) {
+
+	cv.Convey(`Given a generator, its producer goroutine should exit`+
+		` when a doseq over it fails, and when it is dropped unclosed.`, t, func() {
+
+		env := NewGlisp()
+		defer env.parser.Stop()
+		env.StandardSetup()
+		_, err := env.EvalString(`(defn counter [] (generator (fn [yield] (for [(def i 0) true (set i (+ i 1))] (yield i)))))`)
+		panicOn(err)
+		base := runtime.NumGoroutine()
+
+		_, err = env.EvalString(`(doseq [x (counter)] (cond (== x 3) (assert false) nil))`)
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(goroutinesSettleTo(base), cv.ShouldBeTrue)
+		env.Clear()
+
+		_, err = env.EvalString(`(def it (iter (counter))) (iter-next! it) (iter-next! it) (def it nil)`)
+		panicOn(err)
+		env.Clear()
+		cv.So(goroutinesSettleTo(base), cv.ShouldBeTrue)
+	})
+}
@@ -41,6 +41,8 @@ const (
 	TokenDotSymbol
 	TokenFreshAssign
 	TokenBacktickString
+	TokenLSetCurly
+	TokenRegexp
 	TokenEnd
 )
 
@@ -65,6 +67,10 @@ func (t Token) String() string {
 		return "{"
 	case TokenRCurly:
 		return "}"
+	case TokenLSetCurly:
+		return "#{"
+	case TokenRegexp:
+		return "#\"" + t.str + "\""
 	case TokenDot:
 		return t.str
 	case TokenQuote:
@@ -108,6 +114,8 @@ const (
 	LexerUnquote                   //
 	LexerBacktickString            //
 	LexerFreshAssignOrColon
+	LexerRegexpLit     //
+	LexerRegexpEscaped //
 )
 
 type Lexer struct {
@@ -119,6 +127,10 @@ type Lexer struct {
 	stream  io.RuneScanner
 	next    []io.RuneScanner
 	linenum int
+
+	// runes seen since the start of input, so that a #! line
+	// at the very top can be skipped.
+	nrunes int
 }
 
 func NewLexer(p *Parser) *Lexer {
@@ -140,6 +152,7 @@ func (lex *Lexer) Reset() {
 	lex.tokens = lex.tokens[:0]
 	lex.state = LexerNormal
 	lex.linenum = 1
+	lex.nrunes = 0
 	lex.buffer.Reset()
 }
 
@@ -289,6 +302,12 @@ func (lexer *Lexer) dumpString() {
 	lexer.tokens = append(lexer.tokens, lexer.Token(TokenString, str))
 }
 
+func (lexer *Lexer) dumpRegexp() {
+	str := lexer.buffer.String()
+	lexer.buffer.Reset()
+	lexer.tokens = append(lexer.tokens, lexer.Token(TokenRegexp, str))
+}
+
 func (lexer *Lexer) dumpBacktickString() {
 	str := lexer.buffer.String()
 	lexer.buffer.Reset()
@@ -314,6 +333,7 @@ func (x *Lexer) DecodeBrace(brace rune) Token {
 }
 
 func (lexer *Lexer) LexNextRune(r rune) error {
+	lexer.nrunes++
 top:
 	switch lexer.state {
 	case LexerComment:
@@ -353,6 +373,29 @@ top:
 		lexer.state = LexerStrLit
 		return nil
 
+	// inside #"...", backslashes are left for the regexp
+	// package to interpret; only \" is unescaped.
+	case LexerRegexpLit:
+		if r == '\\' {
+			lexer.state = LexerRegexpEscaped
+			return nil
+		}
+		if r == '"' {
+			lexer.dumpRegexp()
+			lexer.state = LexerNormal
+			return nil
+		}
+		lexer.buffer.WriteRune(r)
+		return nil
+
+	case LexerRegexpEscaped:
+		if r != '"' {
+			lexer.buffer.WriteRune('\\')
+		}
+		lexer.buffer.WriteRune(r)
+		lexer.state = LexerRegexpLit
+		return nil
+
 	case LexerUnquote:
 		if r == '@' {
 			lexer.tokens = append(
@@ -405,6 +448,12 @@ top:
 			return nil
 
 		case '"':
+			// #"..." is a regexp literal
+			if lexer.buffer.String() == "#" {
+				lexer.buffer.Reset()
+				lexer.state = LexerRegexpLit
+				return nil
+			}
 			if lexer.buffer.Len() > 0 {
 				return errors.New("Unexpected quote")
 			}
@@ -415,6 +464,15 @@ top:
 			lexer.state = LexerComment
 			return nil
 
+		// a #! line at the start of a script, for running it
+		// directly from the shell, is a comment.
+		case '!':
+			if lexer.nrunes == 2 && lexer.buffer.String() == "#" {
+				lexer.buffer.Reset()
+				lexer.state = LexerComment
+				return nil
+			}
+
 		// colon terminates a keyword symbol, e.g. in `mykey: "myvalue"`;
 		// mykey is the symbol.
 		// Exception: unless it is the := operator for fresh assigment.
@@ -468,6 +526,12 @@ top:
 		case ']':
 			fallthrough
 		case '{':
+			// #{ opens a set literal
+			if lexer.buffer.String() == "#" {
+				lexer.buffer.Reset()
+				lexer.tokens = append(lexer.tokens, lexer.Token(TokenLSetCurly, ""))
+				return nil
+			}
 			fallthrough
 		case '}':
 			err := lexer.dumpBuffer()
@@ -0,0 +1,374 @@
+package zygo
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Structs declared with (struct ...) can have methods written in zygo:
+//
+//   (func (r *Rect) Area [] (* (:W r) (:H r)))
+//   (func (r *Rect) Grow [by:float64] (.r.W = (+ (:W r) by)))
+//
+// A pointer receiver gets the record itself, a value receiver a copy
+// of it. Given (def rect (Rect W:2.0 H:3.0)), (rect.Area) calls the
+// method, as does (_method rect Area:), and methodls lists it. The
+// methods of a field declared with embed:true are promoted, as Go
+// promotes them: the shallowest wins, and two at one depth are
+// ambiguous.
+
+type UserMethod struct {
+	Name       string
+	Recv       string
+	RecvType   string
+	PtrRecv    bool
+	ParamNames []string
+	Params     []*RegisteredType // nil where a param has no type
+	Varargs    bool
+	Fn         *SexpFunction
+}
+
+func (m *UserMethod) SexpString() string {
+	star := ""
+	if m.PtrRecv {
+		star = "*"
+	}
+	s := fmt.Sprintf("(func (%s %s%s) %s [", m.Recv, star, m.RecvType, m.Name)
+	for i, p := range m.ParamNames {
+		if i > 0 {
+			s += " "
+		}
+		if m.Varargs && i == len(m.ParamNames)-1 {
+			s += "& "
+		}
+		s += p
+		if m.Params[i] != nil {
+			s += ":" + m.Params[i].ShortName()
+		}
+	}
+	return s + "])"
+}
+
+// MethodBuilder defines a method; FuncBuilder hands it
+// (func (recv Type) Name [params] body...).
+func MethodBuilder(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 3 {
+		return SexpNull, fmt.Errorf("bad method declaration. use: " +
+			"(func (recv Type) name [params] body...)")
+	}
+	recv, err := ListToArray(args[0])
+	if err != nil || len(recv) != 2 {
+		return SexpNull, fmt.Errorf("bad method receiver '%s'; use (recv Type) or (recv *Type)",
+			args[0].SexpString())
+	}
+	recvSym, isSym := recv[0].(SexpSymbol)
+	if !isSym {
+		return SexpNull, fmt.Errorf("bad method receiver '%s'; use (recv Type) or (recv *Type)",
+			args[0].SexpString())
+	}
+	m := &UserMethod{Recv: recvSym.name}
+	switch ty := recv[1].(type) {
+	case SexpSymbol:
+		m.RecvType = ty.name
+		if len(ty.name) > 1 && ty.name[0] == '*' {
+			m.RecvType = ty.name[1:]
+			m.PtrRecv = true
+		}
+	case SexpPair:
+		// (* Type)
+		ptr, err := ListToArray(ty)
+		if err == nil && len(ptr) == 2 && symbolNamed(ptr[0], "*") {
+			if sym, isSym := ptr[1].(SexpSymbol); isSym {
+				m.RecvType = sym.name
+				m.PtrRecv = true
+			}
+		}
+	}
+	if m.RecvType == "" {
+		return SexpNull, fmt.Errorf("bad method receiver type '%s'", recv[1].SexpString())
+	}
+	rt := env.Registry().Lookup(m.RecvType)
+	if rt == nil || rt.UserStructDefn == nil {
+		return SexpNull, fmt.Errorf("cannot define methods on '%s': not a struct declared with (struct ...)",
+			m.RecvType)
+	}
+	defn := rt.UserStructDefn
+
+	nameSym, isSym := args[1].(SexpSymbol)
+	if !isSym {
+		return SexpNull, fmt.Errorf("method name must be a symbol, not '%s'", args[1].SexpString())
+	}
+	m.Name = nameSym.name
+	if _, isField := defn.FieldType[m.Name]; isField {
+		return SexpNull, fmt.Errorf("%s has both a field and a method named %s", defn.Name, m.Name)
+	}
+
+	params, isArr := args[2].(*SexpArray)
+	if !isArr {
+		return SexpNull, fmt.Errorf("method %s.%s: params must be an array, not '%s'",
+			defn.Name, m.Name, args[2].SexpString())
+	}
+	fnargs := []Sexp{recvSym}
+	vals := params.Val
+	for i := 0; i < len(vals); i++ {
+		switch p := vals[i].(type) {
+		case SexpSymbol:
+			if p.name == "&" {
+				m.Varargs = true
+				fnargs = append(fnargs, p)
+				continue
+			}
+			m.ParamNames = append(m.ParamNames, p.name)
+			m.Params = append(m.Params, nil)
+			fnargs = append(fnargs, p)
+			continue
+		case SexpPair:
+			// name:Type reads as (quote name) Type
+			if sym, isQuo := isQuotedSymbol(p); isQuo && i+1 < len(vals) {
+				ev, err := env.Duplicate().EvalExpressions(vals[i+1 : i+2])
+				if err != nil {
+					return SexpNull, fmt.Errorf("method %s.%s: bad type for param %s: %v",
+						defn.Name, m.Name, sym.(SexpSymbol).name, err)
+				}
+				ty, isRt := ev.(*RegisteredType)
+				if !isRt {
+					return SexpNull, fmt.Errorf("method %s.%s: '%s' is not a type",
+						defn.Name, m.Name, vals[i+1].SexpString())
+				}
+				m.ParamNames = append(m.ParamNames, sym.(SexpSymbol).name)
+				m.Params = append(m.Params, ty)
+				fnargs = append(fnargs, sym)
+				i++
+				continue
+			}
+		}
+		return SexpNull, fmt.Errorf("method %s.%s: bad param '%s'", defn.Name, m.Name, vals[i].SexpString())
+	}
+
+	fnExpr := MakeList(append([]Sexp{env.MakeSymbol("fn"), &SexpArray{Val: fnargs}}, args[3:]...))
+	ev, err := env.Duplicate().EvalExpressions([]Sexp{fnExpr})
+	if err != nil {
+		return SexpNull, fmt.Errorf("method %s.%s: %v", defn.Name, m.Name, err)
+	}
+	fn, isFn := ev.(*SexpFunction)
+	if !isFn {
+		return SexpNull, fmt.Errorf("method %s.%s: body did not make a function", defn.Name, m.Name)
+	}
+	m.Fn = fn.Copy()
+	m.Fn.name = defn.Name + "." + m.Name
+
+	if _, redefined := defn.Methods[m.Name]; !redefined {
+		defn.MethodOrder = append(defn.MethodOrder, m.Name)
+	}
+	defn.Methods[m.Name] = m
+	return m.Fn, nil
+}
+
+// Call runs m with recv as its receiver.
+func (m *UserMethod) Call(env *Glisp, recv *SexpHash, args []Sexp) (Sexp, error) {
+	if len(args) < len(m.ParamNames)-1 || (!m.Varargs && len(args) != len(m.ParamNames)) {
+		return SexpNull, fmt.Errorf("method %s takes %d arguments, but we have %d",
+			m.Fn.name, len(m.ParamNames), len(args))
+	}
+	for i, p := range m.Params {
+		if p == nil || i >= len(args) {
+			continue
+		}
+		if p.IsInterface() {
+			if err := Satisfies(env, args[i], p); err != nil {
+				return SexpNull, fmt.Errorf("method %s: param %s is %s, cannot pass '%s': %v",
+					m.Fn.name, m.ParamNames[i], p.ShortName(), args[i].SexpString(), err)
+			}
+		} else if args[i].Type() != p {
+			return SexpNull, fmt.Errorf("method %s: param %s is %s, cannot pass '%s'",
+				m.Fn.name, m.ParamNames[i], p.ShortName(), args[i].SexpString())
+		}
+	}
+	var r Sexp = recv
+	if !m.PtrRecv {
+		cp, err := copyRecord(env, recv)
+		if err != nil {
+			return SexpNull, err
+		}
+		r = cp
+	}
+	return env.Apply(m.Fn, append([]Sexp{r}, args...))
+}
+
+func copyRecord(env *Glisp, h *SexpHash) (*SexpHash, error) {
+	kv := make([]Sexp, 0, 2*len(h.KeyOrder))
+	for _, k := range h.KeyOrder {
+		v, err := h.HashGet(env, k)
+		if err != nil {
+			return nil, err
+		}
+		kv = append(kv, k, v)
+	}
+	return MakeHash(kv, h.TypeName, env)
+}
+
+// embedded lists the fields of r declared with embed:true whose
+// type is a struct, or a pointer to one.
+func (r *RecordDefn) embedded() []EmbedPath {
+	var emb []EmbedPath
+	for i, f := range r.Fields {
+		if !IsTruthy(fieldOption((*SexpHash)(f), "embed")) {
+			continue
+		}
+		name := f.KeyOrder[0].(SexpSymbol).name
+		if embeddedDefn(r.FieldType[name]) != nil {
+			emb = append(emb, EmbedPath{ChildName: name, ChildFieldNum: i})
+		}
+	}
+	return emb
+}
+
+func embeddedDefn(rt *RegisteredType) *RecordDefn {
+	if rt != nil && rt.Kind == reflect.Ptr {
+		rt = rt.Elem
+	}
+	if rt == nil {
+		return nil
+	}
+	return rt.UserStructDefn
+}
+
+// FindMethod looks for the method name on r and then, a depth at a
+// time, on the structs embedded in it. path says how to get from a
+// record of type r to the receiver; m is nil if there is no method.
+func (r *RecordDefn) FindMethod(name string) (m *UserMethod, path []EmbedPath, err error) {
+	type visit struct {
+		defn *RecordDefn
+		path []EmbedPath
+	}
+	level := []visit{{defn: r}}
+	seen := map[*RecordDefn]bool{}
+	for len(level) > 0 {
+		var next []visit
+		for _, v := range level {
+			if seen[v.defn] {
+				continue
+			}
+			seen[v.defn] = true
+			if found, ok := v.defn.Methods[name]; ok {
+				if m != nil {
+					return nil, nil, fmt.Errorf("ambiguous selector %s.%s: both %s and %s have it",
+						r.Name, name, GetEmbedPath(path), GetEmbedPath(v.path))
+				}
+				m, path = found, v.path
+				continue
+			}
+			for _, e := range v.defn.embedded() {
+				p := append(append([]EmbedPath{}, v.path...), e)
+				next = append(next, visit{defn: embeddedDefn(v.defn.FieldType[e.ChildName]), path: p})
+			}
+		}
+		if m != nil {
+			return m, path, nil
+		}
+		level = next
+	}
+	return nil, nil, nil
+}
+
+// userMethod finds the zygo method name of h, and the record, h or
+// one embedded in it, that it is called on. m is nil if h has none.
+func (h *SexpHash) userMethod(env *Glisp, name string) (m *UserMethod, recv *SexpHash, err error) {
+	rt := env.Registry().Lookup(h.TypeName)
+	if rt == nil || rt.UserStructDefn == nil {
+		return nil, nil, nil
+	}
+	m, path, err := rt.UserStructDefn.FindMethod(name)
+	if m == nil || err != nil {
+		return nil, nil, err
+	}
+	recv = h
+	for _, step := range path {
+		x, err := recv.HashGet(env, env.MakeSymbol(step.ChildName))
+		if ptr, isPtr := x.(*SexpPointer); isPtr {
+			x = ptr.Target
+		}
+		next, isHash := x.(*SexpHash)
+		if err != nil || !isHash {
+			return nil, nil, fmt.Errorf("cannot call %s.%s: embedded field %s is not set",
+				h.TypeName, name, step.ChildName)
+		}
+		recv = next
+	}
+	return m, recv, nil
+}
+
+// userMethodList gives methodls entries for the zygo methods of
+// defn, promoted ones last with the path they come through.
+func userMethodList(defn *RecordDefn) []Sexp {
+	var list []Sexp
+	type visit struct {
+		defn *RecordDefn
+		path []EmbedPath
+	}
+	seenName := map[string]bool{}
+	seen := map[*RecordDefn]bool{}
+	level := []visit{{defn: defn}}
+	for len(level) > 0 {
+		var next []visit
+		for _, v := range level {
+			if seen[v.defn] {
+				continue
+			}
+			seen[v.defn] = true
+			suffix := ""
+			if len(v.path) > 0 {
+				suffix = fmt.Sprintf(" embed-path<%s>", GetEmbedPath(v.path))
+			}
+			for _, name := range v.defn.MethodOrder {
+				if !seenName[name] {
+					list = append(list, SexpStr{S: name + " " + v.defn.Methods[name].SexpString() + suffix})
+				}
+			}
+			for _, e := range v.defn.embedded() {
+				p := append(append([]EmbedPath{}, v.path...), e)
+				next = append(next, visit{defn: embeddedDefn(v.defn.FieldType[e.ChildName]), path: p})
+			}
+		}
+		for _, v := range level {
+			for _, name := range v.defn.MethodOrder {
+				seenName[name] = true
+			}
+		}
+		level = next
+	}
+	return list
+}
+
+// MethodValue gives the method name of record h bound to h, for
+// (obj.Method args): a zygo method, a function held in the field
+// name, or a method of h's Go struct.
+func (h *SexpHash) MethodValue(env *Glisp, name string) (Sexp, error) {
+	m, recv, err := h.userMethod(env, name)
+	if err != nil {
+		return SexpNull, err
+	}
+	if m != nil {
+		return MakeUserFunction(h.TypeName+"."+name, func(env *Glisp, _ string, args []Sexp) (Sexp, error) {
+			return m.Call(env, recv, args)
+		}), nil
+	}
+	if x, err := h.HashGet(env, env.MakeSymbol(name)); err == nil {
+		if fn, isFn := x.(*SexpFunction); isFn {
+			return fn, nil
+		}
+	}
+	if rt := env.Registry().Lookup(h.TypeName); rt != nil {
+		_, isBound := rt.Methods[name]
+		isGo := false
+		if rt.hasShadowStruct && rt.TypeCache != nil {
+			_, isGo = rt.TypeCache.MethodByName(name)
+		}
+		if isBound || isGo {
+			return MakeUserFunction(h.TypeName+"."+name, func(env *Glisp, _ string, args []Sexp) (Sexp, error) {
+				return CallGoMethodFunction(env, "_method", append([]Sexp{h, SexpStr{S: name}}, args...))
+			}), nil
+		}
+	}
+	return SexpNull, fmt.Errorf("%s has no method '%s'", h.TypeName, name)
+}
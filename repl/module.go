@@ -0,0 +1,415 @@
+package zygo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Unlike source and req, which run a file in the caller's scope,
+// import loads a file as a module: its top-level definitions go
+// into a scope of its own, and the importer reaches them as m.name
+// through the symbol the module is bound to. A module may name
+// itself with (ns name) and list its public definitions with
+// (export name...); without an export list, every definition is
+// public. A module is loaded once, however many times it is
+// imported, and a module that imports itself, directly or not, is
+// an error.
+
+// SexpModule is a zygo file loaded by import.
+type SexpModule struct {
+	Name string
+	Path string
+
+	abs     string
+	scope   *Scope
+	exports map[int]bool // nil when the module has no export list
+}
+
+func (m *SexpModule) SexpString() string {
+	return fmt.Sprintf("(module %s %q)", m.Name, m.Path)
+}
+
+func (m *SexpModule) Type() *RegisteredType {
+	return nil
+}
+
+// Lookup returns the public definition name of the module. A name
+// like util.f looks for f in the module that this one binds to util.
+func (m *SexpModule) Lookup(env *Glisp, name string) (Sexp, error) {
+	member, rest := name, ""
+	if i := strings.Index(name, "."); i > 0 {
+		member, rest = name[:i], name[i+1:]
+	}
+	sym := env.MakeSymbol(member)
+	val, found := m.scope.Map[sym.number]
+	if !found {
+		return SexpNull, fmt.Errorf("module %s has no definition '%s'", m.Name, member)
+	}
+	if m.exports != nil && !m.exports[sym.number] {
+		return SexpNull, fmt.Errorf("'%s' is private to module %s", member, m.Name)
+	}
+	if rest == "" {
+		return val, nil
+	}
+	sub, isMod := val.(*SexpModule)
+	if !isMod {
+		return SexpNull, fmt.Errorf("'%s' in module %s is not a module, "+
+			"so cannot look up '%s' in it", member, m.Name, rest)
+	}
+	return sub.Lookup(env, rest)
+}
+
+// Exports returns the names of the module's public definitions,
+// sorted.
+func (m *SexpModule) Exports(env *Glisp) []string {
+	names := []string{}
+	for num := range m.scope.Map {
+		if m.exports == nil || m.exports[num] {
+			names = append(names, env.revsymtable[num])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ModuleError reports a module that failed to load, along with the
+// chain of imports that led to it.
+type ModuleError struct {
+	Chain []string // module names, outermost first
+	Path  string
+	Cycle bool
+	Err   error
+}
+
+func (e *ModuleError) Error() string {
+	if e.Cycle {
+		return "import cycle: " + strings.Join(e.Chain, " -> ")
+	}
+	return fmt.Sprintf("import %s (%s): %v", strings.Join(e.Chain, " -> "), e.Path, e.Err)
+}
+
+// moduleTable holds the modules loaded so far, by absolute path,
+// and the ones being loaded right now, innermost last.
+type moduleTable struct {
+	loaded  map[string]*SexpModule
+	loading []*SexpModule
+}
+
+func newModuleTable() *moduleTable {
+	return &moduleTable{loaded: make(map[string]*SexpModule)}
+}
+
+func (t *moduleTable) current() *SexpModule {
+	if len(t.loading) == 0 {
+		return nil
+	}
+	return t.loading[len(t.loading)-1]
+}
+
+func (t *moduleTable) chain(from int) []string {
+	names := make([]string, 0, len(t.loading)-from+1)
+	for _, m := range t.loading[from:] {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+// searchDirs are where import looks for a module: next to the
+// module doing the importing, then the current directory, then
+// $ZYGOPATH.
+func (t *moduleTable) searchDirs() []string {
+	dirs := []string{}
+	if m := t.current(); m != nil {
+		dirs = append(dirs, filepath.Dir(m.Path))
+	}
+	dirs = append(dirs, ".")
+	return append(dirs, ZygoPath()...)
+}
+
+// ZygoPath returns the directories listed in $ZYGOPATH, which
+// import and source search for files.
+func ZygoPath() []string {
+	return filepath.SplitList(os.Getenv("ZYGOPATH"))
+}
+
+// searchPath returns the first of dir/file, for each of dirs, that
+// exists. An absolute file is returned as it is if it exists.
+func searchPath(file string, dirs []string) (string, error) {
+	if filepath.IsAbs(file) {
+		if FileExists(file) {
+			return file, nil
+		}
+		return "", fmt.Errorf("cannot find %s", file)
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, file)
+		if FileExists(path) {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("cannot find %s in %s", file,
+		strings.Join(dirs, string(filepath.ListSeparator)))
+}
+
+// LoadModule loads the file at path as a module called name, unless
+// (ns ...) in the file says otherwise. Loading a file that is
+// already loaded returns the same module again.
+func (env *Glisp) LoadModule(name string, path string) (*SexpModule, error) {
+	t := env.modules
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if mod, loaded := t.loaded[abs]; loaded {
+		return mod, nil
+	}
+	for i, m := range t.loading {
+		if m.abs == abs {
+			return nil, &ModuleError{Chain: append(t.chain(i), m.Name), Path: path, Cycle: true}
+		}
+	}
+
+	mod := &SexpModule{
+		Name:  name,
+		Path:  path,
+		abs:   abs,
+		scope: env.NewNamedScope("module " + name),
+	}
+	t.loading = append(t.loading, mod)
+	defer func() { t.loading = t.loading[:len(t.loading)-1] }()
+
+	exps, err := env.parseModule(path)
+	if err == nil {
+		err = env.runModule(mod, exps)
+	}
+	if err == nil {
+		err = mod.checkExports(env)
+	}
+	if err != nil {
+		// a module imported from this one failed, and already
+		// said where.
+		var modErr *ModuleError
+		if errors.As(err, &modErr) {
+			return nil, modErr
+		}
+		return nil, &ModuleError{Chain: t.chain(0), Path: path, Err: err}
+	}
+	t.loaded[abs] = mod
+	return mod, nil
+}
+
+// parseModule uses a parser of its own, since import may run while
+// env.parser is in the middle of its input, or in a duplicate
+// environment that has no parser.
+func (env *Glisp) parseModule(path string) ([]Sexp, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := env.NewParser()
+	p.Start()
+	defer p.Stop()
+	p.ResetAddNewInput(bufio.NewReader(f))
+	exps, err := p.ParseTokens()
+	if err != nil {
+		return nil, fmt.Errorf("error parsing on line %d: %v", p.lexer.Linenum(), err)
+	}
+	return exps, nil
+}
+
+// runModule runs the module's expressions with only the global
+// scope beneath the module's own, so its definitions land in
+// mod.scope, and functions it defines close over it.
+func (env *Glisp) runModule(mod *SexpModule, exps []Sexp) error {
+	saved := env.linearstack
+	env.linearstack = env.NewStack(ScopeStackSize)
+	env.linearstack.Push(saved.elements[0])
+	env.linearstack.Push(mod.scope)
+	defer func() { env.linearstack = saved }()
+
+	return env.SourceExpressions(exps)
+}
+
+func (m *SexpModule) checkExports(env *Glisp) error {
+	for num := range m.exports {
+		if _, found := m.scope.Map[num]; !found {
+			return fmt.Errorf("module %s exports '%s', which it does not define",
+				m.Name, env.revsymtable[num])
+		}
+	}
+	return nil
+}
+
+// lookupQualified finds m.name, where m is bound to a module, and
+// obj.Method, where obj is bound to a record. found is false if sym
+// names neither.
+func (env *Glisp) lookupQualified(sym SexpSymbol) (val Sexp, found bool, err error) {
+	i := strings.Index(sym.name, ".")
+	if i <= 0 || i == len(sym.name)-1 {
+		return SexpNull, false, nil
+	}
+	if _, known := env.symtable[sym.name[:i]]; !known {
+		return SexpNull, false, nil
+	}
+	x, err, _ := env.LexicalLookupSymbol(env.MakeSymbol(sym.name[:i]), false)
+	if err != nil {
+		return SexpNull, false, nil
+	}
+	if ptr, isPtr := x.(*SexpPointer); isPtr {
+		x = ptr.Target
+	}
+	switch obj := x.(type) {
+	case *SexpModule:
+		val, err = obj.Lookup(env, sym.name[i+1:])
+		return val, true, err
+	case *SexpHash:
+		val, err = obj.MethodValue(env, sym.name[i+1:])
+		return val, true, err
+	}
+	return SexpNull, false, nil
+}
+
+func symbolNamed(x Sexp, name string) bool {
+	sym, isSym := x.(SexpSymbol)
+	return isSym && sym.name == name
+}
+
+// (import mylib) loads mylib.zy as a module and binds it to mylib.
+// (import mylib :as m) binds it to m instead, and (import "file.zy")
+// imports a file by its path. A name like net/http is looked for as
+// net/http.zy, and binds to http. The import macro quotes its
+// arguments for __import.
+func ImportFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	spec, err := SeqToArray(args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+
+	// the lexer reads :as as the two symbols : and as
+	alias := ""
+	switch {
+	case len(spec) == 1:
+	case len(spec) == 3 && symbolNamed(spec[1], "as"):
+		alias = spec[2].SexpString()
+	case len(spec) == 4 && symbolNamed(spec[1], ":") && symbolNamed(spec[2], "as"):
+		alias = spec[3].SexpString()
+	default:
+		return SexpNull, fmt.Errorf("import: use (import name) or (import name :as alias)")
+	}
+	if alias != "" {
+		if _, isSym := spec[len(spec)-1].(SexpSymbol); !isSym {
+			return SexpNull, fmt.Errorf("import: alias must be a symbol, but we had '%s'", alias)
+		}
+	}
+
+	var file, want string
+	switch t := spec[0].(type) {
+	case SexpSymbol:
+		want = t.name
+		file = want + ".zy"
+	case SexpStr:
+		file = t.S
+	default:
+		return SexpNull, fmt.Errorf("import: module must be a symbol or a string path, "+
+			"but we had %T / val = '%s'", spec[0], spec[0].SexpString())
+	}
+
+	path, err := searchPath(file, env.modules.searchDirs())
+	if err != nil {
+		return SexpNull, fmt.Errorf("import: %v", err)
+	}
+	modName := want
+	if modName == "" {
+		modName = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	mod, err := env.LoadModule(modName, path)
+	if err != nil {
+		return SexpNull, err
+	}
+	if want != "" && mod.Name != want {
+		return SexpNull, fmt.Errorf("import: %s declares (ns %s), but was imported as %s",
+			path, mod.Name, want)
+	}
+
+	if alias == "" {
+		alias = mod.Name[strings.LastIndex(mod.Name, "/")+1:]
+	}
+	err = env.LexicalBindSymbol(env.MakeSymbol(alias), mod)
+	if err != nil {
+		return SexpNull, err
+	}
+	return mod, nil
+}
+
+// (ns name) names the module being loaded. Outside of import it
+// does nothing, so that a module can be run as a script too.
+func NsFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	sym, isSym := args[0].(SexpSymbol)
+	if !isSym {
+		return SexpNull, fmt.Errorf("ns: module name must be a symbol, but we had '%s'",
+			args[0].SexpString())
+	}
+	if mod := env.modules.current(); mod != nil {
+		mod.Name = sym.name
+	}
+	return SexpNull, nil
+}
+
+// (export name...) makes the named definitions of the module being
+// loaded public, and the rest private to it.
+func ExportFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	names, err := SeqToArray(args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	mod := env.modules.current()
+	if mod != nil && mod.exports == nil {
+		mod.exports = make(map[int]bool)
+	}
+	for _, x := range names {
+		sym, isSym := x.(SexpSymbol)
+		if !isSym {
+			return SexpNull, fmt.Errorf("export: names must be symbols, but we had '%s'",
+				x.SexpString())
+		}
+		if mod != nil {
+			mod.exports[sym.number] = true
+		}
+	}
+	return SexpNull, nil
+}
+
+// (module-exports m) returns the sorted names of m's public
+// definitions, as symbols.
+func ModuleExportsFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	mod, isMod := args[0].(*SexpModule)
+	if !isMod {
+		return SexpNull, fmt.Errorf("%s: argument must be a module, but we had %T / val = '%s'",
+			name, args[0], args[0].SexpString())
+	}
+	names := mod.Exports(env)
+	syms := make([]Sexp, len(names))
+	for i, n := range names {
+		syms[i] = env.MakeSymbol(n)
+	}
+	return &SexpArray{Val: syms}, nil
+}
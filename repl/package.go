@@ -46,11 +46,19 @@ type RecordDefn struct {
 	Name      string
 	Fields    []*SexpField
 	FieldType map[string]*RegisteredType
+
+	// Methods are those defined in zygo; see methods.go.
+	Methods     map[string]*UserMethod
+	MethodOrder []string
+
+	// rt is the type registered for this definition.
+	rt *RegisteredType
 }
 
 func NewRecordDefn() *RecordDefn {
 	return &RecordDefn{
 		FieldType: make(map[string]*RegisteredType),
+		Methods:   make(map[string]*UserMethod),
 	}
 }
 
@@ -67,10 +75,11 @@ func (r *RecordDefn) SetFields(flds []*SexpField) {
 	}
 }
 
+// Type returns the type registered for p by defstruct, in the
+// registry of the env that ran it, or nil if p was never registered.
+// It does not look p up by name, since that depends on the env.
 func (p *RecordDefn) Type() *RegisteredType {
-	rt := GoStructRegistry.Registry[p.Name]
-	//Q("RecordDefn) Type() sees rt = %v", rt)
-	return rt
+	return p.rt
 }
 
 // pretty print a struct
@@ -263,17 +272,19 @@ func StructBuilder(env *Glisp, name string,
 	env.datastack.PushExpr(SexpNull)
 	structName := symN.name
 
+	var rtR *RegisteredType
 	{
 		// begin enable recursion -- add ourselves to the env early, then
 		// update later, so that structs can refer to themselves.
 		udsR := NewRecordDefn()
 		udsR.SetName(structName)
-		rtR := NewRegisteredType(func(env *Glisp) (interface{}, error) {
+		rtR = NewRegisteredType(func(env *Glisp) (interface{}, error) {
 			return udsR, nil
 		})
 		rtR.UserStructDefn = udsR
+		udsR.rt = rtR
 		rtR.DisplayAs = structName
-		GoStructRegistry.RegisterUserdef(structName, rtR, false)
+		env.Registry().RegisterUserdef(structName, rtR, false)
 
 		// overwrite any existing definition, deliberately ignore any error,
 		// as there may not be a prior definition present at all.
@@ -349,8 +360,10 @@ func StructBuilder(env *Glisp, name string,
 		return uds, nil
 	})
 	rt.UserStructDefn = uds
+	uds.rt = rt
 	rt.DisplayAs = structName
-	GoStructRegistry.RegisterUserdef(structName, rt, false)
+	rt.adoptDerived(rtR)
+	env.Registry().RegisterUserdef(structName, rt, false)
 	Q("good: registered new userdefined struct '%s'", structName)
 
 	// replace our recursive-reference-enabling symbol with the real one.
@@ -386,6 +399,8 @@ func PackageBuilder(env *Glisp, name string,
 	return SexpNull, nil
 }
 
+// (interface Name [(func method [params] [results]) ...]) declares
+// an interface; see interfaces.go.
 func InterfaceBuilder(env *Glisp, name string,
 	args []Sexp) (Sexp, error) {
 
@@ -393,13 +408,64 @@ func InterfaceBuilder(env *Glisp, name string,
 		return SexpNull, fmt.Errorf("interface name is missing. use: " +
 			"(interface interface-name ...)\n")
 	}
+	if len(args) > 2 {
+		return SexpNull, fmt.Errorf("bad interface declaration: more than two arguments." +
+			"prototype is (interface name [(func ...)*] )")
+	}
 
 	Q("in interface builder, args = ")
 	for i := range args {
 		Q("args[%v] = '%s'", i, args[i].SexpString())
 	}
 
-	return SexpNull, nil
+	var symN SexpSymbol
+	switch b := args[0].(type) {
+	case SexpSymbol:
+		symN = b
+	case SexpPair:
+		sy, isQuo := isQuotedSymbol(b)
+		if !isQuo {
+			return SexpNull, fmt.Errorf("bad interface name: symbol required")
+		}
+		symN = sy.(SexpSymbol)
+	default:
+		return SexpNull, fmt.Errorf("bad interface name: symbol required")
+	}
+	ifaceName := symN.name
+
+	defn := &InterfaceDefn{Name: ifaceName}
+	rt := NewRegisteredType(func(env *Glisp) (interface{}, error) {
+		return defn, nil
+	})
+	rt.UserInterfaceDefn = defn
+	rt.DisplayAs = ifaceName
+
+	// bind early, so methods can take and return the interface itself.
+	env.Registry().RegisterUserdef(ifaceName, rt, false)
+	env.linearstack.DeleteSymbolFromTopOfStackScope(symN)
+	err := env.LexicalBindSymbol(symN, rt)
+	if err != nil {
+		return SexpNull, fmt.Errorf("interface builder could not bind symbol '%s': '%v'",
+			ifaceName, err)
+	}
+
+	if len(args) == 2 {
+		arr, isArr := args[1].(*SexpArray)
+		if !isArr {
+			return SexpNull, fmt.Errorf("bad interface declaration '%v': second argument "+
+				"must be a slice of methods."+
+				" prototype is (interface name [(func ...)*] )", ifaceName)
+		}
+		for i, ele := range arr.Val {
+			sig, err := parseMethodSig(env, ele)
+			if err != nil {
+				return SexpNull, fmt.Errorf("bad interface declaration '%v': bad "+
+					"method at array entry %v; %v", ifaceName, i, err)
+			}
+			defn.Methods = append(defn.Methods, sig)
+		}
+	}
+	return rt, nil
 }
 
 func FuncBuilder(env *Glisp, name string,
@@ -410,6 +476,13 @@ func FuncBuilder(env *Glisp, name string,
 			"(func func-name ...)\n")
 	}
 
+	// (func (recv Type) name [params] body...) defines a method.
+	if recv, isList := args[0].(SexpPair); isList {
+		if _, isQuo := isQuotedSymbol(recv); !isQuo {
+			return MethodBuilder(env, name, args)
+		}
+	}
+
 	Q("in func builder, args = ")
 	for i := range args {
 		Q("args[%v] = '%s'", i, args[i].SexpString())
@@ -442,7 +515,7 @@ func SliceOfFunction(env *Glisp, name string,
 
 	Q("slice-of arg = '%s' with type %T", args[0].SexpString(), args[0])
 
-	sliceRt := GoStructRegistry.GetOrCreateSliceType(rt)
+	sliceRt := env.Registry().GetOrCreateSliceType(rt)
 	Q("in SliceOfFunction: returning sliceRt = '%#v'", sliceRt)
 	return sliceRt, nil
 }
@@ -466,7 +539,7 @@ func PointerToFunction(env *Glisp, name string,
 	case *SexpPointer:
 		// dereference operation, rather than type declaration
 		Q("dereference operation on *SexpPointer detected, returning target")
-		if arg == nil || arg.Target == nil {
+		if arg == nil || arg.Target == nil || arg.Target == SexpNull {
 			return SexpNull, fmt.Errorf("illegal to dereference nil pointer")
 		}
 		return arg.Target, nil
@@ -482,7 +555,7 @@ func PointerToFunction(env *Glisp, name string,
 
 	Q("pointer-to arg = '%s' with type %T", args[0].SexpString(), args[0])
 
-	ptrRt := GoStructRegistry.GetOrCreatePointerType(rt)
+	ptrRt := env.Registry().GetOrCreatePointerType(rt)
 	return ptrRt, nil
 }
 
@@ -610,8 +683,11 @@ func ArrayOfFunction(env *Glisp, name string,
 		return reflect.New(derivedType), nil
 	})
 	arrayRt.DisplayAs = fmt.Sprintf("(%s %s)", name, rt.DisplayAs)
+	arrayRt.Elem = rt
+	arrayRt.Kind = reflect.Array
+	arrayRt.ArrayLen = sz
 	arrayName := "array-of-" + rt.RegisteredName
-	GoStructRegistry.RegisterUserdef(arrayName, arrayRt, false)
+	env.Registry().RegisterUserdef(arrayName, arrayRt, false)
 	return arrayRt, nil
 }
 
@@ -668,15 +744,20 @@ func VarBuilder(env *Glisp, name string,
 	var valSexp Sexp
 	Q("val is of type %T", val)
 	switch v := val.(type) {
-	case Sexp:
-		valSexp = v
 	case reflect.Value:
+		if rt.Kind == reflect.Ptr {
+			// a nil pointer that knows its type
+			valSexp = &SexpPointer{Target: SexpNull, PointedToType: rt.Elem, MyType: rt}
+			break
+		}
 		Q("v is of type %T", v.Interface())
 		switch rd := v.Interface().(type) {
 		case ***RecordDefn:
 			Q("we have RecordDefn rd = %#v", *rd)
 		}
 		valSexp = SexpReflect(reflect.ValueOf(v))
+	case Sexp:
+		valSexp = v
 	default:
 		valSexp = SexpReflect(reflect.ValueOf(v))
 	}
@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"regexp"
 	"strconv"
 	"sync"
+	"unicode/utf8"
 )
 
 type Parser struct {
@@ -337,6 +339,18 @@ func (parser *Parser) ParseHash(depth int) (Sexp, error) {
 	return list, nil
 }
 
+// ParseSet reads the members of a #{...} set literal, which
+// shares its closing brace with a hash literal.
+func (parser *Parser) ParseSet(depth int) (Sexp, error) {
+	exp, err := parser.ParseHash(depth)
+	if err != nil {
+		return exp, err
+	}
+	list := exp.(SexpPair)
+	list.Head = parser.env.MakeSymbol("hash-set")
+	return list, nil
+}
+
 func (parser *Parser) ParseExpression(depth int) (res Sexp, err error) {
 	//	defer func() {
 	//		P("returning from ParseExpression at depth=%v with res='%s'\n", depth, res.SexpString())
@@ -360,6 +374,9 @@ func (parser *Parser) ParseExpression(depth int) (res Sexp, err error) {
 	case TokenLCurly:
 		exp, err := parser.ParseHash(depth + 1)
 		return exp, err
+	case TokenLSetCurly:
+		exp, err := parser.ParseSet(depth + 1)
+		return exp, err
 	case TokenQuote:
 		expr, err := parser.ParseExpression(depth + 1)
 		if err != nil {
@@ -420,11 +437,20 @@ func (parser *Parser) ParseExpression(depth int) (res Sexp, err error) {
 		}
 		return &SexpInt{Val: i}, nil
 	case TokenChar:
-		return SexpChar{Val: rune(tok.str[0])}, nil
+		r, _ := utf8.DecodeRuneInString(tok.str)
+		return SexpChar{Val: r}, nil
 	case TokenString:
 		return SexpStr{S: tok.str}, nil
 	case TokenBacktickString:
 		return SexpStr{S: tok.str, backtick: true}, nil
+	case TokenRegexp:
+		// compiled once, here, rather than each time the
+		// expression is evaluated.
+		r, err := regexp.Compile(tok.str)
+		if err != nil {
+			return SexpNull, fmt.Errorf("bad regexp literal %s: %v", tok, err)
+		}
+		return (*SexpRegexp)(r), nil
 	case TokenFloat:
 		f, err := strconv.ParseFloat(tok.str, SexpFloatSize)
 		if err != nil {
@@ -0,0 +1,728 @@
+package zygo
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"sync"
+)
+
+// persistent.go: immutable vectors and maps with structural
+// sharing. Every update returns a new value and leaves the old
+// one untouched, so a pvec or pmap can be handed to another
+// goroutine without copying and without surprises.
+//
+// SexpPVec is a 32-way trie with a tail buffer, in the style of
+// Clojure's PersistentVector. SexpPMap is a hash array mapped
+// trie (HAMT); keys whose hashes collide completely end up
+// together in a collision node and are told apart with Compare.
+
+const (
+	pvBits  = 5
+	pvWidth = 1 << pvBits
+	pvMask  = pvWidth - 1
+)
+
+type pvNode struct {
+	kids []*pvNode
+	vals []Sexp
+}
+
+type SexpPVec struct {
+	cnt   int
+	shift uint
+	root  *pvNode
+	tail  []Sexp
+}
+
+var emptyPVec = &SexpPVec{shift: pvBits, root: &pvNode{}}
+
+// NewPVec returns a persistent vector holding elems.
+func NewPVec(elems []Sexp) *SexpPVec {
+	v := emptyPVec
+	for _, e := range elems {
+		v = v.Conj(e)
+	}
+	return v
+}
+
+func (v *SexpPVec) Len() int {
+	return v.cnt
+}
+
+func (v *SexpPVec) tailoff() int {
+	if v.cnt < pvWidth {
+		return 0
+	}
+	return ((v.cnt - 1) >> pvBits) << pvBits
+}
+
+// Nth returns the i-th element, or false if i is out of range.
+func (v *SexpPVec) Nth(i int) (Sexp, bool) {
+	if i < 0 || i >= v.cnt {
+		return SexpNull, false
+	}
+	if i >= v.tailoff() {
+		return v.tail[i-v.tailoff()], true
+	}
+	node := v.root
+	for level := v.shift; level > 0; level -= pvBits {
+		node = node.kids[(i>>level)&pvMask]
+	}
+	return node.vals[i&pvMask], true
+}
+
+// Conj returns a new vector with x appended.
+func (v *SexpPVec) Conj(x Sexp) *SexpPVec {
+	if v.cnt-v.tailoff() < pvWidth {
+		tail := make([]Sexp, len(v.tail)+1)
+		copy(tail, v.tail)
+		tail[len(v.tail)] = x
+		return &SexpPVec{cnt: v.cnt + 1, shift: v.shift, root: v.root, tail: tail}
+	}
+
+	// the tail is full: push it down into the trie.
+	tailnode := &pvNode{vals: v.tail}
+	shift := v.shift
+	var root *pvNode
+	if (v.cnt >> pvBits) > (1 << v.shift) {
+		root = &pvNode{kids: []*pvNode{v.root, pvNewPath(v.shift, tailnode)}}
+		shift += pvBits
+	} else {
+		root = v.pushTail(v.shift, v.root, tailnode)
+	}
+	return &SexpPVec{cnt: v.cnt + 1, shift: shift, root: root, tail: []Sexp{x}}
+}
+
+func (v *SexpPVec) pushTail(level uint, parent *pvNode, tailnode *pvNode) *pvNode {
+	sub := ((v.cnt - 1) >> level) & pvMask
+	ret := &pvNode{kids: make([]*pvNode, sub+1)}
+	copy(ret.kids, parent.kids)
+	if level == pvBits {
+		ret.kids[sub] = tailnode
+	} else if sub < len(parent.kids) {
+		ret.kids[sub] = v.pushTail(level-pvBits, parent.kids[sub], tailnode)
+	} else {
+		ret.kids[sub] = pvNewPath(level-pvBits, tailnode)
+	}
+	return ret
+}
+
+func pvNewPath(level uint, node *pvNode) *pvNode {
+	if level == 0 {
+		return node
+	}
+	return &pvNode{kids: []*pvNode{pvNewPath(level-pvBits, node)}}
+}
+
+// Assoc returns a new vector with element i replaced by x.
+// Assoc at index Len() appends.
+func (v *SexpPVec) Assoc(i int, x Sexp) (*SexpPVec, error) {
+	if i == v.cnt {
+		return v.Conj(x), nil
+	}
+	if i < 0 || i > v.cnt {
+		return nil, fmt.Errorf("pvec index %d out of bounds", i)
+	}
+	if i >= v.tailoff() {
+		tail := make([]Sexp, len(v.tail))
+		copy(tail, v.tail)
+		tail[i-v.tailoff()] = x
+		return &SexpPVec{cnt: v.cnt, shift: v.shift, root: v.root, tail: tail}, nil
+	}
+	return &SexpPVec{cnt: v.cnt, shift: v.shift,
+		root: pvAssoc(v.shift, v.root, i, x), tail: v.tail}, nil
+}
+
+func pvAssoc(level uint, node *pvNode, i int, x Sexp) *pvNode {
+	if level == 0 {
+		vals := make([]Sexp, len(node.vals))
+		copy(vals, node.vals)
+		vals[i&pvMask] = x
+		return &pvNode{vals: vals}
+	}
+	kids := make([]*pvNode, len(node.kids))
+	copy(kids, node.kids)
+	sub := (i >> level) & pvMask
+	kids[sub] = pvAssoc(level-pvBits, node.kids[sub], i, x)
+	return &pvNode{kids: kids}
+}
+
+// Elems returns the elements in a freshly allocated slice.
+func (v *SexpPVec) Elems() []Sexp {
+	res := make([]Sexp, 0, v.cnt)
+	for i := 0; i < v.cnt; i++ {
+		x, _ := v.Nth(i)
+		res = append(res, x)
+	}
+	return res
+}
+
+func (v *SexpPVec) SexpString() string {
+	str := "(pvec"
+	for _, x := range v.Elems() {
+		str += " " + x.SexpString()
+	}
+	return str + ")"
+}
+
+func (v *SexpPVec) Type() *RegisteredType {
+	return nil
+}
+
+type hamtEntry struct {
+	hash  uint32
+	key   Sexp
+	val   Sexp
+	child *hamtNode
+}
+
+// hamtNode is either a bitmap indexed node, whose entries are
+// leaves or children, or (once all the hash bits are used up)
+// a collision node holding only leaves.
+type hamtNode struct {
+	bitmap    uint32
+	collision bool
+	entries   []hamtEntry
+}
+
+func newHamtNode(shift uint) *hamtNode {
+	return &hamtNode{collision: shift >= 32}
+}
+
+func (n *hamtNode) clone() *hamtNode {
+	c := &hamtNode{bitmap: n.bitmap, collision: n.collision,
+		entries: make([]hamtEntry, len(n.entries))}
+	copy(c.entries, n.entries)
+	return c
+}
+
+func (n *hamtNode) index(h uint32, shift uint) (bit uint32, idx int) {
+	bit = uint32(1) << ((h >> shift) & pvMask)
+	return bit, bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+func pmapKeysEqual(a, b Sexp) bool {
+	res, err := Compare(a, b)
+	return err == nil && res == 0
+}
+
+func (n *hamtNode) get(h uint32, shift uint, key Sexp) (Sexp, bool) {
+	for {
+		if n.collision {
+			for _, e := range n.entries {
+				if pmapKeysEqual(e.key, key) {
+					return e.val, true
+				}
+			}
+			return SexpNull, false
+		}
+		bit, idx := n.index(h, shift)
+		if n.bitmap&bit == 0 {
+			return SexpNull, false
+		}
+		e := n.entries[idx]
+		if e.child == nil {
+			if pmapKeysEqual(e.key, key) {
+				return e.val, true
+			}
+			return SexpNull, false
+		}
+		n = e.child
+		shift += pvBits
+	}
+}
+
+func (n *hamtNode) assoc(h uint32, shift uint, key, val Sexp) (*hamtNode, bool) {
+	if n.collision {
+		c := n.clone()
+		for i, e := range n.entries {
+			if pmapKeysEqual(e.key, key) {
+				c.entries[i].val = val
+				return c, false
+			}
+		}
+		c.entries = append(c.entries, hamtEntry{hash: h, key: key, val: val})
+		return c, true
+	}
+
+	bit, idx := n.index(h, shift)
+	if n.bitmap&bit == 0 {
+		c := &hamtNode{bitmap: n.bitmap | bit,
+			entries: make([]hamtEntry, len(n.entries)+1)}
+		copy(c.entries, n.entries[:idx])
+		c.entries[idx] = hamtEntry{hash: h, key: key, val: val}
+		copy(c.entries[idx+1:], n.entries[idx:])
+		return c, true
+	}
+
+	e := n.entries[idx]
+	c := n.clone()
+	if e.child != nil {
+		child, added := e.child.assoc(h, shift+pvBits, key, val)
+		c.entries[idx].child = child
+		return c, added
+	}
+	if pmapKeysEqual(e.key, key) {
+		c.entries[idx].val = val
+		return c, false
+	}
+	// two different keys share this slot; push both down a level.
+	sub := newHamtNode(shift + pvBits)
+	sub, _ = sub.assoc(e.hash, shift+pvBits, e.key, e.val)
+	sub, _ = sub.assoc(h, shift+pvBits, key, val)
+	c.entries[idx] = hamtEntry{child: sub}
+	return c, true
+}
+
+func (n *hamtNode) without(h uint32, shift uint, key Sexp) (*hamtNode, bool) {
+	if n.collision {
+		for i, e := range n.entries {
+			if pmapKeysEqual(e.key, key) {
+				c := &hamtNode{collision: true}
+				c.entries = append(c.entries, n.entries[:i]...)
+				c.entries = append(c.entries, n.entries[i+1:]...)
+				return c, true
+			}
+		}
+		return n, false
+	}
+
+	bit, idx := n.index(h, shift)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	e := n.entries[idx]
+	if e.child != nil {
+		child, removed := e.child.without(h, shift+pvBits, key)
+		if !removed {
+			return n, false
+		}
+		c := n.clone()
+		switch {
+		case len(child.entries) == 0:
+			return c.removeEntry(bit, idx), true
+		case len(child.entries) == 1 && child.entries[0].child == nil:
+			// a lone leaf moves back up into its parent.
+			c.entries[idx] = child.entries[0]
+		default:
+			c.entries[idx].child = child
+		}
+		return c, true
+	}
+	if !pmapKeysEqual(e.key, key) {
+		return n, false
+	}
+	return n.removeEntry(bit, idx), true
+}
+
+func (n *hamtNode) removeEntry(bit uint32, idx int) *hamtNode {
+	c := &hamtNode{bitmap: n.bitmap &^ bit,
+		entries: make([]hamtEntry, 0, len(n.entries)-1)}
+	c.entries = append(c.entries, n.entries[:idx]...)
+	c.entries = append(c.entries, n.entries[idx+1:]...)
+	return c
+}
+
+func (n *hamtNode) each(f func(key, val Sexp)) {
+	for _, e := range n.entries {
+		if e.child != nil {
+			e.child.each(f)
+		} else {
+			f(e.key, e.val)
+		}
+	}
+}
+
+type SexpPMap struct {
+	count int
+	root  *hamtNode
+
+	// pairs caches the (key value) pairs in trie order, for hpair.
+	once  sync.Once
+	pairs []SexpPair
+}
+
+func NewPMap() *SexpPMap {
+	return &SexpPMap{root: newHamtNode(0)}
+}
+
+func pmapHash(key Sexp) (uint32, error) {
+	h, _, err := hashHelper(key)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(h), nil
+}
+
+func (m *SexpPMap) Len() int {
+	return m.count
+}
+
+func (m *SexpPMap) Get(key Sexp) (Sexp, bool, error) {
+	h, err := pmapHash(key)
+	if err != nil {
+		return SexpNull, false, err
+	}
+	val, found := m.root.get(h, 0, key)
+	return val, found, nil
+}
+
+// Assoc returns a new map with key bound to val.
+func (m *SexpPMap) Assoc(key, val Sexp) (*SexpPMap, error) {
+	h, err := pmapHash(key)
+	if err != nil {
+		return nil, err
+	}
+	root, added := m.root.assoc(h, 0, key, val)
+	count := m.count
+	if added {
+		count++
+	}
+	return &SexpPMap{count: count, root: root}, nil
+}
+
+// Dissoc returns a new map without key. If key is absent, m
+// itself is returned.
+func (m *SexpPMap) Dissoc(key Sexp) (*SexpPMap, error) {
+	h, err := pmapHash(key)
+	if err != nil {
+		return nil, err
+	}
+	root, removed := m.root.without(h, 0, key)
+	if !removed {
+		return m, nil
+	}
+	return &SexpPMap{count: m.count - 1, root: root}, nil
+}
+
+// Pairs returns the entries of m as (key value) pairs. The order
+// is fixed for a given map, but it is not insertion order.
+func (m *SexpPMap) Pairs() []SexpPair {
+	m.once.Do(func() {
+		m.pairs = make([]SexpPair, 0, m.count)
+		m.root.each(func(key, val Sexp) {
+			m.pairs = append(m.pairs, SexpPair{Head: key, Tail: val})
+		})
+	})
+	return m.pairs
+}
+
+// pmapPairList returns the entries as two-element lists, the way
+// SeqToArray presents the entries of a hash.
+func pmapPairList(m *SexpPMap) []Sexp {
+	res := make([]Sexp, 0, m.count)
+	for _, p := range m.Pairs() {
+		res = append(res, Cons(p.Head, Cons(p.Tail, SexpNull)))
+	}
+	return res
+}
+
+func (m *SexpPMap) Keys() []Sexp {
+	keys := make([]Sexp, 0, m.count)
+	for _, p := range m.Pairs() {
+		keys = append(keys, p.Head)
+	}
+	return keys
+}
+
+func (m *SexpPMap) SexpString() string {
+	str := "(pmap"
+	for _, p := range m.Pairs() {
+		switch k := p.Head.(type) {
+		case SexpSymbol:
+			str += " " + k.name + ":"
+		default:
+			str += " " + k.SexpString()
+		}
+		str += " " + p.Tail.SexpString()
+	}
+	return str + ")"
+}
+
+func (m *SexpPMap) Type() *RegisteredType {
+	return nil
+}
+
+func comparePVec(a *SexpPVec, b Sexp) (int, error) {
+	bv, isPVec := b.(*SexpPVec)
+	if !isPVec {
+		return 0, fmt.Errorf("cannot compare %T to %T", a, b)
+	}
+	return compareArray(&SexpArray{Val: a.Elems()}, &SexpArray{Val: bv.Elems()})
+}
+
+// pmaps are equal when they hold equal keys bound to equal values;
+// they have no other ordering.
+func comparePMap(a *SexpPMap, b Sexp) (int, error) {
+	bm, isPMap := b.(*SexpPMap)
+	if !isPMap {
+		return 0, fmt.Errorf("cannot compare %T to %T", a, b)
+	}
+	if a.root == bm.root {
+		return 0, nil
+	}
+	if a.count != bm.count {
+		return 1, nil
+	}
+	for _, p := range a.Pairs() {
+		val, found, err := bm.Get(p.Head)
+		if err != nil {
+			return 0, err
+		}
+		if !found {
+			return 1, nil
+		}
+		res, err := Compare(p.Tail, val)
+		if err != nil || res != 0 {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// (pvec a b c ...)
+func PVecFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	return NewPVec(args), nil
+}
+
+// (pmap k1 v1 k2 v2 ...)
+func PMapFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args)%2 != 0 {
+		return SexpNull, errors.New("pmap requires even number of arguments")
+	}
+	m := NewPMap()
+	var err error
+	for i := 0; i < len(args); i += 2 {
+		m, err = m.Assoc(args[i], args[i+1])
+		if err != nil {
+			return SexpNull, err
+		}
+	}
+	return m, nil
+}
+
+func persistentAssoc(coll Sexp, key Sexp, val Sexp) (Sexp, error) {
+	switch c := coll.(type) {
+	case *SexpPVec:
+		i, err := seqIntArg("assoc", key)
+		if err != nil {
+			return SexpNull, err
+		}
+		return c.Assoc(i, val)
+	case *SexpPMap:
+		return c.Assoc(key, val)
+	}
+	return SexpNull, fmt.Errorf("assoc: first argument must be pvec or pmap, "+
+		"but we had %T / val = '%s'", coll, coll.SexpString())
+}
+
+func persistentGet(coll Sexp, key Sexp) (Sexp, bool, error) {
+	switch c := coll.(type) {
+	case *SexpPVec:
+		i, err := seqIntArg("get", key)
+		if err != nil {
+			return SexpNull, false, err
+		}
+		val, found := c.Nth(i)
+		return val, found, nil
+	case *SexpPMap:
+		return c.Get(key)
+	}
+	return SexpNull, false, fmt.Errorf("expected pvec or pmap, "+
+		"but we had %T / val = '%s'", coll, coll.SexpString())
+}
+
+// (assoc coll k v ...) returns coll with each k bound to v.
+// For a pvec, k is an index; an index equal to (len coll) appends.
+func AssocFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return SexpNull, WrongNargs
+	}
+	coll := args[0]
+	var err error
+	for i := 1; i < len(args); i += 2 {
+		coll, err = persistentAssoc(coll, args[i], args[i+1])
+		if err != nil {
+			return SexpNull, err
+		}
+	}
+	return coll, nil
+}
+
+// (dissoc pmap k ...)
+func DissocFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 1 {
+		return SexpNull, WrongNargs
+	}
+	m, isPMap := args[0].(*SexpPMap)
+	if !isPMap {
+		return SexpNull, fmt.Errorf("dissoc: first argument must be pmap, "+
+			"but we had %T / val = '%s'", args[0], args[0].SexpString())
+	}
+	var err error
+	for _, key := range args[1:] {
+		m, err = m.Dissoc(key)
+		if err != nil {
+			return SexpNull, err
+		}
+	}
+	return m, nil
+}
+
+// (conj pvec x ...) appends; (conj pmap pair ...) adds (key value) pairs.
+func ConjFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 1 {
+		return SexpNull, WrongNargs
+	}
+	switch c := args[0].(type) {
+	case *SexpPVec:
+		for _, x := range args[1:] {
+			c = c.Conj(x)
+		}
+		return c, nil
+	case *SexpPMap:
+		var err error
+		for _, x := range args[1:] {
+			var key, val Sexp
+			if pv, isPVec := x.(*SexpPVec); isPVec && pv.Len() == 2 {
+				key, _ = pv.Nth(0)
+				val, _ = pv.Nth(1)
+			} else {
+				key, val, err = seqPairParts(x)
+				if err != nil {
+					return SexpNull, fmt.Errorf("conj: pmap entries must be "+
+						"(key value) pairs, but we had '%s'", x.SexpString())
+				}
+			}
+			c, err = c.Assoc(key, val)
+			if err != nil {
+				return SexpNull, err
+			}
+		}
+		return c, nil
+	}
+	return SexpNull, fmt.Errorf("conj: first argument must be pvec or pmap, "+
+		"but we had %T / val = '%s'", args[0], args[0].SexpString())
+}
+
+// (update-in coll [k1 k2 ...] f args...) replaces the value v found
+// by following the keys with (f v args...). Missing keys along the
+// way get empty pmaps, and f sees nil for a missing value.
+func UpdateInFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 3 {
+		return SexpNull, WrongNargs
+	}
+	path, err := SeqToArray(args[1])
+	if err != nil || len(path) == 0 {
+		return SexpNull, fmt.Errorf("update-in: second argument must be " +
+			"a non-empty sequence of keys")
+	}
+	fun, isFun := args[2].(*SexpFunction)
+	if !isFun {
+		return SexpNull, fmt.Errorf("update-in: third argument must be function, "+
+			"but we had %T / val = '%s'", args[2], args[2].SexpString())
+	}
+	extra := args[3:]
+
+	var update func(coll Sexp, path []Sexp) (Sexp, error)
+	update = func(coll Sexp, path []Sexp) (Sexp, error) {
+		if coll == SexpNull {
+			coll = NewPMap()
+		}
+		old, found, err := persistentGet(coll, path[0])
+		if err != nil {
+			return SexpNull, fmt.Errorf("update-in: %s", err)
+		}
+		if !found {
+			old = SexpNull
+		}
+		var repl Sexp
+		if len(path) == 1 {
+			repl, err = env.Apply(fun, append([]Sexp{old}, extra...))
+		} else {
+			repl, err = update(old, path[1:])
+		}
+		if err != nil {
+			return SexpNull, err
+		}
+		return persistentAssoc(coll, path[0], repl)
+	}
+	return update(args[0], path)
+}
+
+// (snapshot x) returns an immutable version of x. A pvec or pmap
+// already is one, so snapshotting it is O(1) and returns it as is.
+// An array or hash is copied, shallowly, into a new pvec or pmap.
+func SnapshotFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	switch x := args[0].(type) {
+	case *SexpPVec, *SexpPMap:
+		return x, nil
+	case *SexpArray:
+		return NewPVec(x.Val), nil
+	case *SexpHash:
+		pairs, err := SeqToArray(x)
+		if err != nil {
+			return SexpNull, err
+		}
+		return ConjFunction(env, name, append([]Sexp{NewPMap()}, pairs...))
+	}
+	return SexpNull, fmt.Errorf("snapshot: argument must be array, hash, pvec or pmap, "+
+		"but we had %T / val = '%s'", args[0], args[0].SexpString())
+}
+
+// pmapAccessFunction implements hget, keys and hpair for pmaps.
+func pmapAccessFunction(m *SexpPMap, name string, args []Sexp) (Sexp, error) {
+	switch name {
+	case "hget":
+		if len(args) < 2 || len(args) > 3 {
+			return SexpNull, WrongNargs
+		}
+		val, found, err := m.Get(args[1])
+		if err != nil {
+			return SexpNull, err
+		}
+		if !found {
+			if len(args) == 3 {
+				return args[2], nil
+			}
+			return SexpNull, fmt.Errorf("key %s not found", args[1].SexpString())
+		}
+		return val, nil
+	case "keys":
+		if len(args) != 1 {
+			return SexpNull, WrongNargs
+		}
+		return &SexpArray{Val: m.Keys()}, nil
+	case "hpair":
+		if len(args) != 2 {
+			return SexpNull, WrongNargs
+		}
+		posreq, isInt := args[1].(*SexpInt)
+		if !isInt {
+			return SexpNull, fmt.Errorf("hpair position request must be an integer")
+		}
+		pos := int(posreq.Val)
+		pairs := m.Pairs()
+		if pos < 0 || pos >= len(pairs) {
+			return SexpNull, fmt.Errorf("hpair position request %d out of bounds", pos)
+		}
+		return Cons(pairs[pos].Head, Cons(pairs[pos].Tail, SexpNull)), nil
+	}
+	return SexpNull, fmt.Errorf("%s is not supported on pmap", name)
+}
+
+func PersistentFunctions() map[string]GlispUserFunction {
+	return map[string]GlispUserFunction{
+		"pvec":      PVecFunction,
+		"pmap":      PMapFunction,
+		"assoc":     AssocFunction,
+		"dissoc":    DissocFunction,
+		"conj":      ConjFunction,
+		"update-in": UpdateInFunction,
+		"snapshot":  SnapshotFunction,
+	}
+}
@@ -0,0 +1,90 @@
+package zygo
+
+import (
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test031PersistentVectorsShareStructureAcrossManyLevels(t *testing.T) {
+
+	cv.Convey(`Given a pvec grown past several levels of its trie, `+
+		`every element should be reachable, and assoc on a snapshot `+
+		`should not disturb the original.`, t, func() {
+
+		const n = 40000
+		v := NewPVec(nil)
+		for i := 0; i < n; i++ {
+			v = v.Conj(&SexpInt{Val: int64(i)})
+		}
+		cv.So(v.Len(), cv.ShouldEqual, n)
+
+		old := v
+		for i := 0; i < n; i += 97 {
+			var err error
+			v, err = v.Assoc(i, &SexpInt{Val: int64(-i)})
+			panicOn(err)
+		}
+		for i := 0; i < n; i++ {
+			x, found := old.Nth(i)
+			cv.So(found, cv.ShouldBeTrue)
+			cv.So(x.(*SexpInt).Val, cv.ShouldEqual, i)
+
+			y, _ := v.Nth(i)
+			if i%97 == 0 {
+				cv.So(y.(*SexpInt).Val, cv.ShouldEqual, -i)
+			} else {
+				cv.So(y, cv.ShouldEqual, x)
+			}
+		}
+		_, found := v.Nth(n)
+		cv.So(found, cv.ShouldBeFalse)
+	})
+}
+
+func Test032PersistentMapHandlesManyKeysAndFullHashCollisions(t *testing.T) {
+
+	cv.Convey(`Given a pmap with many keys, including pairs whose `+
+		`hashes are identical, Get, Assoc and Dissoc should agree `+
+		`with a plain Go map.`, t, func() {
+
+		const n = 5000
+		m := NewPMap()
+		var err error
+		for i := 0; i < n; i++ {
+			// i and i+2^32 hash to the same 32 bits.
+			m, err = m.Assoc(&SexpInt{Val: int64(i)}, &SexpInt{Val: int64(i)})
+			panicOn(err)
+			m, err = m.Assoc(&SexpInt{Val: int64(i) + 1<<32}, SexpStr{S: "hi"})
+			panicOn(err)
+		}
+		cv.So(m.Len(), cv.ShouldEqual, 2*n)
+		full := m
+
+		for i := 0; i < n; i += 2 {
+			m, err = m.Dissoc(&SexpInt{Val: int64(i)})
+			panicOn(err)
+		}
+		cv.So(m.Len(), cv.ShouldEqual, 2*n-n/2)
+		cv.So(len(m.Pairs()), cv.ShouldEqual, m.Len())
+
+		for i := 0; i < n; i++ {
+			val, found, err := m.Get(&SexpInt{Val: int64(i)})
+			panicOn(err)
+			cv.So(found, cv.ShouldEqual, i%2 == 1)
+			if found {
+				cv.So(val.(*SexpInt).Val, cv.ShouldEqual, i)
+			}
+			val, found, _ = m.Get(&SexpInt{Val: int64(i) + 1<<32})
+			cv.So(found, cv.ShouldBeTrue)
+			cv.So(val.(SexpStr).S, cv.ShouldEqual, "hi")
+
+			_, found, _ = full.Get(&SexpInt{Val: int64(i)})
+			cv.So(found, cv.ShouldBeTrue)
+		}
+
+		res, err := Compare(full, m)
+		panicOn(err)
+		cv.So(res, cv.ShouldNotEqual, 0)
+	})
+}
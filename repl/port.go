@@ -0,0 +1,355 @@
+package zygo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// SexpPort is a stream to read from or write to: an open file, or
+// one of the predefined *stdin*, *stdout* and *stderr*. Ports
+// satisfy io.Reader and io.Writer, so Go code can hand them to
+// anything that takes those.
+type SexpPort struct {
+	Name   string
+	Reader *bufio.Reader
+	Writer io.Writer
+	Closer io.Closer
+
+	buf    *bufio.Writer
+	closed bool
+}
+
+// NewReadPort returns a port reading from r. If c is not nil, it
+// is closed when the port is.
+func NewReadPort(name string, r io.Reader, c io.Closer) *SexpPort {
+	return &SexpPort{Name: name, Reader: bufio.NewReader(r), Closer: c}
+}
+
+// NewWritePort returns a port writing straight through to w.
+func NewWritePort(name string, w io.Writer, c io.Closer) *SexpPort {
+	return &SexpPort{Name: name, Writer: w, Closer: c}
+}
+
+// NewBufferedWritePort returns a port whose writes to w are
+// buffered until a flush or close.
+func NewBufferedWritePort(name string, w io.Writer, c io.Closer) *SexpPort {
+	buf := bufio.NewWriter(w)
+	return &SexpPort{Name: name, Writer: buf, Closer: c, buf: buf}
+}
+
+func (p *SexpPort) SexpString() string {
+	return fmt.Sprintf("(port %q)", p.Name)
+}
+
+func (p *SexpPort) Type() *RegisteredType {
+	return nil
+}
+
+func (p *SexpPort) Read(b []byte) (int, error) {
+	if p.closed {
+		return 0, fmt.Errorf("port '%s' is closed", p.Name)
+	}
+	if p.Reader == nil {
+		return 0, fmt.Errorf("port '%s' is not open for reading", p.Name)
+	}
+	return p.Reader.Read(b)
+}
+
+func (p *SexpPort) Write(b []byte) (int, error) {
+	if p.closed {
+		return 0, fmt.Errorf("port '%s' is closed", p.Name)
+	}
+	if p.Writer == nil {
+		return 0, fmt.Errorf("port '%s' is not open for writing", p.Name)
+	}
+	return p.Writer.Write(b)
+}
+
+func (p *SexpPort) Flush() error {
+	if p.buf != nil && !p.closed {
+		return p.buf.Flush()
+	}
+	return nil
+}
+
+// Close flushes the port and closes what it wraps. Ports with
+// nothing to close, such as *stdout*, stay usable.
+func (p *SexpPort) Close() error {
+	if p.closed {
+		return nil
+	}
+	err := p.Flush()
+	if p.Closer == nil {
+		return err
+	}
+	p.closed = true
+	cerr := p.Closer.Close()
+	if err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (env *Glisp) ImportPorts() {
+	env.AddGlobal("*stdin*", NewReadPort("*stdin*", os.Stdin, nil))
+	env.AddGlobal("*stdout*", NewWritePort("*stdout*", os.Stdout, nil))
+	env.AddGlobal("*stderr*", NewWritePort("*stderr*", os.Stderr, nil))
+}
+
+// StdoutPort returns the port bound to *stdout* where the program
+// currently is, falling back to os.Stdout. Rebinding *stdout*,
+// from Go with AddGlobal or from zygo with def or let, redirects
+// print and friends.
+func (env *Glisp) StdoutPort() *SexpPort {
+	return env.boundPort("*stdout*", os.Stdout)
+}
+
+// StderrPort is StdoutPort for *stderr*.
+func (env *Glisp) StderrPort() *SexpPort {
+	return env.boundPort("*stderr*", os.Stderr)
+}
+
+func (env *Glisp) boundPort(name string, fallback *os.File) *SexpPort {
+	if obj, found := env.FindObject(name); found {
+		if p, isPort := obj.(*SexpPort); isPort {
+			return p
+		}
+	}
+	return NewWritePort(name, fallback, nil)
+}
+
+// portArg accepts a port, or a decoder or encoder standing for
+// the port it wraps, so that flush, close and with-open work on
+// those too.
+func portArg(name string, x Sexp) (*SexpPort, error) {
+	switch p := x.(type) {
+	case *SexpPort:
+		return p, nil
+	case *SexpDecoder:
+		return p.Port, nil
+	case *SexpEncoder:
+		return p.Port, nil
+	}
+	return nil, fmt.Errorf("%s: argument must be a port, but we had %T / val = '%s'",
+		name, x, x.SexpString())
+}
+
+// (open path [mode]) opens a file; mode is "r" (the default) to
+// read, "w" to create or truncate and write, or "a" to append.
+func OpenFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return SexpNull, WrongNargs
+	}
+	path, isStr := args[0].(SexpStr)
+	if !isStr {
+		return SexpNull, fmt.Errorf("%s: path must be a string", name)
+	}
+	mode := "r"
+	if len(args) == 2 {
+		m, isStr := args[1].(SexpStr)
+		if !isStr {
+			return SexpNull, fmt.Errorf("%s: mode must be a string", name)
+		}
+		mode = m.S
+	}
+
+	switch mode {
+	case "r":
+		f, err := os.Open(path.S)
+		if err != nil {
+			return SexpNull, err
+		}
+		return NewReadPort(path.S, f, f), nil
+	case "w", "a":
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if mode == "a" {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(path.S, flags, 0666)
+		if err != nil {
+			return SexpNull, err
+		}
+		return NewBufferedWritePort(path.S, f, f), nil
+	}
+	return SexpNull, fmt.Errorf("%s: unknown mode '%s'; use \"r\", \"w\" or \"a\"", name, mode)
+}
+
+// (read-line port) returns the next line without its newline, or
+// nil at end of input. (read-bytes port n) returns up to n bytes
+// as raw, or nil at end of input. (read-all port) returns
+// everything that is left as a string.
+func PortReadFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 1 {
+		return SexpNull, WrongNargs
+	}
+	p, err := portArg(name, args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	if p.closed {
+		return SexpNull, fmt.Errorf("%s: port '%s' is closed", name, p.Name)
+	}
+	if p.Reader == nil {
+		return SexpNull, fmt.Errorf("%s: port '%s' is not open for reading", name, p.Name)
+	}
+
+	switch name {
+	case "read-line":
+		if len(args) != 1 {
+			return SexpNull, WrongNargs
+		}
+		line, err := p.Reader.ReadString('\n')
+		if err == io.EOF {
+			if line == "" {
+				return SexpNull, nil
+			}
+		} else if err != nil {
+			return SexpNull, err
+		}
+		if n := len(line); n > 0 && line[n-1] == '\n' {
+			line = line[:n-1]
+			if n > 1 && line[n-2] == '\r' {
+				line = line[:n-2]
+			}
+		}
+		return SexpStr{S: line}, nil
+
+	case "read-bytes":
+		if len(args) != 2 {
+			return SexpNull, WrongNargs
+		}
+		n, isInt := args[1].(*SexpInt)
+		if !isInt || n.Val < 0 {
+			return SexpNull, fmt.Errorf("%s: count must be a non-negative integer", name)
+		}
+		b := make([]byte, n.Val)
+		k, err := io.ReadFull(p.Reader, b)
+		if k == 0 && err == io.EOF {
+			return SexpNull, nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return SexpNull, err
+		}
+		return SexpRaw{Val: b[:k]}, nil
+
+	case "read-all":
+		if len(args) != 1 {
+			return SexpNull, WrongNargs
+		}
+		b, err := ioutil.ReadAll(p.Reader)
+		if err != nil {
+			return SexpNull, err
+		}
+		return SexpStr{S: string(b)}, nil
+	}
+	return SexpNull, fmt.Errorf("unknown function %s", name)
+}
+
+// writeSexp writes strings and chars as their contents, raw bytes
+// as they are, and anything else as its SexpString().
+func writeSexp(w io.Writer, x Sexp) error {
+	var err error
+	switch t := x.(type) {
+	case SexpStr:
+		_, err = io.WriteString(w, t.S)
+	case SexpChar:
+		_, err = io.WriteString(w, string(t.Val))
+	case SexpRaw:
+		_, err = w.Write(t.Val)
+	default:
+		_, err = io.WriteString(w, x.SexpString())
+	}
+	return err
+}
+
+// (write port x...) writes each x to the port. For compatibility,
+// (write array path) still writes an array of lines to a new
+// file, as writef does. The write macro calls this as __write.
+func WriteFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 1 {
+		return SexpNull, WrongNargs
+	}
+	p, isPort := args[0].(*SexpPort)
+	if !isPort {
+		return WriteToFileFunction(env, "writef", args)
+	}
+	for _, x := range args[1:] {
+		if err := writeSexp(p, x); err != nil {
+			return SexpNull, err
+		}
+	}
+	return SexpNull, nil
+}
+
+// (__write-sym dest 'sym thunk) is what the write macro makes of
+// (write dest sym). If dest is a port, the value of sym, got by
+// calling thunk, is written to it. Otherwise dest is an array
+// and, as write has always done, sym names the file.
+func WriteSymFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 3 {
+		return SexpNull, WrongNargs
+	}
+	sym, isSym := args[1].(SexpSymbol)
+	thunk, isFun := args[2].(*SexpFunction)
+	if !isSym || !isFun {
+		return SexpNull, fmt.Errorf("%s: needs a symbol and a function", name)
+	}
+	p, isPort := args[0].(*SexpPort)
+	if !isPort {
+		return WriteToFileFunction(env, "writef", []Sexp{args[0], SexpStr{S: sym.name}})
+	}
+	x, err := env.Apply(thunk, nil)
+	if err != nil {
+		return SexpNull, err
+	}
+	return SexpNull, writeSexp(p, x)
+}
+
+// (flush port) and (close port).
+func PortFlushCloseFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	p, err := portArg(name, args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	if name == "flush" {
+		err = p.Flush()
+	} else {
+		err = p.Close()
+	}
+	if err != nil {
+		return SexpNull, err
+	}
+	return SexpNull, nil
+}
+
+// (__with-open port fun) calls fun with port, then closes port
+// whether or not fun returned an error. It is the body of the
+// with-open macro: (with-open [f (open path)] body...).
+func WithOpenFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	p, err := portArg(name, args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	fun, isFun := args[1].(*SexpFunction)
+	if !isFun {
+		return SexpNull, fmt.Errorf("%s: second argument must be a function", name)
+	}
+	res, err := env.Apply(fun, []Sexp{args[0]})
+	cerr := p.Close()
+	if err != nil {
+		return SexpNull, err
+	}
+	if cerr != nil {
+		return SexpNull, cerr
+	}
+	return res, nil
+}
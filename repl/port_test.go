@@ -0,0 +1,28 @@
+package zygo
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test036StdPortsCanBeRedirectedFromGo(t *testing.T) {
+
+	cv.Convey(`Given *stdin* and *stdout* rebound to Go buffers,`+
+		` read-line and println should use them.`, t, func() {
+
+		env := NewGlisp()
+		defer env.parser.Stop()
+		env.StandardSetup()
+
+		var out bytes.Buffer
+		env.AddGlobal("*stdin*", NewReadPort("in", strings.NewReader("ping\n"), nil))
+		env.AddGlobal("*stdout*", NewWritePort("out", &out, nil))
+
+		_, err := env.EvalString(`(println (concat (read-line *stdin*) " pong"))`)
+		panicOn(err)
+		cv.So(out.String(), cv.ShouldEqual, "ping pong\n")
+	})
+}
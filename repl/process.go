@@ -0,0 +1,392 @@
+package zygo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Unlike system, exec, pipeline and spawn run programs directly
+// from an argv array, with no shell in between, so arguments need
+// no quoting. Stdout and stderr are kept apart, and a non-zero
+// exit is reported in the result rather than raised. A program
+// that cannot be started at all gives back an error value, as
+// the filesystem builtins do.
+
+type execOptions struct {
+	env     []string
+	cwd     string
+	stdin   io.Reader
+	timeout time.Duration
+}
+
+// parseExecOptions reads the options hash: env (a hash of
+// variables to add to the environment), cwd, stdin (a string,
+// raw bytes or a port) and timeout (in seconds).
+func parseExecOptions(env *Glisp, name string, x Sexp) (*execOptions, error) {
+	opts := &execOptions{}
+	if x == nil {
+		return opts, nil
+	}
+	hash, isHash := x.(*SexpHash)
+	if !isHash {
+		return nil, fmt.Errorf("%s: options must be a hash, but we had %T", name, x)
+	}
+	get := func(key string) Sexp {
+		val, err := hash.HashGet(env, env.MakeSymbol(key))
+		if err != nil {
+			return nil
+		}
+		return val
+	}
+
+	if v := get("env"); v != nil {
+		vars, isHash := v.(*SexpHash)
+		if !isHash {
+			return nil, fmt.Errorf("%s: env option must be a hash of names to values", name)
+		}
+		opts.env = os.Environ()
+		for _, key := range vars.KeyOrder {
+			val, err := vars.HashGet(env, key)
+			if err != nil {
+				return nil, err
+			}
+			k, err := envVarString(key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: env option name %v", name, err)
+			}
+			v, err := envVarString(val)
+			if err != nil {
+				return nil, fmt.Errorf("%s: env option %s value %v", name, k, err)
+			}
+			opts.env = append(opts.env, k+"="+v)
+		}
+	}
+	if v := get("cwd"); v != nil {
+		s, isStr := v.(SexpStr)
+		if !isStr {
+			return nil, fmt.Errorf("%s: cwd option must be a string", name)
+		}
+		opts.cwd = s.S
+	}
+	if v := get("stdin"); v != nil {
+		switch in := v.(type) {
+		case SexpStr:
+			opts.stdin = strings.NewReader(in.S)
+		case SexpRaw:
+			opts.stdin = bytes.NewReader(in.Val)
+		case *SexpPort:
+			opts.stdin = in
+		default:
+			return nil, fmt.Errorf("%s: stdin option must be a string, raw or port", name)
+		}
+	}
+	if v := get("timeout"); v != nil {
+		switch t := v.(type) {
+		case *SexpInt:
+			opts.timeout = time.Duration(t.Val) * time.Second
+		case SexpFloat:
+			opts.timeout = time.Duration(t.Val * float64(time.Second))
+		default:
+			return nil, fmt.Errorf("%s: timeout option must be a number of seconds", name)
+		}
+	}
+	return opts, nil
+}
+
+// envVarString gives the text of a name or value of the env option.
+// Only scalars make sense in an environment variable.
+func envVarString(x Sexp) (string, error) {
+	switch v := x.(type) {
+	case SexpSymbol:
+		return v.name, nil
+	case SexpStr:
+		return v.S, nil
+	case SexpChar:
+		return string(v.Val), nil
+	case *SexpInt:
+		return strconv.FormatInt(v.Val, 10), nil
+	case SexpFloat:
+		return strconv.FormatFloat(v.Val, 'g', -1, 64), nil
+	case SexpBool:
+		return strconv.FormatBool(v.Val), nil
+	}
+	return "", fmt.Errorf("must be a string, symbol or number, not '%s'", x.SexpString())
+}
+
+func argvArg(name string, x Sexp) ([]string, error) {
+	arr, isArr := x.(*SexpArray)
+	if !isArr || len(arr.Val) == 0 {
+		return nil, fmt.Errorf("%s: command must be a non-empty array of strings", name)
+	}
+	argv := make([]string, len(arr.Val))
+	for i, a := range arr.Val {
+		switch s := a.(type) {
+		case SexpStr:
+			argv[i] = s.S
+		case SexpSymbol:
+			argv[i] = s.name
+		default:
+			argv[i] = a.SexpString()
+		}
+	}
+	return argv, nil
+}
+
+// procRun is one or more commands started together, each feeding
+// its stdout to the next.
+type procRun struct {
+	argvs   [][]string
+	cmds    []*exec.Cmd
+	stdout  bytes.Buffer
+	stderrs []bytes.Buffer
+	start   time.Time
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func startRun(argvs [][]string, opts *execOptions) (*procRun, error) {
+	r := &procRun{argvs: argvs, stderrs: make([]bytes.Buffer, len(argvs))}
+	if opts.timeout > 0 {
+		r.ctx, r.cancel = context.WithTimeout(context.Background(), opts.timeout)
+	} else {
+		r.ctx, r.cancel = context.WithCancel(context.Background())
+	}
+
+	for i, argv := range argvs {
+		cmd := exec.CommandContext(r.ctx, argv[0], argv[1:]...)
+		cmd.Env = opts.env
+		cmd.Dir = opts.cwd
+		cmd.Stderr = &r.stderrs[i]
+		if i == 0 {
+			cmd.Stdin = opts.stdin
+		} else {
+			pipe, err := r.cmds[i-1].StdoutPipe()
+			if err != nil {
+				r.cancel()
+				return nil, err
+			}
+			cmd.Stdin = pipe
+		}
+		r.cmds = append(r.cmds, cmd)
+	}
+	r.cmds[len(r.cmds)-1].Stdout = &r.stdout
+
+	r.start = time.Now()
+	for i, cmd := range r.cmds {
+		if err := cmd.Start(); err != nil {
+			for _, started := range r.cmds[:i] {
+				started.Process.Kill()
+				started.Wait()
+			}
+			r.cancel()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// wait waits for every command and describes the run as an
+// (exec-result stdout: stderr: exit-code: duration: timed-out:)
+// record. For a pipeline, exit-code is that of the last command,
+// exit-codes has all of them, and stderr is everyone's combined.
+func (r *procRun) wait(env *Glisp) (Sexp, error) {
+	defer r.cancel()
+	codes := make([]Sexp, len(r.cmds))
+	var last int
+	var waitErr error
+	for i, cmd := range r.cmds {
+		// wait on every command, even after one fails, so none of
+		// them is left behind as a zombie.
+		err := cmd.Wait()
+		last = 0
+		if err != nil {
+			exitErr, isExit := err.(*exec.ExitError)
+			if !isExit {
+				if waitErr == nil {
+					waitErr = err
+				}
+				last = -1
+			} else {
+				last = exitErr.ExitCode()
+			}
+		}
+		codes[i] = &SexpInt{Val: int64(last)}
+	}
+	if waitErr != nil {
+		return SexpError{waitErr}, nil
+	}
+	duration := time.Since(r.start)
+
+	var stderr bytes.Buffer
+	for i := range r.stderrs {
+		stderr.Write(r.stderrs[i].Bytes())
+	}
+	fields := []Sexp{
+		env.MakeSymbol("stdout"), SexpStr{S: r.stdout.String()},
+		env.MakeSymbol("stderr"), SexpStr{S: stderr.String()},
+		env.MakeSymbol("exit-code"), &SexpInt{Val: int64(last)},
+		env.MakeSymbol("duration"), SexpFloat{Val: duration.Seconds()},
+		env.MakeSymbol("timed-out"), SexpBool{Val: r.ctx.Err() == context.DeadlineExceeded},
+	}
+	if len(r.cmds) > 1 {
+		fields = append(fields, env.MakeSymbol("exit-codes"), &SexpArray{Val: codes})
+	}
+	return MakeHash(fields, "exec-result", env)
+}
+
+// execArgs sorts out (exec argv [opts]) from (exec cmd [args] [opts]).
+func execArgs(env *Glisp, name string, args []Sexp) ([]string, *execOptions, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return nil, nil, WrongNargs
+	}
+	var argv []string
+	if cmd, isStr := args[0].(SexpStr); isStr {
+		argv = []string{cmd.S}
+		if len(args) > 1 {
+			if _, isArr := args[1].(*SexpArray); isArr {
+				rest, err := argvArg(name, args[1])
+				if err != nil {
+					return nil, nil, err
+				}
+				argv = append(argv, rest...)
+				args = args[1:]
+			}
+		}
+	} else {
+		var err error
+		argv, err = argvArg(name, args[0])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(args) > 2 {
+		return nil, nil, WrongNargs
+	}
+	var optArg Sexp
+	if len(args) == 2 {
+		optArg = args[1]
+	}
+	opts, err := parseExecOptions(env, name, optArg)
+	return argv, opts, err
+}
+
+// (exec ["prog" "arg" ...] [opts]), or (exec "prog" ["arg" ...] [opts]),
+// runs a program to completion and returns an exec-result record.
+func ExecFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	argv, opts, err := execArgs(env, name, args)
+	if err != nil {
+		return SexpNull, err
+	}
+	r, err := startRun([][]string{argv}, opts)
+	if err != nil {
+		return SexpError{err}, nil
+	}
+	return r.wait(env)
+}
+
+// (pipeline [argv1 argv2 ...] [opts]) runs the commands with each
+// one's stdout connected to the next one's stdin.
+func PipelineFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return SexpNull, WrongNargs
+	}
+	arr, isArr := args[0].(*SexpArray)
+	if !isArr || len(arr.Val) == 0 {
+		return SexpNull, fmt.Errorf("%s: expected a non-empty array of commands", name)
+	}
+	argvs := make([][]string, len(arr.Val))
+	for i, c := range arr.Val {
+		argv, err := argvArg(name, c)
+		if err != nil {
+			return SexpNull, err
+		}
+		argvs[i] = argv
+	}
+	var optArg Sexp
+	if len(args) == 2 {
+		optArg = args[1]
+	}
+	opts, err := parseExecOptions(env, name, optArg)
+	if err != nil {
+		return SexpNull, err
+	}
+	r, err := startRun(argvs, opts)
+	if err != nil {
+		return SexpError{err}, nil
+	}
+	return r.wait(env)
+}
+
+// SexpProcess is a program started in the background by spawn.
+type SexpProcess struct {
+	run *procRun
+
+	once   sync.Once
+	result Sexp
+	err    error
+}
+
+func (p *SexpProcess) SexpString() string {
+	return fmt.Sprintf("(process %d %q)", p.run.cmds[0].Process.Pid,
+		strings.Join(p.run.argvs[0], " "))
+}
+
+func (p *SexpProcess) Type() *RegisteredType {
+	return nil
+}
+
+func (p *SexpProcess) Wait(env *Glisp) (Sexp, error) {
+	p.once.Do(func() {
+		p.result, p.err = p.run.wait(env)
+	})
+	return p.result, p.err
+}
+
+// (spawn argv [opts]) starts a program, taking the same arguments as
+// exec, and returns a process handle without waiting for it.
+func SpawnFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	argv, opts, err := execArgs(env, name, args)
+	if err != nil {
+		return SexpNull, err
+	}
+	r, err := startRun([][]string{argv}, opts)
+	if err != nil {
+		return SexpError{err}, nil
+	}
+	return &SexpProcess{run: r}, nil
+}
+
+// (wait proc) waits for a spawned process and returns its
+// exec-result; waiting again returns the same result.
+// (kill proc) stops it. (pid proc) returns its process id.
+func ProcessFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	p, isProc := args[0].(*SexpProcess)
+	if !isProc {
+		return SexpNull, fmt.Errorf("%s: argument must be a process from spawn, "+
+			"but we had %T / val = '%s'", name, args[0], args[0].SexpString())
+	}
+	switch name {
+	case "wait":
+		return p.Wait(env)
+	case "kill":
+		err := p.run.cmds[0].Process.Kill()
+		if err != nil && err != os.ErrProcessDone {
+			return SexpError{err}, nil
+		}
+		return SexpNull, nil
+	case "pid":
+		return &SexpInt{Val: int64(p.run.cmds[0].Process.Pid)}, nil
+	}
+	return SexpNull, fmt.Errorf("unknown function %s", name)
+}
@@ -0,0 +1,34 @@
+package zygo
+
+import (
+	"errors"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("stdin went away")
+}
+
+func Test043PipelineWaitsOnEveryCommandAfterAnError(t *testing.T) {
+
+	cv.Convey(`Given a pipeline whose first command's stdin fails,`+
+		` wait should return that error only after every command has exited.`, t, func() {
+
+		env := NewGlisp()
+		defer env.parser.Stop()
+
+		r, err := startRun([][]string{{"cat"}, {"sh", "-c", "cat; sleep 0.2"}},
+			&execOptions{stdin: failingReader{}})
+		panicOn(err)
+		res, err := r.wait(env)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(res.SexpString(), cv.ShouldContainSubstring, "stdin went away")
+		for _, cmd := range r.cmds {
+			cv.So(cmd.ProcessState, cv.ShouldNotBeNil)
+		}
+	})
+}
@@ -4,13 +4,16 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"strings"
 )
 
 type SexpRegexp regexp.Regexp
 
+// SexpString prints a regexp in its #"..." literal form, which
+// reads back as the same compiled expression.
 func (re *SexpRegexp) SexpString() string {
 	r := (*regexp.Regexp)(re)
-	return fmt.Sprintf(`(regexp-compile "%v")`, r.String())
+	return `#"` + strings.Replace(r.String(), `"`, `\"`, -1) + `"`
 }
 
 func (r *SexpRegexp) Type() *RegisteredType {
@@ -35,22 +38,9 @@ func RegexpFind(env *Glisp, name string,
 	if len(args) != 2 {
 		return SexpNull, WrongNargs
 	}
-	var haystack string
-	switch t := args[1].(type) {
-	case SexpStr:
-		haystack = t.S
-	default:
-		return SexpNull,
-			errors.New(fmt.Sprintf("2nd argument of %v should be a string", name))
-	}
-
-	var needle *regexp.Regexp
-	switch t := args[0].(type) {
-	case *SexpRegexp:
-		needle = (*regexp.Regexp)(t)
-	default:
-		return SexpNull,
-			errors.New(fmt.Sprintf("1st argument of %v should be a compiled regular expression", name))
+	needle, haystack, err := regexpArgs(name, args)
+	if err != nil {
+		return SexpNull, err
 	}
 
 	switch name {
@@ -92,9 +82,168 @@ func RegexpCompile(env *Glisp, name string,
 	return Sexp((*SexpRegexp)(r)), nil
 }
 
+// regexpArgs unpacks the (re haystack ...) arguments shared by the
+// functions below. A plain string is accepted in place of a
+// compiled regexp, and is compiled on each call.
+func regexpArgs(name string, args []Sexp) (*regexp.Regexp, string, error) {
+	var needle *regexp.Regexp
+	switch t := args[0].(type) {
+	case *SexpRegexp:
+		needle = (*regexp.Regexp)(t)
+	case SexpStr:
+		r, err := regexp.Compile(t.S)
+		if err != nil {
+			return nil, "", fmt.Errorf("error during %s: '%v'", name, err)
+		}
+		needle = r
+	default:
+		return nil, "", fmt.Errorf("1st argument of %v should be a compiled regular expression", name)
+	}
+	haystack, isStr := args[1].(SexpStr)
+	if !isStr {
+		return nil, "", fmt.Errorf("2nd argument of %v should be a string", name)
+	}
+	return needle, haystack.S, nil
+}
+
+// regexpLimit reads the optional count argument of find-all
+// and split; -1, the default, means no limit.
+func regexpLimit(name string, args []Sexp, i int) (int, error) {
+	if len(args) <= i {
+		return -1, nil
+	}
+	n, isInt := args[i].(*SexpInt)
+	if !isInt {
+		return 0, fmt.Errorf("limit argument of %v should be an integer", name)
+	}
+	return int(n.Val), nil
+}
+
+func stringsToArray(strs []string) *SexpArray {
+	arr := make([]Sexp, len(strs))
+	for i, s := range strs {
+		arr[i] = SexpStr{S: s}
+	}
+	return &SexpArray{Val: arr}
+}
+
+// (regexp-find-all re haystack [n]) returns an array of up to n
+// successive matches, or of all of them.
+// (regexp-split re haystack [n]) returns the pieces between matches.
+func RegexpFindAllFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return SexpNull, WrongNargs
+	}
+	needle, haystack, err := regexpArgs(name, args)
+	if err != nil {
+		return SexpNull, err
+	}
+	n, err := regexpLimit(name, args, 2)
+	if err != nil {
+		return SexpNull, err
+	}
+	if name == "regexp-split" {
+		return stringsToArray(needle.Split(haystack, n)), nil
+	}
+	return stringsToArray(needle.FindAllString(haystack, n)), nil
+}
+
+// (regexp-submatch re haystack) returns the first match and its
+// captures. With no named groups this is an array: the whole match
+// followed by each group. When the regexp names its groups, as in
+// (?P<year>\d+), the result is instead a hash from each name, as a
+// symbol, to its capture. Returns nil if there is no match.
+func RegexpSubmatchFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	needle, haystack, err := regexpArgs(name, args)
+	if err != nil {
+		return SexpNull, err
+	}
+	caps := needle.FindStringSubmatch(haystack)
+	if caps == nil {
+		return SexpNull, nil
+	}
+
+	named := false
+	for _, n := range needle.SubexpNames() {
+		if n != "" {
+			named = true
+			break
+		}
+	}
+	if !named {
+		return stringsToArray(caps), nil
+	}
+
+	hash, err := MakeHash(nil, "hash", env)
+	if err != nil {
+		return SexpNull, err
+	}
+	for i, n := range needle.SubexpNames() {
+		if n == "" {
+			continue
+		}
+		err = hash.HashSet(env.MakeSymbol(n), SexpStr{S: caps[i]})
+		if err != nil {
+			return SexpNull, err
+		}
+	}
+	return hash, nil
+}
+
+// (regexp-replace re haystack repl) replaces every match. A string
+// repl may refer to captures as $1 or ${name}; a function repl is
+// called with each matched string and must return a string.
+func RegexpReplaceFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 3 {
+		return SexpNull, WrongNargs
+	}
+	needle, haystack, err := regexpArgs(name, args)
+	if err != nil {
+		return SexpNull, err
+	}
+
+	switch repl := args[2].(type) {
+	case SexpStr:
+		return SexpStr{S: needle.ReplaceAllString(haystack, repl.S)}, nil
+	case *SexpFunction:
+		var firstErr error
+		res := needle.ReplaceAllStringFunc(haystack, func(m string) string {
+			if firstErr != nil {
+				return m
+			}
+			out, err := env.Apply(repl, []Sexp{SexpStr{S: m}})
+			if err != nil {
+				firstErr = err
+				return m
+			}
+			switch o := out.(type) {
+			case SexpStr:
+				return o.S
+			case SexpChar:
+				return string(o.Val)
+			}
+			firstErr = fmt.Errorf("%s: replacement function must return a string, "+
+				"but returned %T / val = '%s'", name, out, out.SexpString())
+			return m
+		})
+		if firstErr != nil {
+			return SexpNull, firstErr
+		}
+		return SexpStr{S: res}, nil
+	}
+	return SexpNull, fmt.Errorf("3rd argument of %v should be a string or function", name)
+}
+
 func (env *Glisp) ImportRegex() {
 	env.AddFunction("regexp-compile", RegexpCompile)
 	env.AddFunction("regexp-find-index", RegexpFind)
 	env.AddFunction("regexp-find", RegexpFind)
 	env.AddFunction("regexp-match", RegexpFind)
+	env.AddFunction("regexp-find-all", RegexpFindAllFunction)
+	env.AddFunction("regexp-split", RegexpFindAllFunction)
+	env.AddFunction("regexp-submatch", RegexpSubmatchFunction)
+	env.AddFunction("regexp-replace", RegexpReplaceFunction)
 }
@@ -3,7 +3,6 @@ package zygo
 import (
 	"bufio"
 	"bytes"
-	"encoding/gob"
 	"fmt"
 	"io"
 	"os"
@@ -277,24 +276,78 @@ func Repl(env *Glisp, cfg *GlispConfig) {
 	}
 }
 
+// Exit codes for scripts run by zygo. A script can also choose
+// its own with (exit n), or by returning an int from main.
+const (
+	ExitCodeScriptError = 1
+	ExitCodeLoadError   = 2
+)
+
+// SetArgv binds *argv* to args, the command line arguments
+// that follow the script name.
+func (env *Glisp) SetArgv(args []string) {
+	env.AddGlobal("*argv*", stringsToArray(args))
+}
+
+// RunMain calls the script's main function, if it defined one,
+// with *argv* when main takes an argument. The result is the exit
+// code main asked for: its return value if that was an int, or 0.
+func (env *Glisp) RunMain() (ran bool, code int, err error) {
+	obj, found := env.FindObject("main")
+	if !found {
+		return false, 0, nil
+	}
+	mainf, isFunc := obj.(*SexpFunction)
+	if !isFunc {
+		return false, 0, nil
+	}
+	var args []Sexp
+	if mainf.nargs > 0 || mainf.varargs {
+		argv, _ := env.FindObject("*argv*")
+		args = []Sexp{argv}
+	}
+	res, err := env.Apply(mainf, args)
+	if err != nil {
+		return true, ExitCodeScriptError, err
+	}
+	if n, isInt := res.(*SexpInt); isInt {
+		return true, int(n.Val), nil
+	}
+	return true, 0, nil
+}
+
+// stdinIsTerminal guesses whether someone is typing at us, as
+// opposed to stdin being a pipe, a file or /dev/null.
+func stdinIsTerminal() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(fi, null) {
+		return false
+	}
+	return true
+}
+
 func runScript(env *Glisp, fname string, cfg *GlispConfig) {
 	file, err := os.Open(fname)
 	if err != nil {
 		fmt.Println(err)
-		return
+		os.Exit(ExitCodeLoadError)
 	}
 	defer file.Close()
 
 	err = env.LoadFile(file)
 	if err != nil {
 		fmt.Println(err)
-		if cfg.ExitOnFailure {
-			os.Exit(-1)
-		}
-		return
+		os.Exit(ExitCodeLoadError)
 	}
 
+	code := 0
 	_, err = env.Run()
+	if err == nil {
+		_, code, err = env.RunMain()
+	}
 	if cfg.CountFuncCalls {
 		fmt.Println("Pre:")
 		for name, count := range precounts {
@@ -307,10 +360,16 @@ func runScript(env *Glisp, fname string, cfg *GlispConfig) {
 	}
 	if err != nil {
 		fmt.Print(env.GetStackTrace(err))
-		if cfg.ExitOnFailure {
-			os.Exit(-1)
+		// only drop into the repl to debug when someone is
+		// there to use it.
+		if cfg.ExitOnFailure || !stdinIsTerminal() {
+			os.Exit(ExitCodeScriptError)
 		}
 		Repl(env, cfg)
+		return
+	}
+	if code != 0 {
+		os.Exit(code)
 	}
 }
 
@@ -320,6 +379,7 @@ func (env *Glisp) StandardSetup() {
 	env.ImportTime()
 	env.ImportPackageBuilder()
 	env.ImportMsgpackMap()
+	env.ImportPackages()
 
 	defmap := `(defmac defmap [name] ^(defn ~name [& rest] (msgmap (quote ~name) rest)))`
 	_, err := env.EvalString(defmap)
@@ -329,12 +389,22 @@ func (env *Glisp) StandardSetup() {
 	_, err = env.EvalString(colonOp)
 	panicOn(err)
 
-	rangeMacro := `(defmac range [key value my-hash & body]
-  ^(let [n (len ~my-hash)]
-      (for [(def i 0) (< i n) (def i (+ i 1))]
-        (begin
-          (mdef (quote ~key) (quote ~value) (hpair ~my-hash i))
-          ~@body))))`
+	// (range key value my-hash body...) loops over a hash or array;
+	// (range end), (range start end) and (range start end step) make
+	// an array of integers. The loop is told apart by its body, so a
+	// loop needs at least one body form.
+	rangeMacro := `(defmac range [& args]
+  (cond (< (len args) 4)
+    ^(__range ~@args)
+    (let [key (first args)
+          value (second args)
+          my-hash (first (rest (rest args)))
+          body (rest (rest (rest args)))]
+      ^(let [n (len ~my-hash)]
+         (for [(def i 0) (< i n) (def i (+ i 1))]
+           (begin
+             (mdef (quote ~key) (quote ~value) (hpair ~my-hash i))
+             ~@body))))))`
 	_, err = env.EvalString(rangeMacro)
 	panicOn(err)
 
@@ -342,6 +412,20 @@ func (env *Glisp) StandardSetup() {
 	_, err = env.EvalString(reqMacro)
 	panicOn(err)
 
+	// (ns name), (export name...) and (import name [:as alias]);
+	// see module.go.
+	nsMacro := `(defmac ns [name] ^(__ns (quote ~name)))`
+	_, err = env.EvalString(nsMacro)
+	panicOn(err)
+
+	exportMacro := `(defmac export [& names] ^(__export (quote ~names)))`
+	_, err = env.EvalString(exportMacro)
+	panicOn(err)
+
+	importMacro := `(defmac import [& spec] ^(__import (quote ~spec)))`
+	_, err = env.EvalString(importMacro)
+	panicOn(err)
+
 	slurpMacro := `(defmac slurp [a] ^(slurpf (sym2str (quote ~a))))`
 	_, err = env.EvalString(slurpMacro)
 	panicOn(err)
@@ -350,10 +434,29 @@ func (env *Glisp) StandardSetup() {
 	_, err = env.EvalString(owriteMacro)
 	panicOn(err)
 
-	writeMacro := `(defmac write [array filepath] ^(writef ~array (sym2str (quote ~filepath))))`
+	// (write port x...) writes to a port; (write array filepath)
+	// writes array to a new file named by the symbol filepath.
+	writeMacro := `(defmac write [dest & xs]
+  (cond (and (== (len xs) 1) (symbol? (first xs)))
+    (let [x (first xs)]
+      ^(__write-sym ~dest (quote ~x) (fn [] ~x)))
+    ^(__write ~dest ~@xs)))`
 	_, err = env.EvalString(writeMacro)
 	panicOn(err)
 
+	defflagsMacro := `(defmac defflags [& specs] ^(__defflags (quote ~specs) *argv*))`
+	_, err = env.EvalString(defflagsMacro)
+	panicOn(err)
+
+	// (with-open [f (open path)] body...) closes f when body is done,
+	// even if body fails.
+	withOpenMacro := `(defmac with-open [binding & body]
+  (let [sym (aget binding 0)
+        opener (aget binding 1)]
+    ^(__with-open ~opener (fn [~sym] ~@body))))`
+	_, err = env.EvalString(withOpenMacro)
+	panicOn(err)
+
 	systemMacro := `(defmac $ [ & body] ^(system (quote ~body)))`
 	_, err = env.EvalString(systemMacro)
 	panicOn(err)
@@ -379,9 +482,6 @@ func (env *Glisp) StandardSetup() {
 	env.ImportRegex()
 	env.ImportRandom()
 
-	gob.Register(SexpHash{})
-	gob.Register(SexpArray{})
-
 	// create constructors for each of our pre-registered Go struct types
 	/*
 		for name, fac := range GoStructRegistry.Registry {
@@ -429,6 +529,14 @@ func ReplMain(cfg *GlispConfig) {
 		env.AddPostHook(CountPostHook)
 	}
 
+	args := cfg.Flags.Args()
+	if len(args) > 0 && cfg.Command == "" {
+		env.AddGlobal("*script*", SexpStr{S: args[0]})
+		env.SetArgv(args[1:])
+	} else {
+		env.SetArgv(args)
+	}
+
 	if cfg.Command != "" {
 		_, err := env.EvalString(cfg.Command)
 		if err != nil {
@@ -438,7 +546,6 @@ func ReplMain(cfg *GlispConfig) {
 		os.Exit(0)
 	}
 
-	args := cfg.Flags.Args()
 	if len(args) > 0 {
 		runScript(env, args[0], cfg)
 	} else {
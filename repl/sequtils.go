@@ -0,0 +1,633 @@
+package zygo
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// sequtils.go: higher-order sequence functions that work
+// uniformly over lists, arrays, and the (key value) pairs
+// of hashes. Results come back as the same kind of sequence
+// that was passed in, when that makes sense.
+
+var NotASequence = errors.New("not a list, array, or hash")
+
+// SeqToArray returns the elements of a list, array, or hash.
+// Hash entries are returned as two-element (key value) lists,
+// in key order, just as hpair would return them.
+func SeqToArray(seq Sexp) ([]Sexp, error) {
+	switch s := seq.(type) {
+	case SexpSentinel:
+		if s == SexpNull {
+			return []Sexp{}, nil
+		}
+	case SexpPair:
+		return ListToArray(s)
+	case *SexpArray:
+		return s.Val, nil
+	case *SexpPVec:
+		return s.Elems(), nil
+	case *SexpPMap:
+		return pmapPairList(s), nil
+	case *SexpSet:
+		return s.Elems(), nil
+	case *SexpHash:
+		res := make([]Sexp, 0, s.NumKeys)
+		for _, key := range s.KeyOrder {
+			val, err := s.HashGet(nil, key)
+			if err != nil {
+				// deleted keys remain in KeyOrder; skip them.
+				continue
+			}
+			res = append(res, Cons(key, Cons(val, SexpNull)))
+		}
+		return res, nil
+	}
+	return nil, NotASequence
+}
+
+// seqRebuild makes a new sequence of the same kind as like,
+// holding elems. Hashes are rebuilt from (key value) pairs.
+func seqRebuild(env *Glisp, like Sexp, elems []Sexp) (Sexp, error) {
+	switch like.(type) {
+	case *SexpArray:
+		return &SexpArray{Val: elems}, nil
+	case *SexpPVec:
+		return NewPVec(elems), nil
+	case *SexpPMap:
+		return ConjFunction(env, "conj", append([]Sexp{NewPMap()}, elems...))
+	case *SexpSet:
+		set, err := MakeSet(elems)
+		if err != nil {
+			return SexpNull, err
+		}
+		return set, nil
+	case *SexpHash:
+		hash, err := MakeHash(nil, "hash", env)
+		if err != nil {
+			return SexpNull, err
+		}
+		for i, e := range elems {
+			key, val, err := seqPairParts(e)
+			if err != nil {
+				return SexpNull, fmt.Errorf("cannot rebuild hash: element %d "+
+					"is not a (key value) pair: '%s'", i, e.SexpString())
+			}
+			err = hash.HashSet(key, val)
+			if err != nil {
+				return SexpNull, err
+			}
+		}
+		return hash, nil
+	}
+	return MakeList(elems), nil
+}
+
+func seqPairParts(e Sexp) (key Sexp, val Sexp, err error) {
+	switch p := e.(type) {
+	case SexpPair:
+		switch t := p.Tail.(type) {
+		case SexpPair:
+			return p.Head, t.Head, nil
+		}
+	case *SexpArray:
+		if len(p.Val) == 2 {
+			return p.Val[0], p.Val[1], nil
+		}
+	}
+	return SexpNull, SexpNull, fmt.Errorf("not a pair")
+}
+
+// seqFunctionArg checks that arg, which names it in errors, is a
+// function.
+func seqFunctionArg(name string, which string, arg Sexp) (*SexpFunction, error) {
+	fun, isFun := arg.(*SexpFunction)
+	if !isFun {
+		return nil, fmt.Errorf("%s: %s must be function, "+
+			"but we had %T / val = '%s'", name, which, arg, arg.SexpString())
+	}
+	return fun, nil
+}
+
+func seqIntArg(name string, arg Sexp) (int, error) {
+	switch n := arg.(type) {
+	case *SexpInt:
+		return int(n.Val), nil
+	case SexpChar:
+		return int(n.Val), nil
+	}
+	return 0, fmt.Errorf("%s: integer argument required, but we had %T / val = '%s'",
+		name, arg, arg.SexpString())
+}
+
+// (filter f seq) and (remove f seq)
+func FilterFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	fun, err := seqFunctionArg(name, "first argument", args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	elems, err := SeqToArray(args[1])
+	if err != nil {
+		return SexpNull, fmt.Errorf("%s: second argument: %s", name, err)
+	}
+
+	keep := name == "filter"
+	res := make([]Sexp, 0, len(elems))
+	for _, e := range elems {
+		ok, err := env.Apply(fun, []Sexp{e})
+		if err != nil {
+			return SexpNull, err
+		}
+		if IsTruthy(ok) == keep {
+			res = append(res, e)
+		}
+	}
+	return seqRebuild(env, args[1], res)
+}
+
+// (reduce f seq), (reduce f init seq), and (fold f init seq)
+func ReduceFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	narg := len(args)
+	if narg < 2 || narg > 3 || (name == "fold" && narg != 3) {
+		return SexpNull, WrongNargs
+	}
+	fun, err := seqFunctionArg(name, "first argument", args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	elems, err := SeqToArray(args[narg-1])
+	if err != nil {
+		return SexpNull, fmt.Errorf("%s: last argument: %s", name, err)
+	}
+
+	var accum Sexp
+	if narg == 3 {
+		accum = args[1]
+	} else {
+		if len(elems) == 0 {
+			return SexpNull, fmt.Errorf("%s of empty sequence with no initial value", name)
+		}
+		accum = elems[0]
+		elems = elems[1:]
+	}
+
+	for _, e := range elems {
+		accum, err = env.Apply(fun, []Sexp{accum, e})
+		if err != nil {
+			return SexpNull, err
+		}
+	}
+	return accum, nil
+}
+
+// seqLess builds a less-than function for sorting. A comparator
+// may return a bool (true meaning a < b) or an int (negative
+// meaning a < b). The first error encountered is saved in *perr.
+func seqLess(env *Glisp, cmp *SexpFunction, perr *error) func(a, b Sexp) bool {
+	return func(a, b Sexp) bool {
+		if *perr != nil {
+			return false
+		}
+		if cmp == nil {
+			res, err := Compare(a, b)
+			if err != nil {
+				*perr = err
+				return false
+			}
+			return res < 0
+		}
+		res, err := env.Apply(cmp, []Sexp{a, b})
+		if err != nil {
+			*perr = err
+			return false
+		}
+		switch r := res.(type) {
+		case SexpBool:
+			return r.Val
+		case *SexpInt:
+			return r.Val < 0
+		}
+		*perr = fmt.Errorf("sort comparator must return bool or int, not %T", res)
+		return false
+	}
+}
+
+// (sort seq) or (sort seq comparator). The sort is stable.
+func SortFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	narg := len(args)
+	if narg < 1 || narg > 2 {
+		return SexpNull, WrongNargs
+	}
+	var cmp *SexpFunction
+	if narg == 2 {
+		var err error
+		cmp, err = seqFunctionArg(name, "comparator (second argument)", args[1])
+		if err != nil {
+			return SexpNull, err
+		}
+	}
+	elems, err := SeqToArray(args[0])
+	if err != nil {
+		return SexpNull, fmt.Errorf("%s: first argument: %s", name, err)
+	}
+
+	// never sort the caller's array in place
+	sorted := make([]Sexp, len(elems))
+	copy(sorted, elems)
+
+	var sortErr error
+	less := seqLess(env, cmp, &sortErr)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return less(sorted[i], sorted[j])
+	})
+	if sortErr != nil {
+		return SexpNull, fmt.Errorf("%s: %s", name, sortErr)
+	}
+	return seqRebuild(env, args[0], sorted)
+}
+
+// (sort-by keyfn seq) or (sort-by keyfn seq comparator). The sort is
+// stable, and keyfn is called only once per element.
+func SortByFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	narg := len(args)
+	if narg < 2 || narg > 3 {
+		return SexpNull, WrongNargs
+	}
+	keyfn, err := seqFunctionArg(name, "first argument", args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	var cmp *SexpFunction
+	if narg == 3 {
+		cmp, err = seqFunctionArg(name, "comparator (third argument)", args[2])
+		if err != nil {
+			return SexpNull, err
+		}
+	}
+	elems, err := SeqToArray(args[1])
+	if err != nil {
+		return SexpNull, fmt.Errorf("%s: second argument: %s", name, err)
+	}
+
+	type keyed struct {
+		key Sexp
+		val Sexp
+	}
+	ks := make([]keyed, len(elems))
+	for i, e := range elems {
+		k, err := env.Apply(keyfn, []Sexp{e})
+		if err != nil {
+			return SexpNull, err
+		}
+		ks[i] = keyed{key: k, val: e}
+	}
+
+	var sortErr error
+	less := seqLess(env, cmp, &sortErr)
+	sort.SliceStable(ks, func(i, j int) bool {
+		return less(ks[i].key, ks[j].key)
+	})
+	if sortErr != nil {
+		return SexpNull, fmt.Errorf("%s: %s", name, sortErr)
+	}
+
+	sorted := make([]Sexp, len(ks))
+	for i := range ks {
+		sorted[i] = ks[i].val
+	}
+	return seqRebuild(env, args[1], sorted)
+}
+
+// (group-by f seq) returns a hash from each distinct (f x) to
+// the array of elements x that produced it, in sequence order.
+func GroupByFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	fun, err := seqFunctionArg(name, "first argument", args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	elems, err := SeqToArray(args[1])
+	if err != nil {
+		return SexpNull, fmt.Errorf("%s: second argument: %s", name, err)
+	}
+
+	groups, err := MakeHash(nil, "hash", env)
+	if err != nil {
+		return SexpNull, err
+	}
+	for _, e := range elems {
+		k, err := env.Apply(fun, []Sexp{e})
+		if err != nil {
+			return SexpNull, err
+		}
+		prev, err := groups.HashGetDefault(nil, k, SexpNull)
+		if err != nil {
+			return SexpNull, err
+		}
+		arr, isArr := prev.(*SexpArray)
+		if !isArr {
+			arr = &SexpArray{}
+			err = groups.HashSet(k, arr)
+			if err != nil {
+				return SexpNull, err
+			}
+		}
+		arr.Val = append(arr.Val, e)
+	}
+	return groups, nil
+}
+
+// (partition n seq) splits seq into chunks of n elements; the
+// last chunk may be shorter. (partition f seq) instead returns
+// a two element array: the elements for which (f x) is true,
+// then those for which it is false.
+func PartitionFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	elems, err := SeqToArray(args[1])
+	if err != nil {
+		return SexpNull, fmt.Errorf("%s: second argument: %s", name, err)
+	}
+
+	if fun, isFun := args[0].(*SexpFunction); isFun {
+		yes := make([]Sexp, 0)
+		no := make([]Sexp, 0)
+		for _, e := range elems {
+			ok, err := env.Apply(fun, []Sexp{e})
+			if err != nil {
+				return SexpNull, err
+			}
+			if IsTruthy(ok) {
+				yes = append(yes, e)
+			} else {
+				no = append(no, e)
+			}
+		}
+		yesSeq, err := seqRebuild(env, args[1], yes)
+		if err != nil {
+			return SexpNull, err
+		}
+		noSeq, err := seqRebuild(env, args[1], no)
+		if err != nil {
+			return SexpNull, err
+		}
+		return &SexpArray{Val: []Sexp{yesSeq, noSeq}}, nil
+	}
+
+	n, err := seqIntArg(name, args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	if n <= 0 {
+		return SexpNull, fmt.Errorf("%s: chunk size must be positive, not %d", name, n)
+	}
+	chunks := make([]Sexp, 0, len(elems)/n+1)
+	for i := 0; i < len(elems); i += n {
+		end := i + n
+		if end > len(elems) {
+			end = len(elems)
+		}
+		chunk, err := seqRebuild(env, args[1], elems[i:end:end])
+		if err != nil {
+			return SexpNull, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return seqRebuild(env, &SexpArray{}, chunks)
+}
+
+// (zip a b ...) returns a sequence of tuples, stopping at the
+// end of the shortest input. The result, and each tuple, is of
+// the same kind as the first argument (a list or an array).
+func ZipFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 1 {
+		return SexpNull, WrongNargs
+	}
+	seqs := make([][]Sexp, len(args))
+	shortest := -1
+	for i := range args {
+		elems, err := SeqToArray(args[i])
+		if err != nil {
+			return SexpNull, fmt.Errorf("%s: argument %d: %s", name, i, err)
+		}
+		seqs[i] = elems
+		if shortest < 0 || len(elems) < shortest {
+			shortest = len(elems)
+		}
+	}
+
+	like := args[0]
+	if _, isHash := like.(*SexpHash); isHash {
+		like = &SexpArray{}
+	}
+	res := make([]Sexp, shortest)
+	for j := 0; j < shortest; j++ {
+		tuple := make([]Sexp, len(seqs))
+		for i := range seqs {
+			tuple[i] = seqs[i][j]
+		}
+		t, err := seqRebuild(env, like, tuple)
+		if err != nil {
+			return SexpNull, err
+		}
+		res[j] = t
+	}
+	return seqRebuild(env, like, res)
+}
+
+// (__range end), (__range start end), or (__range start end step)
+// returns an array of integers. Scripts call this through the
+// range macro, as in (range 10); see StandardSetup().
+func RangeFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	narg := len(args)
+	if narg < 1 || narg > 3 {
+		return SexpNull, WrongNargs
+	}
+	bounds := make([]int64, narg)
+	for i := range args {
+		n, isInt := args[i].(*SexpInt)
+		if !isInt {
+			return SexpNull, fmt.Errorf("range arguments must be integers, "+
+				"but argument %d was %T / val = '%s'", i, args[i], args[i].SexpString())
+		}
+		bounds[i] = n.Val
+	}
+
+	var start, end, step int64 = 0, 0, 1
+	switch narg {
+	case 1:
+		end = bounds[0]
+	case 2:
+		start, end = bounds[0], bounds[1]
+	case 3:
+		start, end, step = bounds[0], bounds[1], bounds[2]
+	}
+	if step == 0 {
+		return SexpNull, fmt.Errorf("range step cannot be zero")
+	}
+
+	res := make([]Sexp, 0)
+	for i := start; (step > 0 && i < end) || (step < 0 && i > end); i += step {
+		res = append(res, &SexpInt{Val: i})
+	}
+	return &SexpArray{Val: res}, nil
+}
+
+// (take n seq) and (drop n seq)
+func TakeDropFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	n, err := seqIntArg(name, args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	elems, err := SeqToArray(args[1])
+	if err != nil {
+		return SexpNull, fmt.Errorf("%s: second argument: %s", name, err)
+	}
+	if n < 0 {
+		n = 0
+	}
+	if n > len(elems) {
+		n = len(elems)
+	}
+
+	switch name {
+	case "take":
+		return seqRebuild(env, args[1], elems[:n:n])
+	case "drop":
+		return seqRebuild(env, args[1], elems[n:])
+	}
+	return SexpNull, fmt.Errorf("unrecognized command '%s'", name)
+}
+
+// (any? f seq) and (every? f seq)
+func AnyEveryFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	fun, err := seqFunctionArg(name, "first argument", args[0])
+	if err != nil {
+		return SexpNull, err
+	}
+	elems, err := SeqToArray(args[1])
+	if err != nil {
+		return SexpNull, fmt.Errorf("%s: second argument: %s", name, err)
+	}
+
+	// any? stops at the first truthy result, every? at the first falsy one.
+	stopOn := name == "any?"
+	for _, e := range elems {
+		res, err := env.Apply(fun, []Sexp{e})
+		if err != nil {
+			return SexpNull, err
+		}
+		if IsTruthy(res) == stopOn {
+			return SexpBool{Val: stopOn}, nil
+		}
+	}
+	return SexpBool{Val: !stopOn}, nil
+}
+
+// (distinct seq) drops later duplicates, keeping the first of each.
+func DistinctFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	elems, err := SeqToArray(args[0])
+	if err != nil {
+		return SexpNull, fmt.Errorf("%s: %s", name, err)
+	}
+
+	// a hash finds the elements seen so far by their structural hash
+	seen, err := MakeHash(nil, "hash", env)
+	if err != nil {
+		return SexpNull, err
+	}
+	res := make([]Sexp, 0, len(elems))
+	for _, e := range elems {
+		prev, err := seen.HashGetDefault(nil, e, SexpNull)
+		if err != nil {
+			return SexpNull, fmt.Errorf("%s: %s", name, err)
+		}
+		if prev != SexpNull {
+			continue
+		}
+		if err = seen.HashSet(e, SexpBool{Val: true}); err != nil {
+			return SexpNull, fmt.Errorf("%s: %s", name, err)
+		}
+		res = append(res, e)
+	}
+	return seqRebuild(env, args[0], res)
+}
+
+// (frequencies seq) returns a hash from each element to its count.
+func FrequenciesFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	elems, err := SeqToArray(args[0])
+	if err != nil {
+		return SexpNull, fmt.Errorf("%s: %s", name, err)
+	}
+
+	counts, err := MakeHash(nil, "hash", env)
+	if err != nil {
+		return SexpNull, err
+	}
+	for _, e := range elems {
+		prev, err := counts.HashGetDefault(nil, e, SexpNull)
+		if err != nil {
+			return SexpNull, err
+		}
+		cnt, isInt := prev.(*SexpInt)
+		if !isInt {
+			cnt = &SexpInt{}
+			err = counts.HashSet(e, cnt)
+			if err != nil {
+				return SexpNull, err
+			}
+		}
+		cnt.Val++
+	}
+	return counts, nil
+}
+
+func SequenceFunctions() map[string]GlispUserFunction {
+	return map[string]GlispUserFunction{
+		"filter":       FilterFunction,
+		"remove":       FilterFunction,
+		"reduce":       ReduceFunction,
+		"fold":         ReduceFunction,
+		"sort":         SortFunction,
+		"sort-by":      SortByFunction,
+		"group-by":     GroupByFunction,
+		"partition":    PartitionFunction,
+		"zip":          ZipFunction,
+		"__range":      RangeFunction,
+		"take":         TakeDropFunction,
+		"drop":         TakeDropFunction,
+		"any?":         AnyEveryFunction,
+		"every?":       AnyEveryFunction,
+		"distinct":     DistinctFunction,
+		"frequencies":  FrequenciesFunction,
+		"lmap":         LazyMapFunction,
+		"lfilter":      LazyFilterFunction,
+		"ltake":        LazyTakeFunction,
+		"generator":    GeneratorFunction,
+		"iter":         IterFunction,
+		"__doseq-iter": IterFunction,
+		"iter-next!":   IterFunction,
+		"iter-val":     IterFunction,
+		"iter-close":   IterFunction,
+		"realize":      RealizeFunction,
+	}
+}
@@ -0,0 +1,269 @@
+package zygo
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SexpSet is an unordered collection of distinct values. The
+// reader turns #{1 2 3} into (hash-set 1 2 3). Members are
+// bucketed by hashHelper, just as hash keys are, and told apart
+// within a bucket with Compare.
+type SexpSet struct {
+	Map     map[int][]Sexp
+	NumKeys int
+}
+
+func NewSet() *SexpSet {
+	return &SexpSet{Map: make(map[int][]Sexp)}
+}
+
+func setHash(x Sexp) (int, error) {
+	h, _, err := hashHelper(x)
+	return h, err
+}
+
+func (s *SexpSet) Add(x Sexp) error {
+	h, err := setHash(x)
+	if err != nil {
+		return err
+	}
+	for _, y := range s.Map[h] {
+		if res, err := Compare(x, y); err == nil && res == 0 {
+			return nil
+		}
+	}
+	s.Map[h] = append(s.Map[h], x)
+	s.NumKeys++
+	return nil
+}
+
+func (s *SexpSet) Contains(x Sexp) (bool, error) {
+	h, err := setHash(x)
+	if err != nil {
+		return false, err
+	}
+	for _, y := range s.Map[h] {
+		if res, err := Compare(x, y); err == nil && res == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// setOrderClass groups members for sorting: numbers together,
+// then everything else by Go type.
+func setOrderClass(x Sexp) string {
+	switch x.(type) {
+	case *SexpInt, SexpFloat:
+		return ""
+	}
+	return fmt.Sprintf("%T", x)
+}
+
+// Elems returns the members in a deterministic order: sorted with
+// Compare within each kind of value, so that printing, iteration
+// and encoding do not depend on Go's map ordering.
+func (s *SexpSet) Elems() []Sexp {
+	res := make([]Sexp, 0, s.NumKeys)
+	for _, bucket := range s.Map {
+		res = append(res, bucket...)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		ci, cj := setOrderClass(res[i]), setOrderClass(res[j])
+		if ci != cj {
+			return ci < cj
+		}
+		cmp, err := Compare(res[i], res[j])
+		if err != nil {
+			return res[i].SexpString() < res[j].SexpString()
+		}
+		return cmp < 0
+	})
+	return res
+}
+
+func (s *SexpSet) SexpString() string {
+	str := "#{"
+	for i, x := range s.Elems() {
+		if i > 0 {
+			str += " "
+		}
+		str += x.SexpString()
+	}
+	return str + "}"
+}
+
+func (s *SexpSet) Type() *RegisteredType {
+	return nil
+}
+
+// sets are equal when they have the same members; there is
+// no other ordering between them.
+func compareSet(a *SexpSet, b Sexp) (int, error) {
+	bs, isSet := b.(*SexpSet)
+	if !isSet {
+		return 0, fmt.Errorf("cannot compare %T to %T", a, b)
+	}
+	if a.NumKeys != bs.NumKeys {
+		return 1, nil
+	}
+	sub, err := a.SubsetOf(bs)
+	if err != nil || !sub {
+		return 1, err
+	}
+	return 0, nil
+}
+
+func (s *SexpSet) SubsetOf(t *SexpSet) (bool, error) {
+	for _, bucket := range s.Map {
+		for _, x := range bucket {
+			found, err := t.Contains(x)
+			if err != nil || !found {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// MakeSet builds a set holding each of elems once.
+func MakeSet(elems []Sexp) (*SexpSet, error) {
+	s := NewSet()
+	for _, x := range elems {
+		err := s.Add(x)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func HashSetFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	set, err := MakeSet(args)
+	if err != nil {
+		return SexpNull, err
+	}
+	return set, nil
+}
+
+func setArgs(name string, args []Sexp) ([]*SexpSet, error) {
+	sets := make([]*SexpSet, len(args))
+	for i, a := range args {
+		s, isSet := a.(*SexpSet)
+		if !isSet {
+			return nil, fmt.Errorf("%s: argument %d must be a set, "+
+				"but we had %T / val = '%s'", name, i+1, a, a.SexpString())
+		}
+		sets[i] = s
+	}
+	return sets, nil
+}
+
+// (union s1 s2 ...), (intersection s1 s2 ...) and (difference s1 s2 ...)
+// all return a new set.
+func SetOpFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 1 {
+		return SexpNull, WrongNargs
+	}
+	sets, err := setArgs(name, args)
+	if err != nil {
+		return SexpNull, err
+	}
+
+	res := NewSet()
+	if name == "union" {
+		for _, s := range sets {
+			for _, bucket := range s.Map {
+				for _, x := range bucket {
+					err = res.Add(x)
+					if err != nil {
+						return SexpNull, err
+					}
+				}
+			}
+		}
+		return res, nil
+	}
+
+	for _, bucket := range sets[0].Map {
+	nextMember:
+		for _, x := range bucket {
+			for _, s := range sets[1:] {
+				found, err := s.Contains(x)
+				if err != nil {
+					return SexpNull, err
+				}
+				if found != (name == "intersection") {
+					continue nextMember
+				}
+			}
+			err = res.Add(x)
+			if err != nil {
+				return SexpNull, err
+			}
+		}
+	}
+	return res, nil
+}
+
+// (subset? a b) is true when every member of a is in b.
+func SubsetFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	sets, err := setArgs(name, args)
+	if err != nil {
+		return SexpNull, err
+	}
+	sub, err := sets[0].SubsetOf(sets[1])
+	if err != nil {
+		return SexpNull, err
+	}
+	return SexpBool{Val: sub}, nil
+}
+
+// (contains? coll x) tests set membership, or the presence of a
+// key in a hash or pmap. On a string, it looks for a substring
+// or char.
+func ContainsFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	switch coll := args[0].(type) {
+	case SexpStr:
+		sub, err := strArg(name, args, 1)
+		if err != nil {
+			return SexpNull, err
+		}
+		return SexpBool{Val: strings.Contains(coll.S, sub)}, nil
+	case *SexpSet:
+		found, err := coll.Contains(args[1])
+		if err != nil {
+			return SexpNull, err
+		}
+		return SexpBool{Val: found}, nil
+	case *SexpHash:
+		_, err := coll.HashGet(nil, args[1])
+		return SexpBool{Val: err == nil}, nil
+	case *SexpPMap:
+		_, found, err := coll.Get(args[1])
+		if err != nil {
+			return SexpNull, err
+		}
+		return SexpBool{Val: found}, nil
+	}
+	return SexpNull, errors.New("first argument of contains? must be string, set, hash, or pmap")
+}
+
+func SetFunctions() map[string]GlispUserFunction {
+	return map[string]GlispUserFunction{
+		"hash-set":     HashSetFunction,
+		"union":        SetOpFunction,
+		"intersection": SetOpFunction,
+		"difference":   SetOpFunction,
+		"subset?":      SubsetFunction,
+	}
+}
@@ -61,7 +61,7 @@ func SlurpfileFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 	return &SexpArray{Val: a}, nil
 }
 
-// (writef path); (write path) is the macro version.
+// (writef array path); (write array path) does the same.
 // (owritef path): write an array of strings out to the named file,
 // overwriting it in the process. (owrite) is the macro version.
 func WriteToFileFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
@@ -128,7 +128,12 @@ func SourceFileFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 			var f *os.File
 			var err error
 
-			if f, err = os.Open(t.S); err != nil {
+			// relative to the current directory, else on $ZYGOPATH
+			file := t.S
+			if found, err := searchPath(file, append([]string{"."}, ZygoPath()...)); err == nil {
+				file = found
+			}
+			if f, err = os.Open(file); err != nil {
 				return err
 			}
 			defer f.Close()
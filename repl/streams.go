@@ -0,0 +1,239 @@
+package zygo
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Streaming JSON and msgpack over ports. A decoder reads one value
+// each time it is asked, so a multi-GB log of newline-delimited
+// JSON, or of concatenated msgpack values, can be walked with
+// decode, doseq or the lazy lmap/lfilter/ltake without slurping it.
+// An encoder writes one value per call; JSON values each go on a
+// line of their own.
+
+// SexpDecoder reads successive values from a port.
+type SexpDecoder struct {
+	Format string
+	Port   *SexpPort
+
+	dec  *codec.Decoder
+	own  bool
+	done bool
+}
+
+// SexpEncoder writes successive values to a port.
+type SexpEncoder struct {
+	Format string
+	Port   *SexpPort
+}
+
+func NewJsonDecoder(p *SexpPort) *SexpDecoder {
+	return &SexpDecoder{Format: "json", Port: p, dec: codec.NewDecoder(p.Reader, &msgpHelper.jh)}
+}
+
+func NewMsgpackDecoder(p *SexpPort) *SexpDecoder {
+	return &SexpDecoder{Format: "msgpack", Port: p, dec: codec.NewDecoder(p.Reader, &msgpHelper.mh)}
+}
+
+func (d *SexpDecoder) SexpString() string {
+	return fmt.Sprintf("(%s-decoder %q)", d.Format, d.Port.Name)
+}
+
+func (d *SexpDecoder) Type() *RegisteredType {
+	return nil
+}
+
+func (e *SexpEncoder) SexpString() string {
+	return fmt.Sprintf("(%s-encoder %q)", e.Format, e.Port.Name)
+}
+
+func (e *SexpEncoder) Type() *RegisteredType {
+	return nil
+}
+
+// Next decodes the next value, or returns false at the end of the
+// stream. A decoder that opened its own file closes it there.
+// Decoders are Iterators, so doseq and friends read them lazily.
+func (d *SexpDecoder) Next(env *Glisp) (Sexp, bool, error) {
+	if d.done {
+		return SexpNull, false, nil
+	}
+	if d.Port.closed {
+		return SexpNull, false, fmt.Errorf("port '%s' is closed", d.Port.Name)
+	}
+	var iface interface{}
+	err := d.atEnd()
+	if err == nil {
+		err = d.dec.Decode(&iface)
+	}
+	if err == io.EOF {
+		d.done = true
+		if d.own {
+			return SexpNull, false, d.Port.Close()
+		}
+		return SexpNull, false, nil
+	}
+	if err != nil {
+		return SexpNull, false, fmt.Errorf("%s decode error on '%s': %v", d.Format, d.Port.Name, err)
+	}
+	x, err := GoToSexp(iface, env)
+	return x, err == nil, err
+}
+
+// atEnd returns io.EOF if nothing but white space is left before
+// the end of the stream. The codec does not tell a clean end apart
+// from a truncated value after the newline of the last JSON line.
+func (d *SexpDecoder) atEnd() error {
+	for {
+		c, err := d.Port.Reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if d.Format == "msgpack" || (c != ' ' && c != '\t' && c != '\r' && c != '\n') {
+			return d.Port.Reader.UnreadByte()
+		}
+	}
+}
+
+// Close leaves the port open, so that a doseq which breaks out
+// early can be followed by more reads. Use (close decoder) to
+// close the port.
+func (d *SexpDecoder) Close() {}
+
+// Encode writes x to the port. x is converted in full before
+// anything is written, so a value that cannot be encoded, such as a
+// function, gives an error and leaves the stream as it was.
+func (e *SexpEncoder) Encode(x Sexp) error {
+	by, err := e.encodeBytes(x)
+	if err != nil {
+		return fmt.Errorf("%s encode error: %v", e.Format, err)
+	}
+	_, err = e.Port.Write(by)
+	return err
+}
+
+// encodeBytes gives the encoding of x. For JSON, sexpToMsgpackGo
+// is only run as a check that x is data, as SexpToJson writes out
+// anything it does not know as its SexpString.
+func (e *SexpEncoder) encodeBytes(x Sexp) (by []byte, err error) {
+	// sexpToMsgpackGo panics on what it cannot translate.
+	defer func() {
+		if r := recover(); r != nil {
+			by, err = nil, fmt.Errorf("cannot encode '%s'", x.SexpString())
+		}
+	}()
+	iface := sexpToMsgpackGo(x)
+	if e.Format == "json" {
+		return []byte(SexpToJson(x) + "\n"), nil
+	}
+	return GoToMsgpack(iface)
+}
+
+// streamPort returns the port src names: a port, or a path that is
+// opened here. own is true if the caller should close what it
+// opened.
+func streamPort(name string, src Sexp, write bool) (p *SexpPort, own bool, err error) {
+	switch s := src.(type) {
+	case *SexpPort:
+		return s, false, nil
+	case SexpStr:
+		if write {
+			f, err := os.Create(s.S)
+			if err != nil {
+				return nil, false, err
+			}
+			return NewBufferedWritePort(s.S, f, f), true, nil
+		}
+		f, err := os.Open(s.S)
+		if err != nil {
+			return nil, false, err
+		}
+		return NewReadPort(s.S, f, f), true, nil
+	}
+	return nil, false, fmt.Errorf("%s: argument must be a port or a path, but we had %T / val = '%s'",
+		name, src, src.SexpString())
+}
+
+// (json-decoder src) and (msgp-reader src) read values from src,
+// a port or the path of a file; (json-encoder dst) and
+// (msgp-writer dst) write values to dst, a port or the path of a
+// file to create.
+func StreamFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	write := name == "json-encoder" || name == "msgp-writer"
+	p, own, err := streamPort(name, args[0], write)
+	if err != nil {
+		return SexpNull, err
+	}
+	if write && p.Writer == nil {
+		return SexpNull, fmt.Errorf("%s: port '%s' is not open for writing", name, p.Name)
+	}
+	if !write && p.Reader == nil {
+		return SexpNull, fmt.Errorf("%s: port '%s' is not open for reading", name, p.Name)
+	}
+
+	switch name {
+	case "json-decoder":
+		d := NewJsonDecoder(p)
+		d.own = own
+		return d, nil
+	case "msgp-reader":
+		d := NewMsgpackDecoder(p)
+		d.own = own
+		return d, nil
+	case "json-encoder":
+		return &SexpEncoder{Format: "json", Port: p}, nil
+	case "msgp-writer":
+		return &SexpEncoder{Format: "msgpack", Port: p}, nil
+	}
+	return SexpNull, fmt.Errorf("unknown function %s", name)
+}
+
+// (decode decoder [eof]) returns the next value, or eof (nil if
+// not given) once the stream is used up. Pass eof to tell the end
+// apart from a JSON null.
+func DecodeFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return SexpNull, WrongNargs
+	}
+	d, isDec := args[0].(*SexpDecoder)
+	if !isDec {
+		return SexpNull, fmt.Errorf("%s: argument must be a decoder, but we had %T / val = '%s'",
+			name, args[0], args[0].SexpString())
+	}
+	x, ok, err := d.Next(env)
+	if err != nil {
+		return SexpNull, err
+	}
+	if !ok {
+		if len(args) == 2 {
+			return args[1], nil
+		}
+		return SexpNull, nil
+	}
+	return x, nil
+}
+
+// (encode encoder x...) writes each x.
+func EncodeFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 1 {
+		return SexpNull, WrongNargs
+	}
+	e, isEnc := args[0].(*SexpEncoder)
+	if !isEnc {
+		return SexpNull, fmt.Errorf("%s: argument must be an encoder, but we had %T / val = '%s'",
+			name, args[0], args[0].SexpString())
+	}
+	for _, x := range args[1:] {
+		if err := e.Encode(x); err != nil {
+			return SexpNull, err
+		}
+	}
+	return SexpNull, nil
+}
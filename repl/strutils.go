@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 func ConcatStr(str SexpStr, rest []Sexp) (SexpStr, error) {
@@ -57,3 +59,291 @@ func StringUtilFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
 	}
 	return SexpNull, fmt.Errorf("unrecognized command '%s'", name)
 }
+
+// strArg returns args[i] as a string. Chars are accepted
+// wherever a string is, as one-rune strings.
+func strArg(name string, args []Sexp, i int) (string, error) {
+	switch t := args[i].(type) {
+	case SexpStr:
+		return t.S, nil
+	case SexpChar:
+		return string(t.Val), nil
+	}
+	return "", fmt.Errorf("%s: argument %d must be a string, got %T",
+		name, i+1, args[i])
+}
+
+// titleCase upper-cases the first letter of each word.
+func titleCase(s string) string {
+	runes := []rune(s)
+	start := true
+	for i, r := range runes {
+		if unicode.IsSpace(r) {
+			start = true
+			continue
+		}
+		if start {
+			runes[i] = unicode.ToTitle(r)
+			start = false
+		}
+	}
+	return string(runes)
+}
+
+// (upper s), (lower s), (title s), (fields s) and (string-reverse s).
+// upper and lower also take a char, and return one.
+func StringCaseFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 1 {
+		return SexpNull, WrongNargs
+	}
+	if c, isChar := args[0].(SexpChar); isChar {
+		switch name {
+		case "upper":
+			return SexpChar{Val: unicode.ToUpper(c.Val)}, nil
+		case "lower":
+			return SexpChar{Val: unicode.ToLower(c.Val)}, nil
+		}
+	}
+	s, err := strArg(name, args, 0)
+	if err != nil {
+		return SexpNull, err
+	}
+
+	switch name {
+	case "upper":
+		return SexpStr{S: strings.ToUpper(s)}, nil
+	case "lower":
+		return SexpStr{S: strings.ToLower(s)}, nil
+	case "title":
+		return SexpStr{S: titleCase(s)}, nil
+	case "fields":
+		f := strings.Fields(s)
+		res := make([]Sexp, len(f))
+		for i := range f {
+			res[i] = SexpStr{S: f[i]}
+		}
+		return &SexpArray{Val: res}, nil
+	case "string-reverse":
+		runes := []rune(s)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return SexpStr{S: string(runes)}, nil
+	}
+	return SexpNull, fmt.Errorf("unrecognized command '%s'", name)
+}
+
+// (starts-with? s prefix), (ends-with? s suffix), (trim-prefix s prefix),
+// (trim-suffix s suffix) and (trim-chars s cutset).
+func StringAffixFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	s, err := strArg(name, args, 0)
+	if err != nil {
+		return SexpNull, err
+	}
+	t, err := strArg(name, args, 1)
+	if err != nil {
+		return SexpNull, err
+	}
+
+	switch name {
+	case "starts-with?":
+		return SexpBool{Val: strings.HasPrefix(s, t)}, nil
+	case "ends-with?":
+		return SexpBool{Val: strings.HasSuffix(s, t)}, nil
+	case "trim-prefix":
+		return SexpStr{S: strings.TrimPrefix(s, t)}, nil
+	case "trim-suffix":
+		return SexpStr{S: strings.TrimSuffix(s, t)}, nil
+	case "trim-chars":
+		return SexpStr{S: strings.Trim(s, t)}, nil
+	}
+	return SexpNull, fmt.Errorf("unrecognized command '%s'", name)
+}
+
+// (replace s old new) replaces the first match, or the first n
+// with (replace s old new n); (replace-all s old new) replaces all.
+func StringReplaceFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	n := -1
+	switch {
+	case name == "replace" && len(args) == 4:
+		var err error
+		n, err = seqIntArg(name, args[3])
+		if err != nil {
+			return SexpNull, err
+		}
+	case name == "replace" && len(args) == 3:
+		n = 1
+	case len(args) != 3:
+		return SexpNull, WrongNargs
+	}
+
+	strs := make([]string, 3)
+	for i := range strs {
+		var err error
+		strs[i], err = strArg(name, args, i)
+		if err != nil {
+			return SexpNull, err
+		}
+	}
+	return SexpStr{S: strings.Replace(strs[0], strs[1], strs[2], n)}, nil
+}
+
+// (index-of s sub) and (last-index-of s sub) return the rune index
+// of sub within s, or -1. index-of takes an optional rune index to
+// start searching from.
+func StringIndexFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 2 || len(args) > 3 || (len(args) == 3 && name != "index-of") {
+		return SexpNull, WrongNargs
+	}
+	s, err := strArg(name, args, 0)
+	if err != nil {
+		return SexpNull, err
+	}
+	sub, err := strArg(name, args, 1)
+	if err != nil {
+		return SexpNull, err
+	}
+
+	runes := []rune(s)
+	from := 0
+	if len(args) == 3 {
+		from, err = seqIntArg(name, args[2])
+		if err != nil {
+			return SexpNull, err
+		}
+		if from < 0 || from > len(runes) {
+			return SexpNull, fmt.Errorf("%s: start index %d out of bounds", name, from)
+		}
+	}
+	rest := string(runes[from:])
+
+	var i int
+	if name == "last-index-of" {
+		i = strings.LastIndex(rest, sub)
+	} else {
+		i = strings.Index(rest, sub)
+	}
+	if i < 0 {
+		return &SexpInt{Val: -1}, nil
+	}
+	// convert the byte offset into a rune offset
+	return &SexpInt{Val: int64(from + utf8.RuneCountInString(rest[:i]))}, nil
+}
+
+// (repeat s n) concatenates n copies of s.
+func StringRepeatFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 2 {
+		return SexpNull, WrongNargs
+	}
+	s, err := strArg(name, args, 0)
+	if err != nil {
+		return SexpNull, err
+	}
+	n, err := seqIntArg(name, args[1])
+	if err != nil {
+		return SexpNull, err
+	}
+	if n < 0 {
+		return SexpNull, fmt.Errorf("%s: negative count %d", name, n)
+	}
+	return SexpStr{S: strings.Repeat(s, n)}, nil
+}
+
+// (pad-left s width) and (pad-right s width) pad s with spaces, or
+// with the optional third argument, until it is width runes long.
+func StringPadFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return SexpNull, WrongNargs
+	}
+	s, err := strArg(name, args, 0)
+	if err != nil {
+		return SexpNull, err
+	}
+	width, err := seqIntArg(name, args[1])
+	if err != nil {
+		return SexpNull, err
+	}
+	pad := " "
+	if len(args) == 3 {
+		pad, err = strArg(name, args, 2)
+		if err != nil {
+			return SexpNull, err
+		}
+		if utf8.RuneCountInString(pad) != 1 {
+			return SexpNull, fmt.Errorf("%s: pad must be a single character", name)
+		}
+	}
+
+	n := width - utf8.RuneCountInString(s)
+	if n <= 0 {
+		return SexpStr{S: s}, nil
+	}
+	if name == "pad-left" {
+		return SexpStr{S: strings.Repeat(pad, n) + s}, nil
+	}
+	return SexpStr{S: s + strings.Repeat(pad, n)}, nil
+}
+
+// (join seq sep) is the inverse of split: it concatenates the
+// elements of a list or array, with sep between them. Elements
+// that are not strings or chars are joined in printed form.
+func StringJoinFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return SexpNull, WrongNargs
+	}
+	elems, err := SeqToArray(args[0])
+	if err != nil {
+		return SexpNull, fmt.Errorf("%s: first argument: %s", name, err)
+	}
+	sep := ""
+	if len(args) == 2 {
+		sep, err = strArg(name, args, 1)
+		if err != nil {
+			return SexpNull, err
+		}
+	}
+
+	strs := make([]string, len(elems))
+	for i, x := range elems {
+		switch t := x.(type) {
+		case SexpStr:
+			strs[i] = t.S
+		case SexpChar:
+			strs[i] = string(t.Val)
+		default:
+			strs[i] = x.SexpString()
+		}
+	}
+	return SexpStr{S: strings.Join(strs, sep)}, nil
+}
+
+// (substr s start) and (substr s start end) index by rune, not byte.
+func SubstrFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return SexpNull, WrongNargs
+	}
+	s, err := strArg(name, args, 0)
+	if err != nil {
+		return SexpNull, err
+	}
+	runes := []rune(s)
+	start, err := seqIntArg(name, args[1])
+	if err != nil {
+		return SexpNull, err
+	}
+	end := len(runes)
+	if len(args) == 3 {
+		end, err = seqIntArg(name, args[2])
+		if err != nil {
+			return SexpNull, err
+		}
+	}
+	if start < 0 || end > len(runes) || start > end {
+		return SexpNull, fmt.Errorf("%s: range [%d:%d] out of bounds for "+
+			"string of length %d", name, start, end, len(runes))
+	}
+	return SexpStr{S: string(runes[start:end])}, nil
+}
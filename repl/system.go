@@ -118,3 +118,73 @@ func Chomp(by []byte) []byte {
 	}
 	return by
 }
+
+// (getenv name [default]) returns the value of an environment
+// variable, or default (nil if not given) when it is unset.
+// (setenv name value) and (unsetenv name) return nil, or an error
+// value if the change was refused.
+func GetenvFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return SexpNull, WrongNargs
+	}
+	varname, err := strArg(name, args, 0)
+	if err != nil {
+		return SexpNull, err
+	}
+
+	switch name {
+	case "getenv":
+		val, found := os.LookupEnv(varname)
+		if found {
+			return SexpStr{S: val}, nil
+		}
+		if len(args) == 2 {
+			return args[1], nil
+		}
+		return SexpNull, nil
+	case "setenv":
+		if len(args) != 2 {
+			return SexpNull, WrongNargs
+		}
+		val, err := strArg(name, args, 1)
+		if err != nil {
+			return SexpNull, err
+		}
+		err = os.Setenv(varname, val)
+		if err != nil {
+			return SexpError{err}, nil
+		}
+	case "unsetenv":
+		if len(args) != 1 {
+			return SexpNull, WrongNargs
+		}
+		err = os.Unsetenv(varname)
+		if err != nil {
+			return SexpError{err}, nil
+		}
+	}
+	return SexpNull, nil
+}
+
+// (environ) returns a hash of every environment variable to its value.
+func EnvironFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) != 0 {
+		return SexpNull, WrongNargs
+	}
+	hash, err := MakeHash(nil, "hash", env)
+	if err != nil {
+		return SexpNull, err
+	}
+	for _, kv := range os.Environ() {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			// windows has entries like "=C:=C:\"; skip them.
+			continue
+		}
+		err = hash.HashSet(SexpStr{S: kv[:i]}, SexpStr{S: kv[i+1:]})
+		if err != nil {
+			return SexpNull, err
+		}
+	}
+	return hash, nil
+}
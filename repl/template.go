@@ -0,0 +1,212 @@
+package zygo
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"text/template"
+)
+
+// SexpTemplate is a parsed text/template, from template-parse
+// or template-load.
+type SexpTemplate template.Template
+
+func (t *SexpTemplate) SexpString() string {
+	return fmt.Sprintf(`(template %q)`, (*template.Template)(t).Name())
+}
+
+func (t *SexpTemplate) Type() *RegisteredType {
+	return nil
+}
+
+func templateKeyName(key Sexp) string {
+	switch k := key.(type) {
+	case SexpSymbol:
+		return k.name
+	case SexpStr:
+		return k.S
+	}
+	return key.SexpString()
+}
+
+// templateValue converts x into something text/template can walk:
+// hashes and pmaps become maps from key name to value, so that
+// {{.speed}} reads a field, and sequences become slices. A record
+// backed by a Go struct also answers to the Go field names, as
+// in {{.Speed}}.
+func templateValue(x Sexp) interface{} {
+	switch t := x.(type) {
+	case SexpSentinel:
+		if t == SexpNull {
+			return nil
+		}
+	case *SexpHash:
+		m := make(map[string]interface{}, t.NumKeys)
+		for _, key := range t.KeyOrder {
+			val, err := t.HashGet(nil, key)
+			if err != nil {
+				continue
+			}
+			name := templateKeyName(key)
+			m[name] = templateValue(val)
+			if det, found := t.JsonTagMap[name]; found && det.FieldName != "" {
+				if _, taken := m[det.FieldName]; !taken {
+					m[det.FieldName] = m[name]
+				}
+			}
+		}
+		return m
+	case *SexpPMap:
+		m := make(map[string]interface{})
+		for _, pair := range t.Pairs() {
+			m[templateKeyName(pair.Head)] = templateValue(pair.Tail)
+		}
+		return m
+	case *SexpArray, SexpPair, *SexpPVec, *SexpSet:
+		elems, err := SeqToArray(x)
+		if err != nil {
+			return x.SexpString()
+		}
+		res := make([]interface{}, len(elems))
+		for i, e := range elems {
+			res[i] = templateValue(e)
+		}
+		return res
+	case SexpSymbol:
+		return t.name
+	}
+	return unboxSexp(x)
+}
+
+// templateFuncs turns a hash of name -> zygo function into a
+// template.FuncMap. Arguments arrive as Go values and are
+// converted back with GoToSexp.
+func templateFuncs(env *Glisp, name string, x Sexp) (template.FuncMap, error) {
+	hash, isHash := x.(*SexpHash)
+	if !isHash {
+		return nil, fmt.Errorf("%s: functions must be given as a hash of "+
+			"name to function, but we had %T", name, x)
+	}
+	funcs := template.FuncMap{}
+	for _, key := range hash.KeyOrder {
+		val, err := hash.HashGet(nil, key)
+		if err != nil {
+			return nil, err
+		}
+		fun, isFun := val.(*SexpFunction)
+		if !isFun {
+			return nil, fmt.Errorf("%s: template function '%s' is not a function",
+				name, templateKeyName(key))
+		}
+		funcs[templateKeyName(key)] = func(args ...interface{}) (interface{}, error) {
+			sargs := make([]Sexp, len(args))
+			for i, a := range args {
+				if s, isSexp := a.(Sexp); isSexp {
+					sargs[i] = s
+					continue
+				}
+				s, err := GoToSexp(a, env)
+				if err != nil {
+					return nil, err
+				}
+				sargs[i] = s
+			}
+			res, err := env.Apply(fun, sargs)
+			if err != nil {
+				return nil, err
+			}
+			return templateValue(res), nil
+		}
+	}
+	return funcs, nil
+}
+
+func parseTemplate(env *Glisp, name string, tmplName string, text string, args []Sexp) (*SexpTemplate, error) {
+	t := template.New(tmplName)
+	if len(args) > 0 {
+		funcs, err := templateFuncs(env, name, args[0])
+		if err != nil {
+			return nil, err
+		}
+		t = t.Funcs(funcs)
+	}
+	t, err := t.Parse(text)
+	if err != nil {
+		// parse errors read "template: name:line: ...", giving
+		// the position within the template.
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return (*SexpTemplate)(t), nil
+}
+
+// (template-parse text [funcs]) parses a template; funcs is an
+// optional hash of names to zygo functions callable from it.
+func TemplateParseFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return SexpNull, WrongNargs
+	}
+	text, isStr := args[0].(SexpStr)
+	if !isStr {
+		return SexpNull, fmt.Errorf("%s: template text must be a string", name)
+	}
+	t, err := parseTemplate(env, name, "template", text.S, args[1:])
+	if err != nil {
+		return SexpNull, err
+	}
+	return t, nil
+}
+
+// (template-load path [funcs]) parses the template in a file.
+// Errors name the file.
+func TemplateLoadFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return SexpNull, WrongNargs
+	}
+	path, isStr := args[0].(SexpStr)
+	if !isStr {
+		return SexpNull, fmt.Errorf("%s: path must be a string", name)
+	}
+	text, err := ioutil.ReadFile(path.S)
+	if err != nil {
+		return SexpNull, err
+	}
+	t, err := parseTemplate(env, name, path.S, string(text), args[1:])
+	if err != nil {
+		return SexpNull, err
+	}
+	return t, nil
+}
+
+// (template-render tmpl data) executes tmpl with data as dot and
+// returns the output. tmpl is a parsed template or a string; for a
+// string, a hash of template functions may follow data.
+func TemplateRenderFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return SexpNull, WrongNargs
+	}
+	var t *template.Template
+	switch tmpl := args[0].(type) {
+	case *SexpTemplate:
+		if len(args) == 3 {
+			return SexpNull, fmt.Errorf("%s: functions must be given to "+
+				"template-parse, before the template is parsed", name)
+		}
+		t = (*template.Template)(tmpl)
+	case SexpStr:
+		st, err := parseTemplate(env, name, "template", tmpl.S, args[2:])
+		if err != nil {
+			return SexpNull, err
+		}
+		t = (*template.Template)(st)
+	default:
+		return SexpNull, fmt.Errorf("%s: first argument must be a template "+
+			"or string, but we had %T", name, args[0])
+	}
+
+	var out bytes.Buffer
+	err := t.Execute(&out, templateValue(args[1]))
+	if err != nil {
+		return SexpNull, fmt.Errorf("%s: %v", name, err)
+	}
+	return SexpStr{S: out.String()}, nil
+}
@@ -0,0 +1,348 @@
+package zygo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/scanner"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// zygoImportPath is where generated code finds GoStructRegistry.
+const zygoImportPath = "github.com/glycerine/zygomys/repl"
+
+// GoSourceGen writes Go type declarations for structs declared with
+// (struct ...), so that a schema prototyped at the REPL can become
+// Go code. Each struct is registered in GoStructRegistry under its
+// zygo name, so scripts using it keep working against the Go type.
+type GoSourceGen struct {
+	// Package is the package clause of the generated file.
+	Package string
+
+	defs    []*RecordDefn
+	seen    map[string]bool
+	imports map[string]string
+}
+
+func NewGoSourceGen(pkg string) *GoSourceGen {
+	return &GoSourceGen{
+		Package: pkg,
+		seen:    make(map[string]bool),
+		imports: make(map[string]string),
+	}
+}
+
+// Add queues the struct rt for generation, along with any
+// other zygo structs its fields refer to.
+func (g *GoSourceGen) Add(rt *RegisteredType) error {
+	if rt.UserStructDefn == nil {
+		return fmt.Errorf("'%s' is not a struct declared with (struct ...)", rt.DisplayAs)
+	}
+	defn := rt.UserStructDefn
+	if g.seen[defn.Name] {
+		return nil
+	}
+	g.seen[defn.Name] = true
+	g.defs = append(g.defs, defn)
+	for _, f := range defn.Fields {
+		name := f.KeyOrder[0].(SexpSymbol).name
+		for ft := defn.FieldType[name]; ft != nil; ft = ft.Elem {
+			if ft.UserStructDefn != nil {
+				if err := g.Add(ft); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// AddDeclared queues every struct declared in env, in the order
+// they were declared.
+func (g *GoSourceGen) AddDeclared(env *Glisp) error {
+	r := env.Registry()
+	for _, name := range r.Names() {
+		rt := r.Lookup(name)
+		if rt == nil || rt.UserStructDefn == nil || rt.RegisteredName != name {
+			continue
+		}
+		if err := g.Add(rt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Source returns the gofmt-ed Go file for the structs added so far.
+func (g *GoSourceGen) Source() ([]byte, error) {
+	var body bytes.Buffer
+	fromType := make(map[string]string) // Go type name -> zygo struct name
+	for _, defn := range g.defs {
+		goName := GoExportedName(defn.Name)
+		if prev, dup := fromType[goName]; dup {
+			return nil, fmt.Errorf("structs %s and %s would both be Go type %s",
+				prev, defn.Name, goName)
+		}
+		fromType[goName] = defn.Name
+		if err := g.writeStruct(&body, defn); err != nil {
+			return nil, err
+		}
+	}
+	g.writeRegistration(&body)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by zygo gen-go. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&out, "package %s\n\n", g.Package)
+	if len(g.imports) > 0 {
+		paths := make([]string, 0, len(g.imports))
+		for p := range g.imports {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		fmt.Fprintf(&out, "import (\n")
+		for _, p := range paths {
+			if name := g.imports[p]; name != path.Base(p) {
+				fmt.Fprintf(&out, "\t%s %q\n", name, p)
+			} else {
+				fmt.Fprintf(&out, "\t%q\n", p)
+			}
+		}
+		fmt.Fprintf(&out, ")\n\n")
+	}
+	out.Write(body.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated Go source does not parse: %v%s", err, badLine(out.Bytes(), err))
+	}
+	return src, nil
+}
+
+// badLine quotes the line of src that a parse error points to.
+func badLine(src []byte, err error) string {
+	var list scanner.ErrorList
+	if !errors.As(err, &list) || len(list) == 0 {
+		return ""
+	}
+	lines := strings.Split(string(src), "\n")
+	n := list[0].Pos.Line
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return fmt.Sprintf("; line %d is: %s", n, strings.TrimSpace(lines[n-1]))
+}
+
+func (g *GoSourceGen) writeStruct(w *bytes.Buffer, defn *RecordDefn) error {
+	fmt.Fprintf(w, "type %s struct {\n", GoExportedName(defn.Name))
+	fromField := make(map[string]string) // Go field name -> zygo field name
+	for _, f := range defn.Fields {
+		h := (*SexpHash)(f)
+		name := h.KeyOrder[0].(SexpSymbol).name
+		ty, err := g.goType(defn.FieldType[name])
+		if err != nil {
+			return fmt.Errorf("struct %s, field %s: %v", defn.Name, name, err)
+		}
+		tags := fmt.Sprintf(`json:"%s" msg:"%s"`, lowerFirst(name), lowerFirst(name))
+		if s, isStr := fieldOption(h, "gotags").(SexpStr); isStr {
+			tags = s.S
+		}
+		comment := ""
+		if IsTruthy(fieldOption(h, "deprecated")) {
+			comment = " // Deprecated."
+		}
+		goName := GoExportedName(name)
+		fld := goName + " "
+		if IsTruthy(fieldOption(h, "embed")) && embeddedDefn(defn.FieldType[name]) != nil {
+			// an embedded field is named after its type
+			goName = strings.TrimPrefix(ty, "*")
+			fld = ""
+		}
+		if prev, dup := fromField[goName]; dup {
+			return fmt.Errorf("struct %s: fields %s and %s would both be Go field %s",
+				defn.Name, prev, name, goName)
+		}
+		fromField[goName] = name
+		fmt.Fprintf(w, "\t%s%s `%s`%s\n", fld, ty, tags, comment)
+	}
+	fmt.Fprintf(w, "}\n\n")
+	return nil
+}
+
+// fieldOption returns the value of a (field ...) option such as
+// gotags:, or SexpNull if the field does not give it.
+func fieldOption(h *SexpHash, opt string) Sexp {
+	for _, key := range h.KeyOrder[1:] {
+		if sym, isSym := key.(SexpSymbol); isSym && sym.name == opt {
+			val, err := h.HashGet(nil, key)
+			if err == nil {
+				return val
+			}
+		}
+	}
+	return SexpNull
+}
+
+func (g *GoSourceGen) writeRegistration(w *bytes.Buffer) {
+	if len(g.defs) == 0 {
+		return
+	}
+	q := ""
+	if g.Package != "zygo" {
+		g.imports[zygoImportPath] = "zygo"
+		q = "zygo."
+	}
+	fmt.Fprintf(w, "func init() {\n")
+	for _, defn := range g.defs {
+		fmt.Fprintf(w, "\t%sGoStructRegistry.RegisterUserdef(%q, &%sRegisteredType{GenDefMap: true, "+
+			"Factory: func(env *%sGlisp) (interface{}, error) {\n\t\treturn &%s{}, nil\n\t}}, true)\n",
+			q, defn.Name, q, q, GoExportedName(defn.Name))
+	}
+	fmt.Fprintf(w, "}\n")
+}
+
+// goType spells rt as a Go type expression, noting any import it needs.
+func (g *GoSourceGen) goType(rt *RegisteredType) (string, error) {
+	if rt == nil {
+		return "", fmt.Errorf("no type")
+	}
+	if rt.UserStructDefn != nil {
+		return GoExportedName(rt.UserStructDefn.Name), nil
+	}
+	if rt.Elem != nil {
+		elem, err := g.goType(rt.Elem)
+		if err != nil {
+			return "", err
+		}
+		switch rt.Kind {
+		case reflect.Ptr:
+			return "*" + elem, nil
+		case reflect.Slice:
+			return "[]" + elem, nil
+		case reflect.Array:
+			return fmt.Sprintf("[%d]%s", rt.ArrayLen, elem), nil
+		}
+	}
+	t, ok := goTypeFor(rt)
+	if !ok {
+		return "", fmt.Errorf("type '%s' has no Go equivalent", rt.ShortName())
+	}
+	pkg := t.PkgPath()
+	if pkg == zygoImportPath && g.Package == "zygo" {
+		// our own types need no import inside package zygo
+		return t.Name(), nil
+	}
+	if pkg != "" {
+		g.imports[pkg] = strings.SplitN(t.String(), ".", 2)[0]
+	}
+	return t.String(), nil
+}
+
+// GoExportedName turns a zygo name such as my-point into the
+// exported Go identifier MyPoint. Runes that cannot be in a Go
+// identifier start a new word and are dropped, except ? which
+// becomes P, so that empty? and empty give Go names EmptyP and Empty.
+// A name that would not start with an upper case letter gets an X in
+// front.
+func GoExportedName(name string) string {
+	s := ""
+	up := true
+	for _, r := range name {
+		switch {
+		case r == '?':
+			s += "P"
+			up = true
+		case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			if up {
+				r = unicode.ToUpper(r)
+				up = false
+			}
+			s += string(r)
+		default:
+			up = true
+		}
+	}
+	if first, _ := utf8.DecodeRuneInString(s); !unicode.IsUpper(first) {
+		s = "X" + s
+	}
+	return s
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// (togo-source 'Car ...) returns Go source declaring the named
+// structs, the structs they refer to, and their GoStructRegistry
+// registration. A leading string names the package; it defaults
+// to main.
+func TogoSourceFunction(env *Glisp, name string, args []Sexp) (Sexp, error) {
+	pkg := "main"
+	if len(args) > 0 {
+		if s, isStr := args[0].(SexpStr); isStr {
+			pkg = s.S
+			args = args[1:]
+		}
+	}
+	if len(args) == 0 {
+		return SexpNull, WrongNargs
+	}
+	g := NewGoSourceGen(pkg)
+	for _, a := range args {
+		var rt *RegisteredType
+		switch x := a.(type) {
+		case *RegisteredType:
+			rt = x
+		case SexpSymbol:
+			rt = env.Registry().Lookup(x.name)
+			if rt == nil {
+				return SexpNull, fmt.Errorf("%s: unknown struct '%s'", name, x.name)
+			}
+		default:
+			return SexpNull, fmt.Errorf("%s: arguments must be struct names, but we had %T / val = '%s'",
+				name, a, a.SexpString())
+		}
+		if err := g.Add(rt); err != nil {
+			return SexpNull, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	src, err := g.Source()
+	if err != nil {
+		return SexpNull, fmt.Errorf("%s: %v", name, err)
+	}
+	return SexpStr{S: string(src)}, nil
+}
+
+// GenGoFile runs the script fname and returns Go source, in package
+// pkg, for the structs it declares. It is zygo gen-go.
+func GenGoFile(fname string, pkg string) ([]byte, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	env := NewGlisp()
+	defer env.parser.Stop()
+	env.StandardSetup()
+	if err = env.LoadFile(file); err != nil {
+		return nil, err
+	}
+	if _, err = env.Run(); err != nil {
+		return nil, err
+	}
+	g := NewGoSourceGen(pkg)
+	if err = g.AddDeclared(env); err != nil {
+		return nil, err
+	}
+	return g.Source()
+}
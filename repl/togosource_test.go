@@ -0,0 +1,110 @@
+package zygo
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test040GenGoDeclaresEveryStructInTheScript(t *testing.T) {
+
+	cv.Convey(`Given a script declaring structs, GenGoFile should emit`+
+		` a Go file that parses, declares each struct once in`+
+		` declaration order, and registers each under its zygo name.`, t, func() {
+
+		dir, err := ioutil.TempDir("", "gen-go")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		fname := filepath.Join(dir, "schema.zy")
+		panicOn(ioutil.WriteFile(fname, []byte(`
+(struct point [(field X: float64) (field Y: float64)])
+(struct path-seg [(field From: point) (field To: (* point))])
+(struct route [(field Name: string) (field Legs: ([]path-seg)) (field Next: (* route))])
+(def r (route Name: "home"))
+`), 0644))
+
+		src, err := GenGoFile(fname, "routes")
+		panicOn(err)
+
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "routes.go", src, 0)
+		panicOn(err)
+		cv.So(f.Name.Name, cv.ShouldEqual, "routes")
+		conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+		_, err = conf.Check("routes", fset, []*ast.File{f}, nil)
+		cv.So(err, cv.ShouldBeNil)
+
+		var types []string
+		fields := map[string]int{}
+		for _, decl := range f.Decls {
+			gd, isGen := decl.(*ast.GenDecl)
+			if !isGen || gd.Tok != token.TYPE {
+				continue
+			}
+			ts := gd.Specs[0].(*ast.TypeSpec)
+			types = append(types, ts.Name.Name)
+			fields[ts.Name.Name] = len(ts.Type.(*ast.StructType).Fields.List)
+		}
+		cv.So(types, cv.ShouldResemble, []string{"Point", "PathSeg", "Route"})
+		cv.So(fields, cv.ShouldResemble, map[string]int{"Point": 2, "PathSeg": 2, "Route": 3})
+		cv.So(string(src), cv.ShouldContainSubstring, "Legs []PathSeg `json:\"legs\" msg:\"legs\"`")
+		cv.So(string(src), cv.ShouldContainSubstring, `RegisterUserdef("path-seg", `)
+	})
+}
+
+func Test050GoNamesAreValidAndDistinct(t *testing.T) {
+
+	cv.Convey(`GoExportedName should give valid exported Go identifiers`+
+		` for any zygo name, and the generator should refuse names`+
+		` that collide in Go, quoting only the line at fault.`, t, func() {
+
+		cv.So(GoExportedName("my-point"), cv.ShouldEqual, "MyPoint")
+		cv.So(GoExportedName("empty?"), cv.ShouldEqual, "EmptyP")
+		cv.So(GoExportedName("set!"), cv.ShouldEqual, "Set")
+		cv.So(GoExportedName("*earmuffs*"), cv.ShouldEqual, "Earmuffs")
+		cv.So(GoExportedName("a.b/c"), cv.ShouldEqual, "ABC")
+		cv.So(GoExportedName("2d"), cv.ShouldEqual, "X2d")
+		cv.So(GoExportedName("*"), cv.ShouldEqual, "X")
+		cv.So(GoExportedName("élan"), cv.ShouldEqual, "Élan")
+
+		env := NewGlisp()
+		defer env.parser.Stop()
+		env.StandardSetup()
+
+		x, err := env.EvalString(`(struct flags [(field done?: bool) (field *count*: int64)])
+            (togo-source "main" 'flags)`)
+		panicOn(err)
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "flags.go", x.(SexpStr).S, 0)
+		panicOn(err)
+		conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+		_, err = conf.Check("main", fset, []*ast.File{f}, nil)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(x.(SexpStr).S, cv.ShouldContainSubstring, "DoneP ")
+		cv.So(x.(SexpStr).S, cv.ShouldContainSubstring, "Count ")
+
+		_, err = env.EvalString(`(struct clash [(field my-name: string) (field myName: string)])
+            (togo-source 'clash)`)
+		env.Clear()
+		cv.So(err.Error(), cv.ShouldEqual, "Error calling 'togo-source': togo-source: "+
+			"struct clash: fields my-name and myName would both be Go field MyName")
+
+		_, err = env.EvalString(`(struct my-car [(field Id: int64)]) (struct myCar [(field Other: my-car)])
+            (togo-source 'myCar)`)
+		env.Clear()
+		cv.So(err.Error(), cv.ShouldEqual, "Error calling 'togo-source': togo-source: "+
+			"structs myCar and my-car would both be Go type MyCar")
+
+		_, err = NewGoSourceGen("bad pkg").Source()
+		cv.So(err.Error(), cv.ShouldEqual, "generated Go source does not parse: "+
+			"3:13: expected ';', found pkg; line 3 is: package bad pkg")
+	})
+}
@@ -124,6 +124,8 @@ func IsEmpty(expr Sexp) bool {
 		return len(e.Val) == 0
 	case *SexpHash:
 		return HashIsEmpty(e)
+	case *SexpSet:
+		return e.NumKeys == 0
 	}
 
 	return false
@@ -137,6 +139,11 @@ func IsFunc(expr Sexp) bool {
 	return false
 }
 
+func IsError(expr Sexp) bool {
+	_, isErr := expr.(SexpError)
+	return isErr
+}
+
 func TypeOf(expr Sexp) SexpStr {
 	v := ""
 	switch e := expr.(type) {
@@ -166,6 +173,30 @@ func TypeOf(expr Sexp) SexpStr {
 		v = "time.Time"
 	case *RegisteredType:
 		v = "regtype"
+	case *SexpPVec:
+		v = "pvec"
+	case *SexpPMap:
+		v = "pmap"
+	case *SexpSet:
+		v = "set"
+	case *SexpLazySeq:
+		v = "lazy-seq"
+	case *SexpIterator:
+		v = "iterator"
+	case *SexpTemplate:
+		v = "template"
+	case *SexpPort:
+		v = "port"
+	case *SexpDecoder:
+		v = "decoder"
+	case *SexpEncoder:
+		v = "encoder"
+	case SexpError:
+		v = "error"
+	case *SexpProcess:
+		v = "process"
+	case *SexpModule:
+		v = "module"
 	case *SexpPointer:
 		v = e.MyType.RegisteredName
 	case SexpReflect:
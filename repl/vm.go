@@ -199,6 +199,11 @@ func (p UpdateInstr) Execute(env *Glisp) error {
 		// instead of (set)
 		return env.LexicalBindSymbol(p.sym, expr)
 	}
+	if scope == nil {
+		// m.name found in an imported module
+		return fmt.Errorf("cannot set '%s': a module's definitions "+
+			"are read-only outside it", p.sym.name)
+	}
 
 	// found up the stack, so (set)
 	return scope.UpdateSymbolInScope(p.sym, expr)
@@ -500,9 +505,15 @@ func (b BindlistInstr) Execute(env *Glisp) error {
 		return err
 	}
 
-	arr, err := ListToArray(expr)
-	if err != nil {
-		return err
+	var arr []Sexp
+	switch e := expr.(type) {
+	case *SexpArray:
+		arr = e.Val
+	default:
+		arr, err = ListToArray(expr)
+		if err != nil {
+			return err
+		}
 	}
 
 	nsym := len(b.syms)
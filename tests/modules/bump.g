@@ -0,0 +1,2 @@
+;; sourced by tests/modules.zy from $ZYGOPATH
+(def a (+ a 1))